@@ -0,0 +1,450 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/schema"
+	"github.com/axetroy/hooker/internal/app/store"
+	"github.com/kataras/iris/v12/context"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	CookieName = "hooker_token"     // 视图使用的 cookie
+	SessionTTL = time.Hour * 24 * 7 // 登录会话的有效期
+)
+
+var (
+	usernameReg = regexp.MustCompile(`^[\w\-.]{3,32}$`)
+
+	ErrInvalidAccount = schema.NewError(http.StatusUnauthorized, "invalid username or password")
+
+	// 用户不存在时用于比较的哈希, 避免通过响应时间判断用户是否存在
+	dummyHash, _ = bcrypt.GenerateFromPassword([]byte("hooker"), bcrypt.DefaultCost)
+)
+
+type SignUpParams struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Admin    bool   `json:"admin"`
+}
+
+type SignInParams struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type SignInResponse struct {
+	Token     string    `json:"token"`
+	ExpiredAt time.Time `json:"expired_at"`
+	User      User      `json:"user"`
+}
+
+// 接口中返回的用户信息, 不包含密码
+type User struct {
+	Id        string    `json:"id"`
+	Username  string    `json:"username"`
+	Admin     bool      `json:"admin"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func public(user model.User) User {
+	return User{
+		Id:        user.Id,
+		Username:  user.Username,
+		Admin:     user.Admin,
+		CreatedAt: user.CreatedAt,
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+func findUser(username string) (*model.User, error) {
+	users, err := store.Default.ListUsers()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range users {
+		if strings.EqualFold(u.Username, username) {
+			user := u
+			return &user, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// 创建用户
+func createUser(username string, password string, admin bool) (*model.User, error) {
+	if !usernameReg.MatchString(username) {
+		return nil, schema.NewError(http.StatusBadRequest, "invalid username, only 3-32 letters, numbers, '_', '-' and '.' are allowed")
+	}
+
+	if len(password) < 8 {
+		return nil, schema.NewError(http.StatusBadRequest, "password must be at least 8 characters")
+	}
+
+	if exist, err := findUser(username); err != nil {
+		return nil, err
+	} else if exist != nil {
+		return nil, schema.NewError(http.StatusConflict, "username already exists")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	user := model.User{
+		Username: username,
+		Password: string(hash),
+		Admin:    admin,
+	}
+
+	if err := store.Default.CreateUser(&user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// 首次启动时创建管理员帐号, 没有指定密码则随机生成并且输出到日志中
+func Bootstrap(username string, password string) error {
+	users, err := store.Default.ListUsers()
+
+	if err != nil {
+		return err
+	}
+
+	if len(users) > 0 {
+		return nil
+	}
+
+	generated := password == ""
+
+	if generated {
+		password = randomString(12)
+	}
+
+	if _, err := createUser(username, password, true); err != nil {
+		return err
+	}
+
+	if generated {
+		log.Printf("Create admin account '%s' with password '%s', please keep it safe\n", username, password)
+	} else {
+		log.Printf("Create admin account '%s'\n", username)
+	}
+
+	return nil
+}
+
+// 从请求中获取 token, 接口使用 Authorization: Bearer xxx
+func tokenFromHeader(ctx context.Context) string {
+	authorization := ctx.GetHeader("Authorization")
+
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimPrefix(authorization, "Bearer ")
+	}
+
+	return ""
+}
+
+// 从请求中获取只读接口的 token, 从代码托管平台的部署状态链接打开时无法设置请求头, 所以同时支持 cookie
+func tokenFromCookie(ctx context.Context) string {
+	if token := tokenFromHeader(ctx); token != "" {
+		return token
+	}
+
+	return ctx.GetCookie(CookieName)
+}
+
+// 从请求中获取实时日志的 token, 浏览器的 EventSource 无法设置请求头, 所以同时支持 cookie 和 URL 参数 token
+func tokenFromStream(ctx context.Context) string {
+	if token := tokenFromCookie(ctx); token != "" {
+		return token
+	}
+
+	return ctx.URLParam("token")
+}
+
+// 删除过期的登录会话, 过期的 token 没有再次使用时会话不会被删除
+func PruneSessions() error {
+	sessions, err := store.Default.ListSessions()
+
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	for _, session := range sessions {
+		if now.After(session.ExpiredAt) {
+			if err := store.Default.DeleteSession(session.Id); err != nil && errors.Cause(err) != store.ErrNotFound {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// 根据 token 获取登录的用户
+func authenticate(token string) (*model.User, error) {
+	if token == "" {
+		return nil, schema.ErrUnauthorized
+	}
+
+	session, err := store.Default.GetSession(hashToken(token))
+
+	if err != nil {
+		if errors.Cause(err) == store.ErrNotFound {
+			return nil, schema.ErrUnauthorized
+		}
+
+		return nil, err
+	}
+
+	if time.Now().After(session.ExpiredAt) {
+		_ = store.Default.DeleteSession(session.Id)
+		return nil, schema.ErrUnauthorized
+	}
+
+	user, err := store.Default.GetUser(session.UserId)
+
+	if err != nil {
+		if errors.Cause(err) == store.ErrNotFound {
+			return nil, schema.ErrUnauthorized
+		}
+
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// 获取当前登录的用户, 只能在 Require 之后使用
+func CurrentUser(ctx context.Context) *model.User {
+	if user, ok := ctx.Values().Get("user").(*model.User); ok {
+		return user
+	}
+
+	return nil
+}
+
+// 接口的认证中间件
+func Require(ctx context.Context) {
+	user, err := authenticate(tokenFromHeader(ctx))
+
+	if err != nil {
+		schema.JSON(ctx, nil, nil, err)
+		ctx.StopExecution()
+		return
+	}
+
+	ctx.Values().Set("user", user)
+	ctx.Next()
+}
+
+// 部署记录详情的认证中间件, 在浏览器中登录之后可以直接打开部署状态和评论中的链接
+func RequireCookie(ctx context.Context) {
+	user, err := authenticate(tokenFromCookie(ctx))
+
+	if err != nil {
+		schema.JSON(ctx, nil, nil, err)
+		ctx.StopExecution()
+		return
+	}
+
+	ctx.Values().Set("user", user)
+	ctx.Next()
+}
+
+// 实时日志 (Server-Sent Events) 的认证中间件
+func RequireStream(ctx context.Context) {
+	user, err := authenticate(tokenFromStream(ctx))
+
+	if err != nil {
+		schema.JSON(ctx, nil, nil, err)
+		ctx.StopExecution()
+		return
+	}
+
+	ctx.Values().Set("user", user)
+	ctx.Next()
+}
+
+// 视图的认证中间件, 未登录则跳转到登录页
+func RequireView(ctx context.Context) {
+	user, err := authenticate(ctx.GetCookie(CookieName))
+
+	if err != nil {
+		ctx.Redirect("/login", http.StatusFound)
+		ctx.StopExecution()
+		return
+	}
+
+	ctx.Values().Set("user", user)
+	ctx.Next()
+}
+
+// 注册帐号, 只有管理员可以注册新用户
+func RegisterRouter(ctx context.Context) {
+	var (
+		err    error
+		data   interface{}
+		params SignUpParams
+	)
+
+	defer func() {
+		schema.JSON(ctx, data, nil, err)
+	}()
+
+	current, err := authenticate(tokenFromHeader(ctx))
+
+	if err != nil {
+		return
+	}
+
+	if !current.Admin {
+		err = schema.ErrForbidden
+		return
+	}
+
+	if err = ctx.ReadJSON(&params); err != nil {
+		err = schema.NewError(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := createUser(params.Username, params.Password, params.Admin)
+
+	if err != nil {
+		return
+	}
+
+	data = public(*user)
+}
+
+// 登录帐号
+func LoginRouter(ctx context.Context) {
+	var (
+		err    error
+		data   interface{}
+		params SignInParams
+	)
+
+	defer func() {
+		schema.JSON(ctx, data, nil, err)
+	}()
+
+	if err = ctx.ReadJSON(&params); err != nil {
+		err = schema.NewError(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := findUser(params.Username)
+
+	if err != nil {
+		return
+	}
+
+	if user == nil {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(params.Password))
+		err = ErrInvalidAccount
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(params.Password)) != nil {
+		err = ErrInvalidAccount
+		return
+	}
+
+	if err := PruneSessions(); err != nil {
+		log.Printf("%+v\n", err)
+	}
+
+	token := randomString(32)
+
+	session := model.Session{
+		Id:        hashToken(token),
+		UserId:    user.Id,
+		ExpiredAt: time.Now().Add(SessionTTL),
+	}
+
+	if err = store.Default.CreateSession(&session); err != nil {
+		return
+	}
+
+	ctx.SetCookie(&http.Cookie{
+		Name:     CookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  session.ExpiredAt,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	data = SignInResponse{
+		Token:     token,
+		ExpiredAt: session.ExpiredAt,
+		User:      public(*user),
+	}
+}
+
+// 退出登录, 删除 token 对应的会话并且清除 cookie. 视图使用 cookie, 接口使用 Authorization: Bearer xxx
+func LogoutRouter(ctx context.Context) {
+	var err error
+
+	defer func() {
+		schema.JSON(ctx, nil, nil, err)
+	}()
+
+	token := tokenFromHeader(ctx)
+
+	if token == "" {
+		token = ctx.GetCookie(CookieName)
+	}
+
+	ctx.SetCookie(&http.Cookie{
+		Name:     CookieName,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if token == "" {
+		return
+	}
+
+	if err = store.Default.DeleteSession(hashToken(token)); errors.Cause(err) == store.ErrNotFound {
+		err = nil
+	}
+}
@@ -0,0 +1,362 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/schema"
+	"github.com/axetroy/hooker/internal/app/store"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func setup(t *testing.T) *iris.Application {
+	db, err := store.NewFileStore(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origin := store.Default
+
+	t.Cleanup(func() {
+		store.Default = origin
+	})
+
+	store.Default = db
+
+	app := iris.New()
+
+	app.Post("/v1/auth/signup", RegisterRouter)
+	app.Post("/v1/auth/login", LoginRouter)
+	app.Post("/v1/auth/logout", LogoutRouter)
+	app.Get("/v1/me", Require, func(ctx context.Context) {
+		schema.JSON(ctx, public(*CurrentUser(ctx)), nil, nil)
+	})
+	app.Get("/v1/log", RequireCookie, func(ctx context.Context) {
+		schema.JSON(ctx, public(*CurrentUser(ctx)), nil, nil)
+	})
+	app.Get("/v1/stream", RequireStream, func(ctx context.Context) {
+		schema.JSON(ctx, public(*CurrentUser(ctx)), nil, nil)
+	})
+
+	if err := app.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	return app
+}
+
+func request(app *iris.Application, method string, url string, token string, body interface{}) *httptest.ResponseRecorder {
+	var b []byte
+
+	if body != nil {
+		b, _ = json.Marshal(body)
+	}
+
+	req := httptest.NewRequest(method, url, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+
+	app.ServeHTTP(w, req)
+
+	return w
+}
+
+func login(t *testing.T, app *iris.Application, username string, password string) (int, SignInResponse) {
+	w := request(app, http.MethodPost, "/v1/auth/login", "", SignInParams{Username: username, Password: password})
+
+	var res struct {
+		Data SignInResponse `json:"data"`
+	}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+
+	return w.Code, res.Data
+}
+
+func TestBootstrap(t *testing.T) {
+	setup(t)
+
+	if err := Bootstrap("admin", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 已经有用户时不再创建
+	if err := Bootstrap("other", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := store.Default.ListUsers()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users) != 1 || users[0].Username != "admin" || !users[0].Admin {
+		t.Fatalf("users = %+v, want only admin", users)
+	}
+
+	// 密码使用 bcrypt 保存
+	if users[0].Password == "password" || bcrypt.CompareHashAndPassword([]byte(users[0].Password), []byte("password")) != nil {
+		t.Errorf("password = %s, want bcrypt hash", users[0].Password)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	app := setup(t)
+
+	if err := Bootstrap("admin", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     int
+	}{
+		{name: "valid account", username: "admin", password: "password", want: http.StatusOK},
+		{name: "case insensitive username", username: "ADMIN", password: "password", want: http.StatusOK},
+		{name: "wrong password", username: "admin", password: "wrong-password", want: http.StatusUnauthorized},
+		{name: "unknown user", username: "nobody", password: "password", want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, res := login(t, app, tt.username, tt.password)
+
+			if code != tt.want {
+				t.Fatalf("login status = %d, want %d", code, tt.want)
+			}
+
+			if code == http.StatusOK && (res.Token == "" || res.User.Username != "admin") {
+				t.Errorf("login response = %+v", res)
+			}
+		})
+	}
+}
+
+func TestSession(t *testing.T) {
+	app := setup(t)
+
+	if err := Bootstrap("admin", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, res := login(t, app, "admin", "password")
+
+	if w := request(app, http.MethodGet, "/v1/me", res.Token, nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"username":"admin"`) {
+		t.Errorf("GET /v1/me = %d %s", w.Code, w.Body)
+	}
+
+	// 只保存 token 的哈希
+	if _, err := store.Default.GetSession(res.Token); err == nil {
+		t.Error("session stored with the plain token")
+	}
+
+	for _, token := range []string{"", "invalid-token"} {
+		if w := request(app, http.MethodGet, "/v1/me", token, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("GET /v1/me with token %q = %d, want 401", token, w.Code)
+		}
+	}
+
+	// 实时日志支持 cookie 和 URL 参数 token, 接口只支持请求头
+	if w := request(app, http.MethodGet, "/v1/stream?token="+res.Token, "", nil); w.Code != http.StatusOK {
+		t.Errorf("GET /v1/stream with query token = %d, want 200", w.Code)
+	}
+
+	if w := request(app, http.MethodGet, "/v1/me?token="+res.Token, "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /v1/me with query token = %d, want 401", w.Code)
+	}
+
+	// 部署记录详情支持 cookie, 从代码托管平台的链接打开
+	req := httptest.NewRequest(http.MethodGet, "/v1/log", nil)
+	req.AddCookie(&http.Cookie{Name: CookieName, Value: res.Token})
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("GET /v1/log with cookie = %d, want 200", w.Code)
+	}
+
+	if w := request(app, http.MethodGet, "/v1/log", res.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("GET /v1/log with header = %d, want 200", w.Code)
+	}
+
+	if w := request(app, http.MethodGet, "/v1/log?token="+res.Token, "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /v1/log with query token = %d, want 401", w.Code)
+	}
+
+	// 接口不支持 cookie
+	req = httptest.NewRequest(http.MethodGet, "/v1/me", nil)
+	req.AddCookie(&http.Cookie{Name: CookieName, Value: res.Token})
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /v1/me with cookie = %d, want 401", w.Code)
+	}
+
+	// 过期的会话被删除
+	session, err := store.Default.GetSession(hashToken(res.Token))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session.ExpiredAt = time.Now().Add(-time.Minute)
+
+	if err := store.Default.DeleteSession(session.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Default.CreateSession(session); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := request(app, http.MethodGet, "/v1/me", res.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /v1/me with expired token = %d, want 401", w.Code)
+	}
+
+	if _, err := store.Default.GetSession(session.Id); err == nil {
+		t.Error("expired session is not deleted")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	app := setup(t)
+
+	if err := Bootstrap("admin", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, admin := login(t, app, "admin", "password")
+
+	tests := []struct {
+		name   string
+		token  string
+		params SignUpParams
+		want   int
+	}{
+		{name: "without token", params: SignUpParams{Username: "user", Password: "password"}, want: http.StatusUnauthorized},
+		{name: "invalid username", token: admin.Token, params: SignUpParams{Username: "a b", Password: "password"}, want: http.StatusBadRequest},
+		{name: "short password", token: admin.Token, params: SignUpParams{Username: "user", Password: "pass"}, want: http.StatusBadRequest},
+		{name: "existing username", token: admin.Token, params: SignUpParams{Username: "Admin", Password: "password"}, want: http.StatusConflict},
+		{name: "valid user", token: admin.Token, params: SignUpParams{Username: "user", Password: "password"}, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := request(app, http.MethodPost, "/v1/auth/signup", tt.token, tt.params); w.Code != tt.want {
+				t.Errorf("signup status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	// 普通用户不能注册新用户
+	code, user := login(t, app, "user", "password")
+
+	if code != http.StatusOK || user.User.Admin {
+		t.Fatalf("login as user = %d %+v", code, user)
+	}
+
+	if w := request(app, http.MethodPost, "/v1/auth/signup", user.Token, SignUpParams{Username: "other", Password: "password"}); w.Code != http.StatusForbidden {
+		t.Errorf("signup by user = %d, want 403", w.Code)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	app := setup(t)
+
+	if err := Bootstrap("admin", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, api := login(t, app, "admin", "password")
+	_, view := login(t, app, "admin", "password")
+
+	w := request(app, http.MethodPost, "/v1/auth/logout", api.Token, nil)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("logout status = %d: %s", w.Code, w.Body)
+	}
+
+	if cookie := w.Header().Get("Set-Cookie"); !strings.Contains(cookie, CookieName+"=;") || !strings.Contains(cookie, "Max-Age=0") {
+		t.Errorf("Set-Cookie = %s, want the cookie cleared", cookie)
+	}
+
+	if w := request(app, http.MethodGet, "/v1/me", api.Token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /v1/me after logout = %d, want 401", w.Code)
+	}
+
+	// 其他会话不受影响
+	if w := request(app, http.MethodGet, "/v1/me", view.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("GET /v1/me with another session = %d, want 200", w.Code)
+	}
+
+	// 视图通过 cookie 退出登录
+	req := httptest.NewRequest(http.MethodPost, "/v1/auth/logout", nil)
+	req.AddCookie(&http.Cookie{Name: CookieName, Value: view.Token})
+
+	w = httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("logout with cookie status = %d", w.Code)
+	}
+
+	if _, err := store.Default.GetSession(hashToken(view.Token)); err == nil {
+		t.Error("session is not deleted by logout with cookie")
+	}
+
+	// 重复退出登录
+	if w := request(app, http.MethodPost, "/v1/auth/logout", api.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("logout twice status = %d, want 200", w.Code)
+	}
+}
+
+func TestPruneSessions(t *testing.T) {
+	setup(t)
+
+	for _, session := range []model.Session{
+		{Id: "expired", UserId: "1", ExpiredAt: time.Now().Add(-time.Minute)},
+		{Id: "valid", UserId: "1", ExpiredAt: time.Now().Add(time.Hour)},
+	} {
+		session := session
+
+		if err := store.Default.CreateSession(&session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := PruneSessions(); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := store.Default.ListSessions()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sessions) != 1 || sessions[0].Id != "valid" {
+		t.Errorf("sessions = %+v, want only the valid one", sessions)
+	}
+}
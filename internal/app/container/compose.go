@@ -0,0 +1,391 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// 默认的 compose 文件
+const DefaultComposeFile = "docker-compose.yml"
+
+// docker-compose.yml 中支持的配置, 不支持的字段会被忽略
+type Compose struct {
+	Services map[string]*Service        `yaml:"services"`
+	Networks map[string]*ComposeNetwork `yaml:"networks"`
+	Volumes  map[string]*ComposeVolume  `yaml:"volumes"`
+}
+
+// compose 中的服务
+type Service struct {
+	Build       *Build          `yaml:"build"`       // 构建的配置, 为空则使用 image
+	Image       string          `yaml:"image"`       // 镜像名, 设置了 build 时忽略
+	Command     command         `yaml:"command"`     // 启动命令
+	Entrypoint  command         `yaml:"entrypoint"`  // 入口
+	Environment environment     `yaml:"environment"` // 环境变量
+	Ports       []string        `yaml:"ports"`       // 端口映射, 例如 8080:80
+	Volumes     []string        `yaml:"volumes"`     // 挂载, 例如 data:/data, ./conf:/etc/conf:ro
+	Networks    serviceNetworks `yaml:"networks"`    // 加入的网络, 为空则加入默认网络
+	DependsOn   dependsOn       `yaml:"depends_on"`  // 依赖的服务, 依赖的服务先启动
+	Restart     string          `yaml:"restart"`     // 重启策略, no/on-failure/always/unless-stopped
+	WorkingDir  string          `yaml:"working_dir"` // 工作目录
+	User        string          `yaml:"user"`        // 运行的用户
+	CPUs        float64         `yaml:"cpus"`        // CPU 核数
+	MemLimit    string          `yaml:"mem_limit"`   // 内存限制, 例如 512m
+	PidsLimit   int64           `yaml:"pids_limit"`  // 最大进程数
+}
+
+// 服务的构建配置, 可以是构建目录, 也可以是对象
+type Build struct {
+	Context    string      `yaml:"context"`    // 构建目录, 相对于仓库的根目录
+	Dockerfile string      `yaml:"dockerfile"` // Dockerfile 的路径, 相对于构建目录
+	Args       environment `yaml:"args"`       // 构建参数
+}
+
+func (b *Build) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		b.Context = value.Value
+		return nil
+	}
+
+	type plain Build
+
+	return value.Decode((*plain)(b))
+}
+
+// compose 中的网络
+type ComposeNetwork struct {
+	Driver   string `yaml:"driver"`   // 驱动, 默认为 bridge
+	External bool   `yaml:"external"` // 是否为已经存在的网络, 不会被创建
+	Name     string `yaml:"name"`     // 网络的实际名称, 为空则为 compose 项目名_网络名
+}
+
+// compose 中的命名卷
+type ComposeVolume struct {
+	Driver   string `yaml:"driver"`   // 驱动, 默认为 local
+	External bool   `yaml:"external"` // 是否为已经存在的卷, 不会被创建
+	Name     string `yaml:"name"`     // 卷的实际名称, 为空则为 compose 项目名_卷名
+}
+
+// 命令, 可以是字符串, 也可以是数组
+type command []string
+
+func (c *command) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*c = splitCommand(value.Value)
+		return nil
+	}
+
+	var list []string
+
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+
+	*c = list
+
+	return nil
+}
+
+// 环境变量, 可以是 KEY=VALUE 的数组, 也可以是对象
+type environment []string
+
+func (e *environment) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.SequenceNode {
+		var list []string
+
+		if err := value.Decode(&list); err != nil {
+			return err
+		}
+
+		*e = list
+
+		return nil
+	}
+
+	var m map[string]*string
+
+	if err := value.Decode(&m); err != nil {
+		return err
+	}
+
+	list := make([]string, 0, len(m))
+
+	for k, v := range m {
+		if v == nil {
+			list = append(list, k)
+		} else {
+			list = append(list, k+"="+*v)
+		}
+	}
+
+	sort.Strings(list)
+
+	*e = list
+
+	return nil
+}
+
+// 服务加入的网络和别名, 可以是网络名的数组, 也可以是对象
+type serviceNetworks map[string][]string
+
+func (n *serviceNetworks) UnmarshalYAML(value *yaml.Node) error {
+	networks := serviceNetworks{}
+
+	if value.Kind == yaml.SequenceNode {
+		var list []string
+
+		if err := value.Decode(&list); err != nil {
+			return err
+		}
+
+		for _, name := range list {
+			networks[name] = nil
+		}
+
+		*n = networks
+
+		return nil
+	}
+
+	var m map[string]*struct {
+		Aliases []string `yaml:"aliases"`
+	}
+
+	if err := value.Decode(&m); err != nil {
+		return err
+	}
+
+	for name, v := range m {
+		if v == nil {
+			networks[name] = nil
+		} else {
+			networks[name] = v.Aliases
+		}
+	}
+
+	*n = networks
+
+	return nil
+}
+
+// 依赖的服务, 可以是服务名的数组, 也可以是对象
+type dependsOn []string
+
+func (d *dependsOn) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.SequenceNode {
+		var list []string
+
+		if err := value.Decode(&list); err != nil {
+			return err
+		}
+
+		*d = list
+
+		return nil
+	}
+
+	var m map[string]interface{}
+
+	if err := value.Decode(&m); err != nil {
+		return err
+	}
+
+	list := make([]string, 0, len(m))
+
+	for name := range m {
+		list = append(list, name)
+	}
+
+	sort.Strings(list)
+
+	*d = list
+
+	return nil
+}
+
+// 按照空白分割命令, 支持单引号和双引号
+func splitCommand(s string) []string {
+	var (
+		args    []string
+		current strings.Builder
+		quote   rune
+		started bool
+	)
+
+	for _, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			started = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if started {
+				args = append(args, current.String())
+				current.Reset()
+				started = false
+			}
+		default:
+			current.WriteRune(c)
+			started = true
+		}
+	}
+
+	if started {
+		args = append(args, current.String())
+	}
+
+	return args
+}
+
+// 解析 compose 文件
+func ParseCompose(content []byte) (*Compose, error) {
+	var compose Compose
+
+	if err := yaml.Unmarshal(content, &compose); err != nil {
+		return nil, errors.Wrap(err, "invalid compose file")
+	}
+
+	if len(compose.Services) == 0 {
+		return nil, errors.New("no services in compose file")
+	}
+
+	for name, s := range compose.Services {
+		if s == nil {
+			return nil, errors.Errorf("service '%s' is empty", name)
+		}
+
+		if nameReg.MatchString(name) {
+			return nil, errors.Errorf("invalid service name '%s'", name)
+		}
+
+		if s.Build == nil && s.Image == "" {
+			return nil, errors.Errorf("service '%s' must specify build or image", name)
+		}
+
+		for _, dep := range s.DependsOn {
+			if _, ok := compose.Services[dep]; !ok {
+				return nil, errors.Errorf("service '%s' depends on undefined service '%s'", name, dep)
+			}
+		}
+
+		for network := range s.Networks {
+			if _, ok := compose.Networks[network]; !ok && network != "default" {
+				return nil, errors.Errorf("service '%s' uses undefined network '%s'", name, network)
+			}
+		}
+
+		switch s.Restart {
+		case "", "no", RestartOnFailure, RestartAlways, "unless-stopped":
+		default:
+			return nil, errors.Errorf("invalid restart policy '%s' of service '%s'", s.Restart, name)
+		}
+	}
+
+	if _, err := compose.order(); err != nil {
+		return nil, err
+	}
+
+	return &compose, nil
+}
+
+// 读取并解析 compose 文件
+func readCompose(file string) (*Compose, error) {
+	b, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return ParseCompose(b)
+}
+
+// 按照依赖排序的服务名, 依赖的服务在前, 循环依赖返回错误
+func (c *Compose) order() ([]string, error) {
+	names := make([]string, 0, len(c.Services))
+
+	for name := range c.Services {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	state := map[string]int{}
+	order := make([]string, 0, len(names))
+
+	var visit func(name string, path []string) error
+
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return errors.Errorf("circular dependency between services: %s", strings.Join(append(path, name), " -> "))
+		}
+
+		state[name] = visiting
+
+		for _, dep := range c.Services[name].DependsOn {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+
+		state[name] = visited
+		order = append(order, name)
+
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// 网络的实际名称
+func (c *Compose) networkName(project string, network string) string {
+	if n := c.Networks[network]; n != nil {
+		if n.Name != "" {
+			return n.Name
+		}
+
+		if n.External {
+			return network
+		}
+	}
+
+	return fmt.Sprintf("%s_%s", project, network)
+}
+
+// 命名卷的实际名称
+func (c *Compose) volumeName(project string, volume string) string {
+	if v := c.Volumes[volume]; v != nil {
+		if v.Name != "" {
+			return v.Name
+		}
+
+		if v.External {
+			return volume
+		}
+	}
+
+	return fmt.Sprintf("%s_%s", project, volume)
+}
@@ -0,0 +1,275 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+)
+
+func TestParseCompose(t *testing.T) {
+	compose, err := ParseCompose([]byte(`
+services:
+  web:
+    build: ./web
+    command: node server.js --port 80
+    environment:
+      LOG_LEVEL: info
+    ports:
+      - 8080:80
+    depends_on:
+      - db
+    networks:
+      - backend
+  db:
+    image: postgres:13
+    environment:
+      - POSTGRES_PASSWORD
+    volumes:
+      - data:/var/lib/postgresql/data
+    restart: always
+networks:
+  backend: {}
+volumes:
+  data: {}
+`))
+
+	if err != nil {
+		t.Fatalf("ParseCompose() error = %v", err)
+	}
+
+	web := compose.Services["web"]
+
+	if web.Build == nil || web.Build.Context != "./web" {
+		t.Errorf("build = %+v, want context ./web", web.Build)
+	}
+
+	if want := []string{"node", "server.js", "--port", "80"}; !reflect.DeepEqual([]string(web.Command), want) {
+		t.Errorf("command = %v, want %v", web.Command, want)
+	}
+
+	if want := []string{"LOG_LEVEL=info"}; !reflect.DeepEqual([]string(web.Environment), want) {
+		t.Errorf("environment = %v, want %v", web.Environment, want)
+	}
+
+	order, err := compose.order()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"db", "web"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("order() = %v, want %v", order, want)
+	}
+
+	if got := compose.volumeName("hooker-blog", "data"); got != "hooker-blog_data" {
+		t.Errorf("volumeName() = %s", got)
+	}
+
+	if got := compose.networkName("hooker-blog", "backend"); got != "hooker-blog_backend" {
+		t.Errorf("networkName() = %s", got)
+	}
+}
+
+func TestParseComposeInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{name: "no services", content: "version: '3'", err: "no services"},
+		{name: "no build or image", content: "services:\n  web:\n    ports: ['80']", err: "must specify build or image"},
+		{name: "invalid name", content: "services:\n  'w b':\n    image: nginx", err: "invalid service name"},
+		{name: "undefined dependency", content: "services:\n  web:\n    image: nginx\n    depends_on: [db]", err: "undefined service 'db'"},
+		{name: "undefined network", content: "services:\n  web:\n    image: nginx\n    networks: [backend]", err: "undefined network 'backend'"},
+		{name: "invalid restart", content: "services:\n  web:\n    image: nginx\n    restart: sometimes", err: "invalid restart policy"},
+		{
+			name:    "circular dependency",
+			content: "services:\n  a:\n    image: nginx\n    depends_on: [b]\n  b:\n    image: nginx\n    depends_on: [a]",
+			err:     "circular dependency",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCompose([]byte(tt.content))
+
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseCompose() error = %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestServiceConfig(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+
+	if err := os.Mkdir(filepath.Join(root, "conf"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// 提交到仓库中的符号链接, 指向仓库之外
+	if err := os.Symlink("/", filepath.Join(root, "host")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(outside, filepath.Join(root, "conf", "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("conf", filepath.Join(root, "config")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(filepath.Join(outside, "missing"), filepath.Join(root, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	MountRoots = []string{outside}
+
+	defer func() {
+		MountRoots = nil
+	}()
+
+	r := Runtime{project: "blog", repo: "github.com/axetroy/blog", hash: "abc"}
+
+	tests := []struct {
+		name   string
+		volume string
+		want   string
+		err    bool
+	}{
+		{name: "repository directory", volume: "./conf:/etc/conf:ro", want: filepath.Join(realRoot, "conf") + ":/etc/conf:ro"},
+		{name: "symlink inside repository", volume: "./config:/etc/conf", want: filepath.Join(realRoot, "conf") + ":/etc/conf"},
+		{name: "not created yet", volume: "./cache:/cache", want: filepath.Join(realRoot, "cache") + ":/cache"},
+		{name: "named volume", volume: "data:/data", want: "hooker-blog_data:/data"},
+		{name: "allowed host directory", volume: outside + ":/data", want: outside + ":/data"},
+		{name: "parent directory", volume: "../:/data", err: true},
+		{name: "symlink to host root", volume: "./host:/host", err: true},
+		{name: "path through symlink to host root", volume: "./host/etc:/etc/host", err: true},
+		{name: "nested symlink out of repository", volume: "./conf/escape:/data", err: true},
+		{name: "dangling symlink", volume: "./dangling:/data", err: true},
+		{name: "host directory not allowed", volume: "/etc:/etc/host", err: true},
+		{name: "undefined volume", volume: "cache:/cache", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compose := &Compose{
+				Services: map[string]*Service{"web": {Image: "nginx", Volumes: []string{tt.volume}}},
+				Volumes:  map[string]*ComposeVolume{"data": {}},
+			}
+
+			config, err := r.serviceConfig(compose, root, "web", "sha256:nginx", "nginx")
+
+			if tt.err {
+				if err == nil {
+					t.Errorf("serviceConfig() binds = %v, want error", config.HostConfig.Binds)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("serviceConfig() error = %v", err)
+			}
+
+			if want := []string{tt.want}; !reflect.DeepEqual(config.HostConfig.Binds, want) {
+				t.Errorf("binds = %v, want %v", config.HostConfig.Binds, want)
+			}
+
+			if want := []string{"hooker-blog_default"}; !reflect.DeepEqual(keys(config.Networks), want) {
+				t.Errorf("networks = %v, want %v", config.Networks, want)
+			}
+		})
+	}
+}
+
+func TestInside(t *testing.T) {
+	root := t.TempDir()
+
+	if err := os.Symlink("/", filepath.Join(root, "host")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 构建目录也不能通过符号链接超出仓库
+	if _, err := inside(root, "host"); err == nil {
+		t.Error("inside() = nil error for symlink to /")
+	}
+
+	if _, err := inside(root, "../"); err == nil {
+		t.Error("inside() = nil error for parent directory")
+	}
+
+	if _, err := inside(root, "."); err != nil {
+		t.Errorf("inside() error = %v for repository root", err)
+	}
+}
+
+func TestServiceConfigPorts(t *testing.T) {
+	compose := &Compose{
+		Services: map[string]*Service{"web": {Image: "nginx", Ports: []string{"8080:80", "127.0.0.1:8443:443"}}},
+	}
+
+	tests := []struct {
+		name        string
+		environment string
+		want        nat.PortMap
+	}{
+		{
+			name: "default environment",
+			want: nat.PortMap{
+				"80/tcp":  {{HostPort: "8080"}},
+				"443/tcp": {{HostIP: "127.0.0.1", HostPort: "8443"}},
+			},
+		},
+		{
+			name:        "preview environment",
+			environment: "pr-1",
+			want: nat.PortMap{
+				"80/tcp":  {{}},
+				"443/tcp": {{HostIP: "127.0.0.1"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Runtime{project: "blog", environment: tt.environment, repo: "github.com/axetroy/blog", hash: "abc"}
+
+			config, err := r.serviceConfig(compose, t.TempDir(), "web", "sha256:nginx", "nginx")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(config.HostConfig.PortBindings, tt.want) {
+				t.Errorf("port bindings = %v, want %v", config.HostConfig.PortBindings, tt.want)
+			}
+
+			if len(config.Config.ExposedPorts) != 2 {
+				t.Errorf("exposed ports = %v", config.Config.ExposedPorts)
+			}
+		})
+	}
+}
+
+func keys(m map[string][]string) []string {
+	var result []string
+
+	for k := range m {
+		result = append(result, k)
+	}
+
+	return result
+}
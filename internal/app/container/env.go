@@ -0,0 +1,164 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// 数据目录, 挂载的文件保存在其中的 secrets 目录
+var DataDir = "data"
+
+// 挂载到容器中的文件
+type File struct {
+	Name    string // 变量名, compose 部署只挂载到引用了该变量的服务
+	Path    string // 容器中的路径
+	Content string // 文件内容
+}
+
+// 挂载的文件保存在 {data}/secrets/{project}/{environment}/{deployment} 目录中
+func (r *Runtime) filesDir() string {
+	environment := r.environment
+
+	if environment == "" {
+		environment = "default"
+	}
+
+	return path.Join(DataDir, "secrets", nameReg.ReplaceAllString(r.project, "_"), environment)
+}
+
+// 注入容器的环境变量, 内置变量放在最后, 不会被覆盖
+func (r *Runtime) env() []string {
+	return append(append([]string{}, r.variables...), r.builtins()...)
+}
+
+// 内置变量
+func (r *Runtime) builtins() []string {
+	return []string{
+		"HOOKER_PROJECT=" + r.project,
+		"HOOKER_ENVIRONMENT=" + r.environment,
+		"HOOKER_REPO=" + r.repo,
+		"HOOKER_REF=" + r.ref,
+		"HOOKER_COMMIT=" + r.hash,
+		"HOOKER_DEPLOYMENT=" + r.deployment,
+	}
+}
+
+// 环境变量的名称, 格式为 KEY=VALUE 或者 KEY
+func variableName(env string) string {
+	return strings.SplitN(env, "=", 2)[0]
+}
+
+// compose 服务的 environment 中引用的变量名
+func referenced(environment []string) map[string]bool {
+	names := map[string]bool{}
+
+	for _, e := range environment {
+		names[variableName(e)] = true
+	}
+
+	return names
+}
+
+// compose 服务的环境变量. 项目的变量只注入在 environment 中引用了它的服务, 同名时覆盖 compose 文件中的值, 内置变量注入所有服务
+func (r *Runtime) serviceEnv(environment []string) []string {
+	names := referenced(environment)
+	env := append([]string{}, environment...)
+
+	for _, v := range r.variables {
+		if names[variableName(v)] {
+			env = append(env, v)
+		}
+	}
+
+	return append(env, r.builtins()...)
+}
+
+// compose 服务引用的变量对应的文件, 以及 writeFiles 返回的 binds 中对应的挂载
+func (r *Runtime) serviceFiles(environment []string, binds []string) ([]File, []string) {
+	names := referenced(environment)
+
+	var (
+		files  []File
+		mounts []string
+	)
+
+	for i, f := range r.files {
+		if !names[f.Name] {
+			continue
+		}
+
+		files = append(files, f)
+
+		if i < len(binds) {
+			mounts = append(mounts, binds[i])
+		}
+	}
+
+	return files, mounts
+}
+
+// 写入需要挂载的文件, 返回容器的 Binds
+func (r *Runtime) writeFiles() ([]string, error) {
+	if len(r.files) == 0 {
+		return nil, nil
+	}
+
+	dir, err := filepath.Abs(path.Join(r.filesDir(), r.deployment))
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// 旧版本创建的 secrets 目录已经存在时 MkdirAll 不会修改它的权限, 确保只有 hooker 可以访问
+	if err := os.Chmod(path.Join(DataDir, "secrets"), 0700); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	binds := make([]string, 0, len(r.files))
+
+	for i, f := range r.files {
+		file := path.Join(dir, fmt.Sprintf("%d", i))
+
+		// 只有 hooker 的用户可以读取, 容器中需要使用同样的 uid 或者 root 运行
+		if err := ioutil.WriteFile(file, []byte(f.Content), 0600); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		binds = append(binds, fmt.Sprintf("%s:%s:ro", file, f.Path))
+	}
+
+	return binds, nil
+}
+
+// 删除挂载的文件, keep 为保留的部署 ID, 为空则全部删除
+func (r *Runtime) removeFiles(keep string) error {
+	dirs, err := ioutil.ReadDir(r.filesDir())
+
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, d := range dirs {
+		if d.Name() == keep {
+			continue
+		}
+
+		if err := os.RemoveAll(path.Join(r.filesDir(), d.Name())); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package container
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestServiceEnv(t *testing.T) {
+	r := Runtime{
+		project:    "blog",
+		repo:       "github.com/axetroy/blog",
+		hash:       "abc",
+		deployment: "1",
+		variables:  []string{"DB_PASSWORD=secret", "API_TOKEN=token", "LOG_LEVEL=info"},
+	}
+
+	builtins := r.builtins()
+
+	tests := []struct {
+		name        string
+		environment []string
+		want        []string
+	}{
+		{
+			name:        "no reference",
+			environment: []string{"PORT=80"},
+			want:        append([]string{"PORT=80"}, builtins...),
+		},
+		{
+			name:        "reference by name",
+			environment: []string{"DB_PASSWORD"},
+			want:        append([]string{"DB_PASSWORD", "DB_PASSWORD=secret"}, builtins...),
+		},
+		{
+			name:        "override compose value",
+			environment: []string{"LOG_LEVEL=debug", "PORT=80"},
+			want:        append([]string{"LOG_LEVEL=debug", "PORT=80", "LOG_LEVEL=info"}, builtins...),
+		},
+		{
+			name: "no environment",
+			want: builtins,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.serviceEnv(tt.environment); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("serviceEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceFiles(t *testing.T) {
+	r := Runtime{
+		files: []File{
+			{Name: "DB_PASSWORD", Path: "/run/secrets/db_password", Content: "secret"},
+			{Name: "TLS_KEY", Path: "/run/secrets/tls.key", Content: "key"},
+		},
+	}
+
+	binds := []string{"/data/0:/run/secrets/db_password:ro", "/data/1:/run/secrets/tls.key:ro"}
+
+	files, mounts := r.serviceFiles([]string{"TLS_KEY", "PORT=80"}, binds)
+
+	if !reflect.DeepEqual(files, r.files[1:]) || !reflect.DeepEqual(mounts, binds[1:]) {
+		t.Errorf("serviceFiles() = %v, %v, want only TLS_KEY", files, mounts)
+	}
+
+	if files, mounts := r.serviceFiles([]string{"PORT=80"}, binds); len(files) != 0 || len(mounts) != 0 {
+		t.Errorf("serviceFiles() = %v, %v, want none", files, mounts)
+	}
+}
+
+func TestWriteFiles(t *testing.T) {
+	defer func(dir string) {
+		DataDir = dir
+	}(DataDir)
+
+	DataDir = t.TempDir()
+
+	// 旧版本创建的目录权限过宽
+	if err := os.MkdirAll(path.Join(DataDir, "secrets"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	r := Runtime{
+		project:    "blog",
+		deployment: "1",
+		files:      []File{{Name: "DB_PASSWORD", Path: "/run/secrets/db_password", Content: "secret"}},
+	}
+
+	binds, err := r.writeFiles()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := path.Join(DataDir, "secrets", "blog", "default", "1", "0")
+
+	if abs, _ := filepath.Abs(file); !reflect.DeepEqual(binds, []string{abs + ":/run/secrets/db_password:ro"}) {
+		t.Errorf("writeFiles() = %v", binds)
+	}
+
+	for p, want := range map[string]os.FileMode{
+		path.Join(DataDir, "secrets"):                         0700,
+		path.Join(DataDir, "secrets", "blog", "default", "1"): 0700,
+		file: 0600,
+	} {
+		info, err := os.Stat(p)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Mode().Perm() != want {
+			t.Errorf("mode of %s = %o, want %o", p, info.Mode().Perm(), want)
+		}
+	}
+
+	if err := r.removeFiles(""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("file %s is not removed", file)
+	}
+}
@@ -0,0 +1,171 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+)
+
+// 健康检查的类型
+const (
+	HealthDocker = "docker" // 使用镜像中的 HEALTHCHECK
+	HealthHTTP   = "http"   // 请求返回 2xx 或者 3xx
+	HealthTCP    = "tcp"    // 端口可以连接
+)
+
+// 默认的健康检查超时时间和间隔
+const (
+	defaultHealthTimeout  = time.Second * 60
+	defaultHealthInterval = time.Second * 2
+)
+
+// 健康检查的配置
+type HealthCheck struct {
+	Type     string        // docker/http/tcp, 为空时镜像有 HEALTHCHECK 则使用 docker, 否则只检查容器是否在运行
+	Path     string        // http 检查的路径, 默认为 /
+	Port     uint64        // http/tcp 检查的容器端口, 为 0 则使用第一个端口映射的容器端口
+	Timeout  time.Duration // 等待健康的超时时间
+	Interval time.Duration // 检查的间隔
+}
+
+var probeClient = &http.Client{Timeout: time.Second * 5}
+
+// 等待容器健康, 容器退出, 不健康或者超时返回错误
+func (r *Runtime) waitHealthy(ctx context.Context, id string) error {
+	timeout := r.health.Timeout
+	interval := r.health.Interval
+
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+
+	if interval <= 0 {
+		interval = defaultHealthInterval
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		time.Sleep(interval)
+
+		info, err := r.client.ContainerInspect(ctx, id)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if !info.State.Running {
+			return errors.Errorf("container exited with code %d", info.State.ExitCode)
+		}
+
+		err = r.probe(info)
+
+		if err == nil {
+			return nil
+		}
+
+		if errors.Cause(err) == errUnhealthy || time.Now().After(deadline) {
+			return errors.Wrapf(err, "container is not healthy after %s", timeout)
+		}
+
+		_, _ = fmt.Fprintf(r.writer, "Waiting for container to be healthy: %v\n", err)
+	}
+}
+
+var errUnhealthy = errors.New("container is unhealthy")
+
+// 检查一次容器是否健康
+func (r *Runtime) probe(info types.ContainerJSON) error {
+	kind := r.health.Type
+
+	if kind == "" && info.State.Health != nil {
+		kind = HealthDocker
+	}
+
+	switch kind {
+	case HealthDocker:
+		if info.State.Health == nil {
+			return errors.New("image has no HEALTHCHECK")
+		}
+
+		switch info.State.Health.Status {
+		case "healthy":
+			return nil
+		case "unhealthy":
+			return errors.WithStack(errUnhealthy)
+		default:
+			return errors.Errorf("health status is '%s'", info.State.Health.Status)
+		}
+	case HealthHTTP:
+		addr, err := r.probeAddress(info)
+
+		if err != nil {
+			return err
+		}
+
+		path := r.health.Path
+
+		if path == "" {
+			path = "/"
+		}
+
+		res, err := probeClient.Get(fmt.Sprintf("http://%s%s", addr, path))
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		_ = res.Body.Close()
+
+		if res.StatusCode >= 400 {
+			return errors.Errorf("GET %s: %s", path, res.Status)
+		}
+
+		return nil
+	case HealthTCP:
+		addr, err := r.probeAddress(info)
+
+		if err != nil {
+			return err
+		}
+
+		conn, err := net.DialTimeout("tcp", addr, time.Second*5)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return conn.Close()
+	default:
+		// 没有配置健康检查, 容器在运行即可
+		return nil
+	}
+}
+
+// 健康检查的地址, 优先使用发布到本机的端口, 否则使用容器的 IP
+func (r *Runtime) probeAddress(info types.ContainerJSON) (string, error) {
+	port := r.health.Port
+
+	if port == 0 && len(r.ports) > 0 {
+		port = r.ports[0].ContainerPort
+	}
+
+	if port == 0 {
+		return "", errors.New("no port to check")
+	}
+
+	if addr, err := publishedAddress(info, port); err == nil {
+		return addr, nil
+	}
+
+	if info.NetworkSettings == nil || info.NetworkSettings.IPAddress == "" {
+		return "", errors.Errorf("port %d is not reachable", port)
+	}
+
+	return net.JoinHostPort(info.NetworkSettings.IPAddress, fmt.Sprintf("%d", port)), nil
+}
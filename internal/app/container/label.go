@@ -0,0 +1,187 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+)
+
+// 容器和镜像的标签, hooker 只会管理带有这些标签的容器和镜像
+const (
+	LabelProject     = "hooker.project"
+	LabelRepo        = "hooker.repo"
+	LabelEnvironment = "hooker.environment"
+	LabelDeployment  = "hooker.deployment"
+	LabelCommit      = "hooker.commit"
+	LabelPorts       = "hooker.ports"       // 本机端口到容器端口的映射, 例如 8080:80,8081:81
+	LabelService     = "hooker.service"     // compose 部署的服务名
+	LabelConfigHash  = "hooker.config-hash" // compose 服务的配置摘要, 没有变化的服务不会被重新创建
+)
+
+// 容器名称只能包含字母, 数字和 _.-
+var nameReg = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// 容器名称, 默认环境为 hooker-project, 其他环境为 hooker-project-environment
+func ContainerName(project string, environment string) string {
+	name := "hooker-" + project
+
+	if environment != "" {
+		name += "-" + environment
+	}
+
+	return nameReg.ReplaceAllString(name, "_")
+}
+
+// 按照项目过滤 hooker 管理的容器或者镜像, project 为空则不限制项目
+func projectFilter(project string) filters.Args {
+	args := filters.NewArgs()
+
+	if project == "" {
+		args.Add("label", LabelProject)
+	} else {
+		args.Add("label", LabelProject+"="+project)
+	}
+
+	return args
+}
+
+// 当前部署的容器和镜像的标签
+func (r *Runtime) labels() map[string]string {
+	return map[string]string{
+		LabelProject:     r.project,
+		LabelRepo:        r.repo,
+		LabelEnvironment: r.environment,
+		LabelDeployment:  r.deployment,
+		LabelCommit:      r.hash,
+		LabelPorts:       formatPorts(r.ports),
+	}
+}
+
+func formatPorts(ports []ExposePort) string {
+	list := make([]string, 0, len(ports))
+
+	for _, p := range ports {
+		list = append(list, fmt.Sprintf("%d:%d", p.MachinePort, p.ContainerPort))
+	}
+
+	return strings.Join(list, ",")
+}
+
+// 解析标签中的端口映射, 格式错误的端口会被忽略
+func parsePorts(label string) []ExposePort {
+	ports := make([]ExposePort, 0)
+
+	for _, s := range strings.Split(label, ",") {
+		var p ExposePort
+
+		if _, err := fmt.Sscanf(s, "%d:%d", &p.MachinePort, &p.ContainerPort); err == nil {
+			ports = append(ports, p)
+		}
+	}
+
+	return ports
+}
+
+// 当前项目和环境的所有容器, 包括已经停止的容器.
+// 项目第一次部署默认环境时还包括旧版本创建的没有标签的容器, 它们直接占用了本机端口, 和其他旧的容器一起被停止
+func (r *Runtime) containers(ctx context.Context) ([]types.Container, error) {
+	list, err := r.client.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: projectFilter(r.project),
+	})
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	containers := make([]types.Container, 0, len(list))
+
+	for _, c := range list {
+		// 默认环境的标签为空, 所以在这里过滤环境
+		if c.Labels[LabelEnvironment] == r.environment {
+			containers = append(containers, c)
+		}
+	}
+
+	if r.environment != "" || len(list) > 0 {
+		return containers, nil
+	}
+
+	// 通过镜像名识别旧的容器只用于升级之后的第一次部署, 之后的部署不会停止其他镜像名相同的容器
+	if first, err := r.firstDeploy(ctx); err != nil || !first {
+		return containers, err
+	}
+
+	legacy, err := r.client.ContainerList(ctx, types.ContainerListOptions{All: true})
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, c := range legacy {
+		if isLegacy(c, r.repo) {
+			containers = append(containers, c)
+		}
+	}
+
+	return containers, nil
+}
+
+// 是否为项目的第一次部署, 即没有之前的部署创建的镜像. 保留的版本和运行中的容器都会保留镜像
+func (r *Runtime) firstDeploy(ctx context.Context) (bool, error) {
+	images, err := r.client.ImageList(ctx, types.ImageListOptions{
+		All:     true,
+		Filters: projectFilter(r.project),
+	})
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	for _, img := range images {
+		if img.Labels[LabelDeployment] != r.deployment {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// 是否为旧版本部署的容器. 旧版本不设置标签和容器名称, 只能通过镜像名 repo:hash 识别
+func isLegacy(c types.Container, repo string) bool {
+	if _, ok := c.Labels[LabelProject]; ok || repo == "" {
+		return false
+	}
+
+	return strings.HasPrefix(c.Image, repo+":")
+}
+
+// 删除容器, 旧版本创建的容器设置了 AutoRemove, 停止之后会被自动删除, 等待删除完成
+func (r *Runtime) removeContainer(ctx context.Context, id string) error {
+	err := r.client.ContainerRemove(ctx, id, types.ContainerRemoveOptions{Force: true})
+
+	if err == nil || client.IsErrContainerNotFound(err) {
+		return nil
+	}
+
+	if !strings.Contains(err.Error(), "already in progress") {
+		return errors.WithStack(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		if _, err := r.client.ContainerInspect(ctx, id); client.IsErrContainerNotFound(err) {
+			return nil
+		}
+
+		time.Sleep(time.Millisecond * 500)
+	}
+
+	return errors.Errorf("timeout to remove container '%s'", id)
+}
@@ -0,0 +1,83 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestIsLegacy(t *testing.T) {
+	tests := []struct {
+		name      string
+		container types.Container
+		want      bool
+	}{
+		{
+			name:      "deployed before labels",
+			container: types.Container{Image: "github.com/axetroy/blog:da1560886d4f094c3e6c9ef40349f7d38b5d27d7"},
+			want:      true,
+		},
+		{
+			name: "labelled",
+			container: types.Container{
+				Image:  "github.com/axetroy/blog:da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+				Labels: map[string]string{LabelProject: "blog"},
+			},
+		},
+		{
+			name:      "other repository with the same prefix",
+			container: types.Container{Image: "github.com/axetroy/blog-admin:da1560886d4f094c3e6c9ef40349f7d38b5d27d7"},
+		},
+		{
+			name:      "not deployed by hooker",
+			container: types.Container{Image: "nginx:latest"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isLegacy(tt.container, "github.com/axetroy/blog"); got != tt.want {
+				t.Errorf("isLegacy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if isLegacy(types.Container{Image: ":latest"}, "") {
+		t.Error("isLegacy() = true without repository")
+	}
+}
+
+func TestPorts(t *testing.T) {
+	ports := []ExposePort{{MachinePort: 8080, ContainerPort: 80}, {MachinePort: 8443, ContainerPort: 443}}
+
+	if got := formatPorts(ports); got != "8080:80,8443:443" {
+		t.Errorf("formatPorts() = %s", got)
+	}
+
+	if got := parsePorts("8080:80,invalid,8443:443"); !reflect.DeepEqual(got, ports) {
+		t.Errorf("parsePorts() = %v, want %v", got, ports)
+	}
+
+	if got := parsePorts(""); len(got) != 0 {
+		t.Errorf("parsePorts() of empty label = %v", got)
+	}
+}
+
+func TestContainerName(t *testing.T) {
+	tests := []struct {
+		project     string
+		environment string
+		want        string
+	}{
+		{"blog", "", "hooker-blog"},
+		{"blog", "pr-1", "hooker-blog-pr-1"},
+		{"github.com_axetroy_blog", "feature/login", "hooker-github.com_axetroy_blog-feature_login"},
+	}
+
+	for _, tt := range tests {
+		if got := ContainerName(tt.project, tt.environment); got != tt.want {
+			t.Errorf("ContainerName(%s, %s) = %s, want %s", tt.project, tt.environment, got, tt.want)
+		}
+	}
+}
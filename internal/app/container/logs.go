@@ -0,0 +1,75 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+)
+
+// 容器最后的 n 行日志, 包括 stdout 和 stderr
+func (r *Runtime) tail(ctx context.Context, id string, n int) ([]string, error) {
+	reader, err := r.client.ContainerLogs(ctx, id, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       fmt.Sprintf("%d", n),
+	})
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	b, err := ioutil.ReadAll(reader)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	lines := make([]string, 0, n)
+	scanner := bufio.NewScanner(bytes.NewReader(demux(b)))
+
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+
+	return lines, nil
+}
+
+// 没有 TTY 的容器日志中每一段都带有 8 个字节的头, 第 1 个字节为 stdout/stderr, 后 4 个字节为长度
+func demux(b []byte) []byte {
+	var out bytes.Buffer
+
+	for len(b) >= 8 {
+		if b[0] > 2 || b[1] != 0 || b[2] != 0 || b[3] != 0 {
+			// 使用 TTY 的容器没有头
+			out.Write(b)
+			return out.Bytes()
+		}
+
+		size := int(binary.BigEndian.Uint32(b[4:8]))
+		b = b[8:]
+
+		if size > len(b) {
+			size = len(b)
+		}
+
+		out.Write(b[:size])
+		b = b[size:]
+	}
+
+	if out.Len() == 0 {
+		return b
+	}
+
+	return out.Bytes()
+}
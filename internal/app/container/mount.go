@@ -0,0 +1,229 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+)
+
+// 允许挂载的本机目录, 为空则不允许挂载本机目录
+var MountRoots []string
+
+var ErrMountNotAllowed = errors.New("mount source is not allowed")
+
+// 挂载到容器中的卷, name 和 source 二选一
+type Volume struct {
+	Name     string // 命名卷, 不存在时自动创建, 重新部署时保留
+	Source   string // 本机目录, 必须在 MountRoots 中
+	Target   string // 容器中的路径
+	ReadOnly bool   // 是否只读
+}
+
+// 容器加入的网络
+type Network struct {
+	Name    string   // 网络名称, 不存在时自动创建
+	Aliases []string // 容器在网络中的别名
+}
+
+// 检查本机目录是否在允许挂载的目录中, 符号链接会被解析
+func CheckMountSource(source string) error {
+	if !filepath.IsAbs(source) {
+		return errors.Errorf("mount source '%s' must be an absolute path", source)
+	}
+
+	source = filepath.Clean(source)
+
+	if resolved, err := filepath.EvalSymlinks(source); err == nil {
+		source = resolved
+	}
+
+	for _, root := range MountRoots {
+		root = filepath.Clean(root)
+
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			root = resolved
+		}
+
+		if source == root || strings.HasPrefix(source, root+string(filepath.Separator)) {
+			return nil
+		}
+	}
+
+	return errors.Wrapf(ErrMountNotAllowed, "'%s' is not under %v", source, MountRoots)
+}
+
+// 替换名称中的 {environment}, 默认环境为 default
+func ExpandName(name string, environment string) string {
+	if environment == "" {
+		environment = "default"
+	}
+
+	return strings.Replace(name, "{environment}", environment, -1)
+}
+
+// 创建不存在的命名卷, driver 为空则为 local
+func (r *Runtime) ensureVolume(ctx context.Context, name string, driver string, labels map[string]string) error {
+	if _, err := r.client.VolumeInspect(ctx, name); err == nil {
+		return nil
+	} else if !client.IsErrVolumeNotFound(err) {
+		return errors.WithStack(err)
+	}
+
+	if driver == "" {
+		driver = "local"
+	}
+
+	log.Printf("Creating volume '%s'\n", name)
+	_, _ = fmt.Fprintf(r.writer, "Creating volume '%s'\n", name)
+
+	l := map[string]string{LabelProject: r.project, LabelEnvironment: r.environment}
+
+	for k, v := range labels {
+		l[k] = v
+	}
+
+	_, err := r.client.VolumeCreate(ctx, volumetypes.VolumesCreateBody{
+		Name:       name,
+		Driver:     driver,
+		DriverOpts: map[string]string{},
+		Labels:     l,
+	})
+
+	return errors.WithStack(err)
+}
+
+// 创建不存在的网络, driver 为空则为 bridge
+func (r *Runtime) ensureNetwork(ctx context.Context, name string, driver string, labels map[string]string) error {
+	if _, err := r.client.NetworkInspect(ctx, name); err == nil {
+		return nil
+	} else if !client.IsErrNetworkNotFound(err) {
+		return errors.WithStack(err)
+	}
+
+	if driver == "" {
+		driver = "bridge"
+	}
+
+	log.Printf("Creating network '%s'\n", name)
+	_, _ = fmt.Fprintf(r.writer, "Creating network '%s'\n", name)
+
+	l := map[string]string{LabelProject: r.project, LabelEnvironment: r.environment}
+
+	for k, v := range labels {
+		l[k] = v
+	}
+
+	_, err := r.client.NetworkCreate(ctx, name, types.NetworkCreate{
+		CheckDuplicate: true,
+		Driver:         driver,
+		Labels:         l,
+	})
+
+	return errors.WithStack(err)
+}
+
+// 创建不存在的命名卷并且检查本机目录, 返回容器的 Binds
+func (r *Runtime) prepareVolumes(ctx context.Context) ([]string, error) {
+	binds := make([]string, 0, len(r.volumes))
+
+	for _, v := range r.volumes {
+		var source string
+
+		if v.Name != "" {
+			source = ExpandName(v.Name, r.environment)
+
+			if err := r.ensureVolume(ctx, source, "", nil); err != nil {
+				return nil, err
+			}
+		} else {
+			if err := CheckMountSource(v.Source); err != nil {
+				return nil, err
+			}
+
+			source = filepath.Clean(v.Source)
+		}
+
+		bind := fmt.Sprintf("%s:%s", source, v.Target)
+
+		if v.ReadOnly {
+			bind += ":ro"
+		}
+
+		binds = append(binds, bind)
+	}
+
+	return binds, nil
+}
+
+// 创建不存在的网络, 返回第一个网络的配置, 其他网络在容器创建之后加入
+func (r *Runtime) prepareNetworks(ctx context.Context) (*network.NetworkingConfig, error) {
+	if len(r.networks) == 0 {
+		return nil, nil
+	}
+
+	for _, n := range r.networks {
+		if err := r.ensureNetwork(ctx, ExpandName(n.Name, r.environment), "", nil); err != nil {
+			return nil, err
+		}
+	}
+
+	first := r.networks[0]
+
+	return &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			ExpandName(first.Name, r.environment): {Aliases: first.Aliases},
+		},
+	}, nil
+}
+
+// 容器加入除第一个网络之外的其他网络
+func (r *Runtime) connectNetworks(ctx context.Context, id string) error {
+	for i, n := range r.networks {
+		if i == 0 {
+			continue
+		}
+
+		if err := r.client.NetworkConnect(ctx, ExpandName(n.Name, r.environment), id, &network.EndpointSettings{
+			Aliases: n.Aliases,
+		}); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// 删除环境独有的网络, 即名称中包含 {environment} 的网络, 命名卷始终保留
+func (r *Runtime) removeNetworks(ctx context.Context) {
+	for _, n := range r.networks {
+		if !strings.Contains(n.Name, "{environment}") {
+			continue
+		}
+
+		name := ExpandName(n.Name, r.environment)
+
+		log.Printf("Removing network '%s'\n", name)
+		_, _ = fmt.Fprintf(r.writer, "Removing network '%s'\n", name)
+
+		if err := r.client.NetworkRemove(ctx, name); err != nil && !client.IsErrNetworkNotFound(err) {
+			log.Printf("%+v\n", errors.WithStack(err))
+		}
+	}
+}
+
+// 容器的网络模式, 加入了网络时使用第一个网络
+func (r *Runtime) networkMode() string {
+	if len(r.networks) == 0 {
+		return ""
+	}
+
+	return ExpandName(r.networks[0].Name, r.environment)
+}
@@ -0,0 +1,173 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+)
+
+// 本机端口的转发, 切换容器时只修改转发的地址, 不会中断端口的监听
+type proxy struct {
+	sync.RWMutex
+	listener net.Listener
+	target   string // 容器发布到 127.0.0.1 的地址
+}
+
+var (
+	proxiesMu sync.Mutex
+	proxies   = map[uint64]*proxy{}
+)
+
+// 把本机端口的流量转发到 target, 端口还没有监听时开始监听. 已经建立的连接不受影响
+func route(port uint64, target string) error {
+	proxiesMu.Lock()
+	defer proxiesMu.Unlock()
+
+	if p, ok := proxies[port]; ok {
+		p.Lock()
+		p.target = target
+		p.Unlock()
+
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	p := &proxy{listener: listener, target: target}
+	proxies[port] = p
+
+	go p.serve()
+
+	return nil
+}
+
+// 停止监听本机端口
+func unroute(port uint64) {
+	proxiesMu.Lock()
+	defer proxiesMu.Unlock()
+
+	if p, ok := proxies[port]; ok {
+		_ = p.listener.Close()
+		delete(proxies, port)
+	}
+}
+
+func (p *proxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+
+		if err != nil {
+			// 停止监听
+			return
+		}
+
+		p.RLock()
+		target := p.target
+		p.RUnlock()
+
+		go forward(conn, target)
+	}
+}
+
+func forward(conn net.Conn, target string) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	upstream, err := net.Dial("tcp", target)
+
+	if err != nil {
+		log.Printf("Can not connect to '%s': %v\n", target, err)
+		return
+	}
+
+	defer func() {
+		_ = upstream.Close()
+	}()
+
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+
+	// 等待两个方向都结束, 一方关闭写入之后另一方仍然可以继续发送响应
+	go func() {
+		defer wg.Done()
+		pipe(upstream, conn)
+	}()
+
+	go func() {
+		defer wg.Done()
+		pipe(conn, upstream)
+	}()
+
+	wg.Wait()
+}
+
+// 把 src 的数据复制到 dst, src 关闭写入之后只关闭 dst 的写入. 连接异常断开时关闭两个连接, 结束另一个方向的复制
+func pipe(dst net.Conn, src net.Conn) {
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		_ = src.Close()
+		return
+	}
+
+	if c, ok := dst.(interface{ CloseWrite() error }); ok {
+		_ = c.CloseWrite()
+	} else {
+		_ = dst.Close()
+	}
+}
+
+// 根据容器的标签恢复本机端口的转发, 用于 hooker 重启之后
+func RestoreRoutes(ctx context.Context) error {
+	cli, err := client.NewEnvClient()
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer func() {
+		_ = cli.Close()
+	}()
+
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		Filters: projectFilter(""),
+	})
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, c := range containers {
+		// 只恢复已经切换的容器, 不包括正在进行健康检查的容器
+		if containerName(c) != ContainerName(c.Labels[LabelProject], c.Labels[LabelEnvironment]) {
+			continue
+		}
+
+		for _, p := range parsePorts(c.Labels[LabelPorts]) {
+			for _, port := range c.Ports {
+				// 只有发布到 127.0.0.1 的端口需要转发, 其他的端口由 docker 直接发布
+				if uint64(port.PrivatePort) != p.ContainerPort || port.PublicPort == 0 || port.IP != "127.0.0.1" {
+					continue
+				}
+
+				if err := route(p.MachinePort, fmt.Sprintf("127.0.0.1:%d", port.PublicPort)); err != nil {
+					log.Printf("%+v\n", err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
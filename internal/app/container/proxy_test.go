@@ -0,0 +1,160 @@
+package container
+
+import (
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+)
+
+func listen(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	return listener
+}
+
+// 客户端发送完请求之后关闭写入, 仍然可以收到服务端在读取完请求之后发送的响应
+func TestForwardHalfClose(t *testing.T) {
+	upstream := listen(t)
+
+	go func() {
+		conn, err := upstream.Accept()
+
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		request, err := ioutil.ReadAll(conn)
+
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		// 读取到 EOF 之后才响应
+		time.Sleep(time.Millisecond * 50)
+
+		_, _ = conn.Write(append([]byte("echo: "), request...))
+	}()
+
+	proxy := listen(t)
+
+	go func() {
+		conn, err := proxy.Accept()
+
+		if err != nil {
+			return
+		}
+
+		forward(conn, upstream.Addr().String())
+	}()
+
+	conn, err := net.Dial("tcp", proxy.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 5))
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := ioutil.ReadAll(conn)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(response) != "echo: ping" {
+		t.Errorf("response = %q, want %q", response, "echo: ping")
+	}
+}
+
+func TestForwardUpstreamUnavailable(t *testing.T) {
+	upstream := listen(t)
+	address := upstream.Addr().String()
+
+	// 容器已经停止, 端口不再监听
+	_ = upstream.Close()
+
+	proxy := listen(t)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		conn, err := proxy.Accept()
+
+		if err != nil {
+			return
+		}
+
+		forward(conn, address)
+	}()
+
+	conn, err := net.Dial("tcp", proxy.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(time.Second * 5))
+
+	if b, err := ioutil.ReadAll(conn); err != nil || len(b) != 0 {
+		t.Errorf("ReadAll() = %q, %v, want connection closed", b, err)
+	}
+
+	<-done
+}
+
+func TestPortBindings(t *testing.T) {
+	ports := []ExposePort{{MachinePort: 8080, ContainerPort: 80}}
+
+	tests := []struct {
+		name  string
+		proxy bool
+		want  nat.PortMap
+	}{
+		{name: "published by docker", want: nat.PortMap{"80/tcp": {{HostPort: "8080"}}}},
+		{name: "forwarded by hooker", proxy: true, want: nat.PortMap{"80/tcp": {{HostIP: "127.0.0.1"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Runtime{ports: ports, proxy: tt.proxy}
+
+			bindings, exposed := r.portBindings()
+
+			if !reflect.DeepEqual(bindings, tt.want) {
+				t.Errorf("portBindings() = %v, want %v", bindings, tt.want)
+			}
+
+			if _, ok := exposed["80/tcp"]; !ok || len(exposed) != 1 {
+				t.Errorf("exposed ports = %v", exposed)
+			}
+		})
+	}
+}
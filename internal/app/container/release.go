@@ -0,0 +1,230 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+)
+
+const (
+	// 默认保留的镜像数量
+	DefaultKeep = 3
+
+	// 短的 commit hash 的最小长度
+	MinCommitLength = 7
+)
+
+var (
+	ErrReleaseNotFound = errors.New("release not found")
+	ErrShortCommit     = errors.Errorf("commit must be at least %d characters", MinCommitLength)
+	ErrAmbiguousCommit = errors.New("ambiguous commit")
+)
+
+// 保留的镜像, 可以不需要构建直接部署
+type Release struct {
+	Commit     string    `json:"commit"`     // 镜像对应的 commit hash
+	Image      string    `json:"image"`      // 镜像名, 例如 github.com/owner/repo:hash
+	ImageId    string    `json:"image_id"`   // 镜像 ID
+	Deployment string    `json:"deployment"` // 构建镜像的部署 ID
+	Current    bool      `json:"current"`    // 是否为正在运行的版本
+	CreatedAt  time.Time `json:"created_at"` // 镜像的创建时间
+}
+
+// 项目的环境的所有镜像, 按照创建时间倒序
+func environmentImages(ctx context.Context, cli *client.Client, project string, environment string) ([]types.ImageSummary, error) {
+	list, err := cli.ImageList(ctx, types.ImageListOptions{
+		Filters: projectFilter(project),
+	})
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	images := make([]types.ImageSummary, 0, len(list))
+
+	for _, img := range list {
+		// 默认环境的标签为空, 所以在这里过滤环境
+		if img.Labels[LabelEnvironment] == environment {
+			images = append(images, img)
+		}
+	}
+
+	sort.SliceStable(images, func(i, j int) bool {
+		return images[i].Created > images[j].Created
+	})
+
+	return images, nil
+}
+
+// 镜像的标签, 没有标签的镜像为空
+func imageTag(img types.ImageSummary) string {
+	for _, tag := range img.RepoTags {
+		if tag != "<none>:<none>" {
+			return tag
+		}
+	}
+
+	return ""
+}
+
+// 列出项目的环境保留的版本, 按照创建时间倒序
+func ListReleases(ctx context.Context, project string, environment string) ([]Release, error) {
+	cli, err := client.NewEnvClient()
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer func() {
+		_ = cli.Close()
+	}()
+
+	images, err := environmentImages(ctx, cli, project, environment)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 正在运行的版本
+	var current string
+
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		Filters: projectFilter(project),
+	})
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, c := range containers {
+		if containerName(c) == ContainerName(project, environment) {
+			current = c.ImageID
+		}
+	}
+
+	releases := make([]Release, 0, len(images))
+
+	for _, img := range images {
+		tag := imageTag(img)
+
+		// compose 部署的服务镜像不能单独部署
+		if tag == "" || img.Labels[LabelService] != "" {
+			continue
+		}
+
+		releases = append(releases, Release{
+			Commit:     img.Labels[LabelCommit],
+			Image:      tag,
+			ImageId:    img.ID,
+			Deployment: img.Labels[LabelDeployment],
+			Current:    img.ID == current,
+			CreatedAt:  time.Unix(img.Created, 0),
+		})
+	}
+
+	return releases, nil
+}
+
+// 根据 commit hash 查找保留的版本, 支持至少 7 位的短 hash
+func FindRelease(ctx context.Context, project string, environment string, commit string) (*Release, error) {
+	if len(commit) < MinCommitLength {
+		return nil, errors.Wrapf(ErrShortCommit, "commit '%s'", commit)
+	}
+
+	releases, err := ListReleases(ctx, project, environment)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return matchRelease(releases, commit)
+}
+
+// 查找 commit hash 以 commit 开头的版本, 同一个提交的多个版本使用最新的, 匹配多个不同的提交时返回错误
+func matchRelease(releases []Release, commit string) (*Release, error) {
+	var found *Release
+
+	for _, r := range releases {
+		if !strings.HasPrefix(r.Commit, commit) {
+			continue
+		}
+
+		if found == nil {
+			release := r
+			found = &release
+		} else if found.Commit != r.Commit {
+			return nil, errors.Wrapf(ErrAmbiguousCommit, "commit '%s' matches '%s' and '%s'", commit, found.Commit, r.Commit)
+		}
+	}
+
+	if found == nil {
+		return nil, errors.Wrapf(ErrReleaseNotFound, "commit '%s'", commit)
+	}
+
+	return found, nil
+}
+
+// 只保留最近的 keep 个版本, 正在运行的镜像不会被删除
+func (r *Runtime) pruneReleases(ctx context.Context) error {
+	keep := r.keep
+
+	if keep <= 0 {
+		keep = DefaultKeep
+	}
+
+	images, err := environmentImages(ctx, r.client, r.project, r.environment)
+
+	if err != nil {
+		return err
+	}
+
+	var current string
+
+	if r.containerId != "" {
+		info, err := r.client.ContainerInspect(ctx, r.containerId)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		current = info.Image
+	}
+
+	kept := 0
+
+	for _, img := range images {
+		// compose 部署的服务镜像由 pruneServiceImages 清理
+		if img.Labels[LabelService] != "" {
+			continue
+		}
+
+		if img.ID == current {
+			kept++
+			continue
+		}
+
+		// 重新构建同一个 commit 之后, 旧的镜像会失去标签
+		if imageTag(img) != "" && kept < keep {
+			kept++
+			continue
+		}
+
+		_, _ = fmt.Fprintf(r.writer, "Removing image '%s' %v\n", img.ID, img.RepoTags)
+
+		if _, err := r.client.ImageRemove(ctx, img.ID, types.ImageRemoveOptions{
+			PruneChildren: true,
+		}); err != nil && !client.IsErrImageNotFound(err) {
+			// 镜像可能还在被其他容器使用
+			log.Printf("%+v\n", errors.WithStack(err))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package container
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestMatchRelease(t *testing.T) {
+	releases := []Release{
+		{Commit: "01fa2a3efeeeaddf8c9f0fafe94944ba0415d48e", Deployment: "3"},
+		{Commit: "01fa2a3efeeeaddf8c9f0fafe94944ba0415d48e", Deployment: "2"},
+		{Commit: "01fa2a3d9c1b5e8f7a6b5c4d3e2f1a0b9c8d7e6f", Deployment: "1"},
+		{Commit: "1051f690cf55b0fb71bc23fb913ca0c324f98e14", Deployment: "0"},
+	}
+
+	tests := []struct {
+		name   string
+		commit string
+		want   string // 部署 ID
+		err    error
+	}{
+		{name: "full hash", commit: "1051f690cf55b0fb71bc23fb913ca0c324f98e14", want: "0"},
+		{name: "short hash", commit: "1051f69", want: "0"},
+		{name: "latest release of the same commit", commit: "01fa2a3e", want: "3"},
+		{name: "ambiguous", commit: "01fa2a3", err: ErrAmbiguousCommit},
+		{name: "not found", commit: "fffffff", err: ErrReleaseNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release, err := matchRelease(releases, tt.commit)
+
+			if errors.Cause(err) != tt.err {
+				t.Fatalf("matchRelease() error = %v, want %v", err, tt.err)
+			}
+
+			if err == nil && release.Deployment != tt.want {
+				t.Errorf("matchRelease() = %s, want %s", release.Deployment, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindReleaseShortCommit(t *testing.T) {
+	for _, commit := range []string{"", "a", "01fa2a"} {
+		if _, err := FindRelease(context.Background(), "blog", "", commit); errors.Cause(err) != ErrShortCommit {
+			t.Errorf("FindRelease(%q) error = %v, want %v", commit, err, ErrShortCommit)
+		}
+	}
+}
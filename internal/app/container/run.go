@@ -6,13 +6,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
-	"net/url"
+	"net"
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/axetroy/hooker/internal/app/glob"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
@@ -20,27 +23,19 @@ import (
 	"github.com/docker/go-connections/nat"
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/storage/filesystem"
 	"github.com/pkg/errors"
 )
 
-func getGitURL(repo, username, password string) string {
-	switch true {
-	case strings.HasPrefix(repo, "github.com"):
-		if username != "" {
-			return fmt.Sprintf("https://%s:%s@%s.git", url.QueryEscape(username), url.QueryEscape(password), repo)
-		} else {
-			return fmt.Sprintf("https://%s.git", repo)
-		}
-	case strings.HasPrefix(repo, "gitlab.com"):
-		// TODO: finish gitlab
-		break
-	}
-
-	return ""
+// 克隆仓库的认证信息, 公开项目为 nil
+type Auth struct {
+	Username string
+	Password string
 }
 
 type ExposePort struct {
@@ -48,15 +43,71 @@ type ExposePort struct {
 	ContainerPort uint64 // 容器的端口
 }
 
+// 部署的配置
+type Options struct {
+	Project     string        // 项目 ID
+	Environment string        // 部署的环境, 为空则为默认环境. 不同环境的容器互不影响
+	Deployment  string        // 部署 ID, 即部署记录的 ID
+	Repo        string        // 仓库名称, 例如 github.com/owner/repo, 用于镜像名
+	URL         string        // 仓库的克隆地址
+	Ref         string        // 分支或者标签, 为空则克隆默认分支
+	Hash        string        // 需要部署的 commit hash, 为空则部署分支的最新提交
+	Ports       []ExposePort  // 端口映射
+	Dockerfile  string        // 指定的 Dockerfile 文件内容, 为空则使用仓库中的 Dockerfile
+	Health      HealthCheck   // 新容器的健康检查
+	Proxy       bool          // 本机端口由 hooker 转发, 为 false 则由 docker 直接发布, 需要先停止旧的容器
+	Image       string        // 部署保留的镜像, 不需要克隆和构建, 为空则构建新的镜像
+	Keep        int           // 保留的镜像数量, 为 0 则为 DefaultKeep
+	Env         []string      // 注入容器的环境变量, 格式为 KEY=VALUE
+	Files       []File        // 挂载到容器中的只读文件
+	Volumes     []Volume      // 挂载的命名卷和本机目录
+	Networks    []Network     // 容器加入的网络
+	Resources   Resources     // 容器的资源限制
+	Restart     RestartPolicy // 容器退出之后的重启策略
+	Compose     string        // docker-compose.yml 在仓库中的路径, 不为空则部署其中的所有服务
+	Paths       []string      // 改动的文件需要符合的规则, 为空则不检查. 支持 glob, 以 ! 开头表示排除
+	Before      string        // 推送之前的 commit hash, 设置了 Paths 时通过 git diff 获取改动的文件
+}
+
+// 没有改动的文件符合规则, 不需要部署
+var ErrNoMatchedChanges = errors.New("no changed files match the path rules")
+
+// 正在被 hooker 停止的容器, 退出时不视为崩溃
+var stopping sync.Map
+
+// 被新的部署暂时停止的旧的容器, 新的容器没有通过健康检查时重新启动, 监控等待部署的结果
+var paused sync.Map
+
 type Runtime struct {
-	repo   string
-	hash   string
-	ports  []ExposePort
-	client *client.Client
-	writer io.Writer
+	project     string
+	environment string
+	deployment  string
+	repo        string
+	url         string
+	ref         string
+	hash        string
+	ports       []ExposePort
+	dockerfile  string
+	health      HealthCheck
+	proxy       bool
+	image       string
+	keep        int
+	variables   []string
+	files       []File
+	volumes     []Volume
+	networks    []Network
+	resources   Resources
+	restart     RestartPolicy
+	compose     string
+	paths       []string
+	before      string
+	client      *client.Client
+	writer      io.Writer
+
+	containerId string // 运行的容器 ID
 }
 
-func NewRuntime(repo string, hash string, ports []ExposePort, writer io.Writer) (*Runtime, error) {
+func NewRuntime(options Options, writer io.Writer) (*Runtime, error) {
 	cli, err := client.NewEnvClient()
 
 	if err != nil {
@@ -64,44 +115,70 @@ func NewRuntime(repo string, hash string, ports []ExposePort, writer io.Writer)
 	}
 
 	r := Runtime{
-		repo:   repo,
-		hash:   hash,
-		ports:  ports,
-		client: cli,
-		writer: writer,
+		project:     options.Project,
+		environment: options.Environment,
+		deployment:  options.Deployment,
+		repo:        options.Repo,
+		url:         options.URL,
+		ref:         options.Ref,
+		hash:        options.Hash,
+		ports:       options.Ports,
+		dockerfile:  options.Dockerfile,
+		health:      options.Health,
+		proxy:       options.Proxy,
+		image:       options.Image,
+		keep:        options.Keep,
+		variables:   options.Env,
+		files:       options.Files,
+		volumes:     options.Volumes,
+		networks:    options.Networks,
+		resources:   options.Resources,
+		restart:     options.Restart,
+		compose:     options.Compose,
+		paths:       options.Paths,
+		before:      options.Before,
+		client:      cli,
+		writer:      writer,
 	}
 
 	return &r, nil
 }
 
-// stop all container run before
-func (r *Runtime) beforeRun(ctx context.Context) error {
-	containers, err := r.client.ContainerList(ctx, types.ContainerListOptions{
-		All: true,
-	})
+// 部署的 commit hash, 没有指定 commit 时为克隆后分支的最新提交
+func (r *Runtime) Hash() string {
+	return r.hash
+}
 
-	if err != nil {
-		return errors.WithStack(err)
+// 运行的容器 ID, 在 Run 成功之后才有值. 旧的容器已经停止之后失败时, 为保留运行的新容器
+func (r *Runtime) ContainerID() string {
+	return r.containerId
+}
+
+// 镜像名, 默认环境为 repo:hash, 其他环境为 repo:environment-hash
+func (r *Runtime) imageName() string {
+	if r.environment == "" {
+		return fmt.Sprintf("%s:%s", r.repo, r.hash)
 	}
 
-	for _, a := range containers {
-		e := func(c types.Container) error {
-			if strings.HasPrefix(c.Image, r.repo+":") {
-				// kill container
-				log.Printf("Stoping container '%s'\n", c.ID)
+	return fmt.Sprintf("%s:%s-%s", r.repo, r.environment, r.hash)
+}
 
-				timeout := 10 * time.Second
+// stop all container run before
+func (r *Runtime) beforeRun(ctx context.Context) error {
+	containers, err := r.containers(ctx)
 
-				if err := r.client.ContainerStop(ctx, c.ID, &timeout); err != nil {
-					return errors.WithStack(err)
-				}
-			}
+	if err != nil {
+		return err
+	}
 
-			return nil
-		}(a)
+	for _, c := range containers {
+		// 不再转发本机端口
+		for _, p := range parsePorts(c.Labels[LabelPorts]) {
+			unroute(p.MachinePort)
+		}
 
-		if e != nil {
-			return errors.WithStack(e)
+		if err := r.stop(ctx, c.ID); err != nil {
+			return err
 		}
 	}
 
@@ -123,8 +200,8 @@ func (r *Runtime) afterRun(ctx context.Context, imageName string) error {
 		}
 
 		if len(imageId) > 0 {
+			// 不强制删除, 正在被容器使用的镜像会被保留
 			if _, err := r.client.ImageRemove(ctx, imageId, types.ImageRemoveOptions{
-				Force:         true,
 				PruneChildren: true,
 			}); err != nil {
 				return err
@@ -135,13 +212,20 @@ func (r *Runtime) afterRun(ctx context.Context, imageName string) error {
 	return nil
 }
 
-func (r *Runtime) clone(ctx context.Context, username string, password string, accessToken string, hash string) (string, error) {
+func (r *Runtime) clone(ctx context.Context, auth *Auth, hash string) (string, error) {
 	var (
 		err error
 	)
 
 	// clone project
-	fs := osfs.New(path.Join("repos", r.repo, r.hash))
+	dir := hash
+
+	if dir == "" {
+		dir = "latest"
+	}
+
+	// 不同环境可能同时部署同一个 commit, 使用单独的目录
+	fs := osfs.New(path.Join("repos", r.repo, r.environment, dir))
 
 	if _, e := os.Stat(fs.Root()); e == nil {
 		// if folder exist. then remove it first
@@ -153,25 +237,28 @@ func (r *Runtime) clone(ctx context.Context, username string, password string, a
 	}
 
 	options := git.CloneOptions{
-		URL:               getGitURL(r.repo, username, password),
-		Progress:          os.Stdout,
+		URL:               r.url,
+		Progress:          r.writer,
 		SingleBranch:      true,
 		Depth:             1,
 		RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
 	}
 
-	if password != "" {
-		options.Auth = &http.BasicAuth{
-			Username: username,
-			Password: password,
-		}
-		options.URL = getGitURL(r.repo, "", "")
-	} else if accessToken != "" {
+	// 需要 git diff 时克隆完整的历史
+	if r.diffRequired() {
+		options.Depth = 0
+	}
+
+	// 克隆对应的分支或标签, 否则只会克隆默认分支
+	if isBranchOrTag(r.ref) {
+		options.ReferenceName = plumbing.ReferenceName(r.ref)
+	}
+
+	if auth != nil {
 		options.Auth = &http.BasicAuth{
-			Username: "access",
-			Password: accessToken,
+			Username: auth.Username,
+			Password: auth.Password,
 		}
-		options.URL = getGitURL(r.repo, "", "")
 	}
 
 	gitDir := path.Join("./", fs.Root(), ".git")
@@ -190,13 +277,50 @@ func (r *Runtime) clone(ctx context.Context, username string, password string, a
 		return "", errors.WithStack(err)
 	}
 
+	// Pull Request 的引用不在 refs/heads 下, 克隆默认分支之后单独拉取
+	if r.ref != "" && !isBranchOrTag(r.ref) {
+		spec := config.RefSpec(fmt.Sprintf("+%s:%s", r.ref, r.ref))
+
+		if err = repo.FetchContext(ctx, &git.FetchOptions{
+			RefSpecs: []config.RefSpec{spec},
+			Depth:    options.Depth,
+			Auth:     options.Auth,
+			Progress: r.writer,
+		}); err != nil && err != git.NoErrAlreadyUpToDate {
+			return "", errors.WithStack(err)
+		}
+
+		err = nil
+
+		if hash == "" {
+			ref, e := repo.Reference(plumbing.ReferenceName(r.ref), true)
+
+			if e != nil {
+				err = e
+				return "", errors.WithStack(err)
+			}
+
+			hash = ref.Hash().String()
+			r.hash = hash
+		}
+	}
+
 	tree, err := repo.Worktree()
 
 	if err != nil {
 		return "", errors.WithStack(err)
 	}
 
-	if err := tree.Checkout(&git.CheckoutOptions{
+	if hash == "" {
+		// 没有指定 commit 则部署分支的最新提交
+		head, err := repo.Head()
+
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		r.hash = head.Hash().String()
+	} else if err := tree.Checkout(&git.CheckoutOptions{
 		Hash:  plumbing.NewHash(hash),
 		Force: true,
 		Keep:  true,
@@ -204,9 +328,79 @@ func (r *Runtime) clone(ctx context.Context, username string, password string, a
 		return "", errors.WithStack(err)
 	}
 
+	if r.diffRequired() {
+		files, e := changedFiles(repo, r.before, r.hash)
+
+		// 找不到推送之前的 commit 时无法确定改动的文件, 例如强制推送, 继续部署
+		if e != nil {
+			_, _ = fmt.Fprintf(r.writer, "Can not get changed files: %v\n", e)
+		} else if !glob.MatchAny(r.paths, files) {
+			err = ErrNoMatchedChanges
+			return "", err
+		}
+	}
+
+	// 使用指定的 Dockerfile 覆盖仓库中的 Dockerfile
+	if r.dockerfile != "" {
+		if err := ioutil.WriteFile(path.Join(fs.Root(), "Dockerfile"), []byte(r.dockerfile), 0644); err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+
 	return fs.Root(), nil
 }
 
+// 是否为分支或者标签, 其他的引用例如 refs/pull/1/head 不能直接克隆
+func isBranchOrTag(ref string) bool {
+	return strings.HasPrefix(ref, "refs/heads/") || strings.HasPrefix(ref, "refs/tags/")
+}
+
+// 是否需要通过 git diff 获取改动的文件
+func (r *Runtime) diffRequired() bool {
+	return len(r.paths) > 0 && r.before != ""
+}
+
+// 获取两个提交之间改动的文件
+func changedFiles(repo *git.Repository, from string, to string) ([]string, error) {
+	var trees []*object.Tree
+
+	for _, hash := range []string{from, to} {
+		commit, err := repo.CommitObject(plumbing.NewHash(hash))
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		tree, err := commit.Tree()
+
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		trees = append(trees, tree)
+	}
+
+	changes, err := object.DiffTree(trees[0], trees[1])
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	files := make([]string, 0, len(changes))
+
+	for _, c := range changes {
+		if c.From.Name != "" {
+			files = append(files, c.From.Name)
+		}
+
+		if c.To.Name != "" && c.To.Name != c.From.Name {
+			files = append(files, c.To.Name)
+		}
+	}
+
+	return files, nil
+}
+
 func (r *Runtime) buildImage(ctx context.Context, rootPath string, imageName string) (io.ReadCloser, error) {
 	reader, err := archive.TarWithOptions(rootPath, &archive.TarOptions{})
 
@@ -220,6 +414,7 @@ func (r *Runtime) buildImage(ctx context.Context, rootPath string, imageName str
 		ForceRemove:    true,
 		PullParent:     true,
 		Tags:           []string{imageName},
+		Labels:         r.labels(),
 	}
 
 	log.Println("Building image...")
@@ -233,12 +428,65 @@ func (r *Runtime) buildImage(ctx context.Context, rootPath string, imageName str
 	return buildResponse.Body, nil
 }
 
-func (r *Runtime) Run(ctx context.Context, username string, password string, accessToken string, ch chan error) error {
+// 输出构建或者拉取镜像的进度, 失败时返回错误
+func (r *Runtime) printProgress(output io.Reader) error {
+	reader := bufio.NewReader(output)
+
+	// copy out response of stream
+	for {
+		line, _, err := reader.ReadLine()
+
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		type Progress struct {
+			Stream string `json:"stream"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}
+
+		var p Progress
+
+		if err := json.Unmarshal(line, &p); err != nil {
+			return err
+		}
+
+		if p.Stream != "" {
+			if _, err := r.writer.Write([]byte(p.Stream)); err != nil {
+				return err
+			}
+		} else if p.Status != "" {
+			if _, err := fmt.Fprintln(r.writer, p.Status); err != nil {
+				return err
+			}
+		}
+
+		// 构建失败
+		if p.Error != "" {
+			_, _ = r.writer.Write([]byte(p.Error + "\n"))
+			return errors.New(p.Error)
+		}
+	}
+
+	return nil
+}
+
+func (r *Runtime) Run(ctx context.Context, auth *Auth, ch chan error) error {
+	// 部署保留的镜像, 不需要克隆和构建
+	if r.image != "" {
+		return r.runRelease(ctx, ch)
+	}
+
 	var (
 		rootPath string
 		err      error
 	)
-	if p, err := r.clone(ctx, username, password, accessToken, r.hash); err != nil {
+	if p, err := r.clone(ctx, auth, r.hash); err != nil {
 		return errors.WithStack(err)
 	} else {
 		rootPath = p
@@ -250,7 +498,13 @@ func (r *Runtime) Run(ctx context.Context, username string, password string, acc
 		}
 	}()
 
-	imageName := fmt.Sprintf("%s:%s", r.repo, r.hash)
+	// 使用 docker-compose.yml 部署多个服务
+	if r.compose != "" {
+		err = r.runCompose(ctx, rootPath, ch)
+		return err
+	}
+
+	imageName := r.imageName()
 
 	output, err := r.buildImage(ctx, rootPath, imageName)
 
@@ -258,106 +512,398 @@ func (r *Runtime) Run(ctx context.Context, username string, password string, acc
 		return errors.WithStack(err)
 	}
 
-	reader := bufio.NewReader(output)
+	if err = r.printProgress(output); err != nil {
+		return err
+	}
 
-	// copy out response of stream
-	for {
-		// var b []byte = make([]byte, 1024*10)
+	// 构建完成之后不再响应取消, 避免停止了旧的容器却没有启动新的容器
+	if err := ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
 
-		// _, err := output.Read(b)
+	ctx = context.Background()
 
-		line, _, err := reader.ReadLine()
+	defer func() {
+		_ = output.Close()
+	}()
 
-		if err != nil && err != io.EOF {
-			return err
+	// 新的容器没有通过健康检查时删除新的镜像, 旧的容器继续运行
+	if err = r.start(ctx, imageName, ch); err != nil {
+		if e := r.afterRun(ctx, imageName); e != nil {
+			log.Printf("%+v\n", e)
 		}
 
-		if err == io.EOF {
-			break
-		}
+		return err
+	}
 
-		type Progress struct {
-			Stream string `json:"stream"`
-		}
+	if e := r.pruneReleases(ctx); e != nil {
+		log.Printf("%+v\n", e)
+	}
 
-		var p Progress
+	return nil
+}
 
-		if err := json.Unmarshal(line, &p); err != nil {
-			return err
-		}
+// 使用保留的镜像启动容器, 失败时不删除镜像
+func (r *Runtime) runRelease(ctx context.Context, ch chan error) error {
+	img, _, err := r.client.ImageInspectWithRaw(ctx, r.image)
 
-		if _, err := r.writer.Write([]byte(p.Stream)); err != nil {
-			return err
-		}
+	if err != nil {
+		return errors.WithStack(err)
 	}
 
-	// _, err = io.Copy(r.writer, output)
+	if img.Config == nil || img.Config.Labels[LabelProject] != r.project {
+		return errors.Errorf("image '%s' does not belong to project '%s'", r.image, r.project)
+	}
 
-	defer func() {
-		_ = output.Close()
-	}()
+	r.hash = img.Config.Labels[LabelCommit]
+
+	_, _ = fmt.Fprintf(r.writer, "Deploying image '%s' without building\n", r.image)
 
-	// stop all container run before
-	if err := r.beforeRun(ctx); err != nil {
+	if err := ctx.Err(); err != nil {
 		return errors.WithStack(err)
 	}
 
-	// remove all images create before
-	defer func() {
-		if err != nil {
-			if er := r.afterRun(ctx, imageName); err != nil {
-				err = er
+	return r.start(context.Background(), r.image, ch)
+}
+
+// 先启动新的容器, 健康检查通过之后把本机端口切换到新的容器, 再停止旧的容器
+func (r *Runtime) start(ctx context.Context, imageName string, ch chan error) (err error) {
+	name := ContainerName(r.project, r.environment)
+	candidate := name + "-next"
+
+	containers, err := r.containers(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	var old []types.Container
+
+	for _, c := range containers {
+		// 上一次部署失败留下的容器
+		if containerName(c) == candidate {
+			if err := r.removeContainer(ctx, c.ID); err != nil {
+				return err
 			}
+
+			continue
 		}
-	}()
+
+		old = append(old, c)
+	}
+
+	portMap, exposedPorts := r.portBindings()
+
+	binds, err := r.prepareVolumes(ctx)
 
 	if err != nil {
-		return errors.WithStack(err)
+		return err
 	}
 
-	portMap := nat.PortMap{}
-	exposedPorts := nat.PortSet{}
+	networking, err := r.prepareNetworks(ctx)
 
-	for _, p := range r.ports {
-		portMap[nat.Port(fmt.Sprintf("%d/tcp", p.ContainerPort))] = []nat.PortBinding{
-			{HostIP: "0.0.0.0", HostPort: fmt.Sprintf("%d", p.MachinePort)},
-		}
-		exposedPorts[nat.Port(fmt.Sprintf("%d/tcp", p.ContainerPort))] = struct{}{}
+	if err != nil {
+		return err
+	}
+
+	files, err := r.writeFiles()
+
+	if err != nil {
+		return err
 	}
 
 	hostConfig := &container.HostConfig{
 		PortBindings: portMap,
-		AutoRemove:   true,
+		Binds:        append(binds, files...),
+		NetworkMode:  container.NetworkMode(r.networkMode()),
+		Resources:    r.containerResources(),
 	}
 
 	resp, err := r.client.ContainerCreate(ctx, &container.Config{
 		Image:        imageName,
 		ExposedPorts: exposedPorts,
-	}, hostConfig, nil, "")
+		Labels:       r.labels(),
+		Env:          r.env(),
+	}, hostConfig, networking, candidate)
 
 	if err != nil {
+		_ = os.RemoveAll(path.Join(r.filesDir(), r.deployment))
 		return errors.WithStack(err)
 	}
 
-	if err := r.client.ContainerStart(context.Background(), resp.ID, types.ContainerStartOptions{}); err != nil {
+	// 旧的容器开始删除之后, 即使失败也保留新的容器, 否则没有容器在运行
+	keep := false
+
+	// 为了发布本机端口暂时停止的旧的容器
+	var stopped []types.Container
+
+	defer func() {
+		if err == nil || keep {
+			return
+		}
+
+		if lines, e := r.tail(ctx, resp.ID, 20); e == nil && len(lines) > 0 {
+			_, _ = fmt.Fprintf(r.writer, "Last logs of container '%s':\n%s\n", resp.ID, strings.Join(lines, "\n"))
+		}
+
+		if e := r.stop(ctx, resp.ID); e != nil {
+			log.Printf("%+v\n", e)
+		}
+
+		// 新的容器释放本机端口之后才能重新启动旧的容器
+		if len(stopped) > 0 {
+			_, _ = fmt.Fprintf(r.writer, "Restarting old containers\n")
+		}
+
+		for _, c := range stopped {
+			if e := r.resume(ctx, c); e != nil {
+				log.Printf("%+v\n", e)
+				_, _ = fmt.Fprintf(r.writer, "Failed to restart container '%s': %v\n", c.ID, e)
+			}
+		}
+
+		_ = os.RemoveAll(path.Join(r.filesDir(), r.deployment))
+	}()
+
+	if err = r.connectNetworks(ctx, resp.ID); err != nil {
+		return err
+	}
+
+	// 本机端口由 docker 直接发布时, 旧的容器和转发占用着本机端口, 只能先停止旧的容器.
+	// 旧的容器只停止不删除, 新的容器通过健康检查之后才删除
+	if !r.proxy && len(r.ports) > 0 {
+		for _, p := range r.ports {
+			unroute(p.MachinePort)
+		}
+
+		_, _ = fmt.Fprintf(r.writer, "Stopping old containers to publish ports\n")
+
+		for _, c := range old {
+			stopped = append(stopped, c)
+
+			if err = r.pause(ctx, c.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = r.client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
 		return errors.WithStack(err)
 	}
 
 	log.Printf("Start container '%s'\n", imageName)
+	_, _ = fmt.Fprintf(r.writer, "Waiting for container '%s' to be healthy\n", candidate)
+
+	if err = r.waitHealthy(ctx, resp.ID); err != nil {
+		return err
+	}
+
+	keep, err = r.swap(ctx, resp.ID, old)
+
+	if err != nil && !keep {
+		return err
+	}
+
+	if e := r.client.ContainerRename(ctx, resp.ID, name); e != nil && err == nil {
+		err = errors.WithStack(e)
+	}
+
+	r.containerId = resp.ID
+
+	// 旧的容器已经停止, 不再需要旧的文件
+	if e := r.removeFiles(r.deployment); e != nil {
+		log.Printf("%+v\n", e)
+	}
+
+	if err != nil {
+		_, _ = fmt.Fprintf(r.writer, "Old containers are stopped, keep container '%s' running: %v\n", candidate, err)
+	} else {
+		_, _ = fmt.Fprintf(r.writer, "Container '%s' is running\n", name)
+	}
 
-	go func() {
-		var err error
-		// wait until container exit
-		_, err = r.client.ContainerWait(context.Background(), resp.ID)
+	go r.supervise(resp.ID, ch)
+
+	return err
+}
+
+// 容器发布的端口. 使用转发时容器的端口发布到 127.0.0.1 的随机端口, 本机端口由 hooker 转发, 切换容器时不需要重新监听
+func (r *Runtime) portBindings() (nat.PortMap, nat.PortSet) {
+	portMap := nat.PortMap{}
+	exposedPorts := nat.PortSet{}
+
+	for _, p := range r.ports {
+		binding := nat.PortBinding{HostIP: "127.0.0.1", HostPort: ""}
+
+		if !r.proxy {
+			binding = nat.PortBinding{HostPort: fmt.Sprintf("%d", p.MachinePort)}
+		}
+
+		portMap[nat.Port(fmt.Sprintf("%d/tcp", p.ContainerPort))] = []nat.PortBinding{binding}
+		exposedPorts[nat.Port(fmt.Sprintf("%d/tcp", p.ContainerPort))] = struct{}{}
+	}
+
+	return portMap, exposedPorts
+}
+
+// 把本机端口转发到新的容器, 然后停止旧的容器. 返回旧的容器是否已经开始停止,
+// 为 true 时旧的容器可能已经被删除, 即使返回错误也需要保留新的容器
+func (r *Runtime) swap(ctx context.Context, id string, old []types.Container) (bool, error) {
+	// 本机端口由 docker 发布, 不需要转发
+	if !r.proxy {
+		return true, r.stopAll(ctx, old)
+	}
+
+	info, err := r.client.ContainerInspect(ctx, id)
+
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	// 先获取所有的地址, 避免转发了一部分端口之后才失败
+	targets := make([]string, len(r.ports))
+
+	for i, p := range r.ports {
+		if targets[i], err = publishedAddress(info, p.ContainerPort); err != nil {
+			return false, err
+		}
+	}
+
+	stopped := false
+
+	for i, p := range r.ports {
+		err := route(p.MachinePort, targets[i])
+
+		// 旧版本创建的容器直接占用了本机端口, 只能先停止旧的容器
+		if err != nil && !stopped {
+			stopped = true
+
+			if err = r.stopAll(ctx, old); err != nil {
+				return true, err
+			}
+
+			err = route(p.MachinePort, targets[i])
+		}
 
 		if err != nil {
-			err = errors.WithStack(err)
+			return stopped, err
 		}
+	}
+
+	if stopped {
+		return true, nil
+	}
+
+	return true, r.stopAll(ctx, old)
+}
+
+// 停止并且删除容器
+func (r *Runtime) stop(ctx context.Context, id string) error {
+	log.Printf("Stoping container '%s'\n", id)
 
-		defer func() {
-			ch <- err
-		}()
+	stopping.Store(id, true)
+
+	defer func() {
+		settle(id, false)
+		paused.Delete(id)
 	}()
 
+	timeout := 10 * time.Second
+
+	if err := r.client.ContainerStop(ctx, id, &timeout); err != nil && !client.IsErrContainerNotFound(err) {
+		return errors.WithStack(err)
+	}
+
+	// 删除容器之后才能使用同样的容器名称
+	return r.removeContainer(ctx, id)
+}
+
+// 停止但不删除旧的容器, 新的容器没有通过健康检查时重新启动
+func (r *Runtime) pause(ctx context.Context, id string) error {
+	log.Printf("Pausing container '%s'\n", id)
+
+	paused.Store(id, make(chan bool, 1))
+
+	timeout := 10 * time.Second
+
+	if err := r.client.ContainerStop(ctx, id, &timeout); err != nil && !client.IsErrContainerNotFound(err) {
+		return errors.WithStack(err)
+	}
+
 	return nil
 }
+
+// 重新启动暂时停止的旧的容器, 并且恢复发布到 127.0.0.1 的端口的转发
+func (r *Runtime) resume(ctx context.Context, c types.Container) error {
+	if err := r.client.ContainerStart(ctx, c.ID, types.ContainerStartOptions{}); err != nil {
+		settle(c.ID, false)
+		return errors.WithStack(err)
+	}
+
+	settle(c.ID, true)
+
+	info, err := r.client.ContainerInspect(ctx, c.ID)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if info.NetworkSettings == nil {
+		return nil
+	}
+
+	for _, p := range parsePorts(c.Labels[LabelPorts]) {
+		for _, binding := range info.NetworkSettings.Ports[nat.Port(fmt.Sprintf("%d/tcp", p.ContainerPort))] {
+			if binding.HostIP != "127.0.0.1" || binding.HostPort == "" {
+				continue
+			}
+
+			if err := route(p.MachinePort, net.JoinHostPort(binding.HostIP, binding.HostPort)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// 通知监控暂时停止的容器是否已经重新启动
+func settle(id string, resumed bool) {
+	if v, ok := paused.Load(id); ok {
+		select {
+		case v.(chan bool) <- resumed:
+		default:
+		}
+	}
+}
+
+func (r *Runtime) stopAll(ctx context.Context, containers []types.Container) error {
+	for _, c := range containers {
+		if err := r.stop(ctx, c.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 容器端口发布到本机的地址
+func publishedAddress(info types.ContainerJSON, port uint64) (string, error) {
+	if info.NetworkSettings != nil {
+		for _, binding := range info.NetworkSettings.Ports[nat.Port(fmt.Sprintf("%d/tcp", port))] {
+			if binding.HostPort != "" {
+				return net.JoinHostPort("127.0.0.1", binding.HostPort), nil
+			}
+		}
+	}
+
+	return "", errors.Errorf("port %d of container '%s' is not published", port, info.ID)
+}
+
+// 容器名称, 不包含开头的 /
+func containerName(c types.Container) string {
+	if len(c.Names) == 0 {
+		return ""
+	}
+
+	return strings.TrimPrefix(c.Names[0], "/")
+}
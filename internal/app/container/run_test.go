@@ -0,0 +1,333 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// 创建一个本地的裸仓库, master 和 Pull Request 的引用指向不同的提交
+func bareRepository(t *testing.T) (dir string, master string, pull string) {
+	src := t.TempDir()
+	dir = t.TempDir()
+
+	repo, err := git.PlainInit(src, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := repo.Worktree()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit := func(content string) plumbing.Hash {
+		if err := ioutil.WriteFile(filepath.Join(src, "README.md"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tree.Add("README.md"); err != nil {
+			t.Fatal(err)
+		}
+
+		hash, err := tree.Commit(content, &git.CommitOptions{
+			Author: &object.Signature{Name: "hooker", Email: "hooker@example.com", When: time.Now()},
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return hash
+	}
+
+	first := commit("master")
+	second := commit("pull request")
+
+	if err := repo.Storer.SetReference(plumbing.NewHashReference("refs/pull/1/head", second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Storer.SetReference(plumbing.NewHashReference("refs/merge-requests/1/head", second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(plumbing.Master, first)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := git.PlainInit(dir, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.CreateRemote(&config.RemoteConfig{Name: "bare", URLs: []string{dir}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Push(&git.PushOptions{
+		RemoteName: "bare",
+		RefSpecs: []config.RefSpec{
+			"refs/heads/master:refs/heads/master",
+			"refs/pull/1/head:refs/pull/1/head",
+			"refs/merge-requests/1/head:refs/merge-requests/1/head",
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, first.String(), second.String()
+}
+
+func TestClone(t *testing.T) {
+	url, master, pull := bareRepository(t)
+
+	wd, err := os.Getwd()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = os.Chdir(wd)
+	}()
+
+	tests := []struct {
+		name    string
+		ref     string
+		hash    string
+		want    string
+		content string
+	}{
+		{name: "default branch", want: master, content: "master"},
+		{name: "branch", ref: "refs/heads/master", want: master, content: "master"},
+		{name: "pull request", ref: "refs/pull/1/head", want: pull, content: "pull request"},
+		{name: "merge request", ref: "refs/merge-requests/1/head", want: pull, content: "pull request"},
+		{name: "pull request commit", ref: "refs/pull/1/head", hash: pull, want: pull, content: "pull request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Runtime{
+				repo:   "github.com/axetroy/blog",
+				url:    url,
+				ref:    tt.ref,
+				hash:   tt.hash,
+				writer: ioutil.Discard,
+			}
+
+			dir, err := r.clone(context.Background(), nil, tt.hash)
+
+			if err != nil {
+				t.Fatalf("clone() error = %+v", err)
+			}
+
+			if r.Hash() != tt.want {
+				t.Errorf("Hash() = %s, want %s", r.Hash(), tt.want)
+			}
+
+			b, err := ioutil.ReadFile(filepath.Join(dir, "README.md"))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(b) != tt.content {
+				t.Errorf("README.md = %q, want %q", b, tt.content)
+			}
+		})
+	}
+}
+
+// 模拟 docker 的容器接口, 新创建的容器启动之后立即退出
+type fakeDocker struct {
+	mu         sync.Mutex
+	containers map[string]*fakeContainer
+	done       chan struct{}
+}
+
+type fakeContainer struct {
+	name    string
+	labels  map[string]string
+	running bool
+	removed bool
+	exited  chan struct{}
+}
+
+func newFakeDocker(t *testing.T, old *fakeContainer) (*fakeDocker, *client.Client) {
+	d := &fakeDocker{containers: map[string]*fakeContainer{"old": old}, done: make(chan struct{})}
+
+	server := httptest.NewServer(d)
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(d.done) })
+
+	cli, err := client.NewClient("tcp://"+server.Listener.Addr().String(), "1.25", nil, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d, cli
+}
+
+func (d *fakeDocker) get(id string) *fakeContainer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.containers[id]
+}
+
+func (d *fakeDocker) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/v1.25"), "/"), "/")
+
+	if len(parts) == 2 && parts[1] == "json" && parts[0] == "containers" {
+		d.mu.Lock()
+		list := []types.Container{}
+		for id, c := range d.containers {
+			if !c.removed {
+				list = append(list, types.Container{ID: id, Names: []string{"/" + c.name}, Labels: c.labels})
+			}
+		}
+		d.mu.Unlock()
+
+		_ = json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "create" {
+		d.mu.Lock()
+		d.containers["next"] = &fakeContainer{name: req.URL.Query().Get("name")}
+		d.mu.Unlock()
+
+		_ = json.NewEncoder(w).Encode(container.ContainerCreateCreatedBody{ID: "next"})
+		return
+	}
+
+	c := d.get(parts[len(parts)-1])
+
+	if len(parts) == 3 {
+		c = d.get(parts[1])
+	}
+
+	if c == nil || c.removed {
+		http.Error(w, "no such container", http.StatusNotFound)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch {
+	case req.Method == http.MethodDelete:
+		c.removed = true
+	case len(parts) == 3 && parts[2] == "start":
+		// 新的容器启动之后立即退出
+		if parts[1] != "next" {
+			c.running = true
+			c.exited = make(chan struct{})
+		}
+	case len(parts) == 3 && parts[2] == "stop":
+		if c.running {
+			c.running = false
+			close(c.exited)
+		}
+	case len(parts) == 3 && parts[2] == "wait":
+		exited := c.exited
+
+		d.mu.Unlock()
+		select {
+		case <-exited:
+		case <-d.done:
+		}
+		d.mu.Lock()
+
+		_ = json.NewEncoder(w).Encode(container.ContainerWaitOKBody{StatusCode: 0})
+	case len(parts) == 3 && parts[2] == "json":
+		_ = json.NewEncoder(w).Encode(types.ContainerJSON{ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    parts[1],
+			State: &types.ContainerState{Running: c.running, ExitCode: 1},
+		}})
+	default:
+		http.Error(w, "not implemented", http.StatusNotFound)
+	}
+}
+
+func TestStartKeepsOldContainerWhenUnhealthy(t *testing.T) {
+	defer func(dir string) {
+		DataDir = dir
+	}(DataDir)
+
+	DataDir = t.TempDir()
+
+	old := &fakeContainer{
+		name:    ContainerName("blog", ""),
+		labels:  map[string]string{LabelProject: "blog", LabelPorts: "18080:80"},
+		running: true,
+		exited:  make(chan struct{}),
+	}
+
+	d, cli := newFakeDocker(t, old)
+
+	r := Runtime{
+		project:    "blog",
+		deployment: "new",
+		ports:      []ExposePort{{MachinePort: 18080, ContainerPort: 80}},
+		health:     HealthCheck{Timeout: time.Second, Interval: 10 * time.Millisecond},
+		client:     cli,
+		writer:     ioutil.Discard,
+	}
+
+	// 旧的容器由上一次部署监控
+	exits := make(chan error)
+
+	go r.supervise("old", exits)
+
+	if err := r.start(context.Background(), "blog:new", make(chan error)); err == nil {
+		t.Fatal("start() error = nil, want health check error")
+	}
+
+	if next := d.get("next"); next == nil || !next.removed {
+		t.Error("unhealthy container is not removed")
+	}
+
+	d.mu.Lock()
+	running, removed := old.running, old.removed
+	d.mu.Unlock()
+
+	if !running || removed {
+		t.Errorf("old container running = %v, removed = %v, want running", running, removed)
+	}
+
+	// 旧的容器重新启动之后继续被监控, 暂时停止不视为崩溃
+	select {
+	case e, ok := <-exits:
+		t.Errorf("old container supervision got %v, closed = %v", e, !ok)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if !Supervised("old") {
+		t.Error("old container is not supervised")
+	}
+}
@@ -0,0 +1,653 @@
+package container
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/go-connections/nat"
+	"github.com/pkg/errors"
+)
+
+// compose 部署的容器, 网络和卷的标签, 与 docker-compose 相同
+const (
+	labelComposeProject = "com.docker.compose.project"
+	labelComposeService = "com.docker.compose.service"
+)
+
+// 服务的容器配置, 配置没有变化的服务不会被重新创建
+type serviceConfig struct {
+	Config     *container.Config
+	HostConfig *container.HostConfig
+	Networks   map[string][]string // 网络 -> 别名
+	ImageId    string
+	Files      []File // 挂载的文件, 每次部署的目录不同, 只比较路径和内容
+}
+
+// compose 项目名, 与单个容器部署的容器名相同, 例如 hooker-project-pr-1
+func (r *Runtime) composeProject() string {
+	return ContainerName(r.project, r.environment)
+}
+
+// 服务的镜像名, 例如 github.com/owner/repo/web:hash
+func (r *Runtime) serviceImage(service string) string {
+	name := r.imageName()
+	i := strings.LastIndex(name, ":")
+
+	return fmt.Sprintf("%s/%s%s", name[:i], strings.ToLower(service), name[i:])
+}
+
+// 服务的容器名, 例如 hooker-project_web_1
+func (r *Runtime) serviceContainer(service string) string {
+	return nameReg.ReplaceAllString(fmt.Sprintf("%s_%s_1", r.composeProject(), service), "_")
+}
+
+// 仓库中的路径, 不允许超出仓库的目录. 符号链接会被解析, 仓库中的 x -> / 不能用来挂载本机的根目录
+func inside(root string, p string) (string, error) {
+	joined := filepath.Join(root, p)
+
+	if !within(root, joined) {
+		return "", errors.Errorf("path '%s' is outside of the repository", p)
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	resolved, err := evalSymlinks(joined)
+
+	if err != nil {
+		return "", err
+	}
+
+	if !within(realRoot, resolved) {
+		return "", errors.Errorf("path '%s' is outside of the repository", p)
+	}
+
+	// 返回解析之后的路径, 检查之后替换符号链接也不会影响挂载的目录
+	return resolved, nil
+}
+
+func within(root string, p string) bool {
+	return p == root || strings.HasPrefix(p, root+string(filepath.Separator))
+}
+
+// 解析路径中的符号链接, 不存在的部分保持不变, 由 docker 创建
+func evalSymlinks(p string) (string, error) {
+	rest := ""
+
+	for {
+		resolved, err := filepath.EvalSymlinks(p)
+
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+
+		if !os.IsNotExist(err) {
+			return "", errors.WithStack(err)
+		}
+
+		// 指向不存在的路径的符号链接, 无法确定 docker 创建的目录位置
+		if _, e := os.Lstat(p); e == nil {
+			return "", errors.Errorf("path '%s' is a dangling symbolic link", p)
+		}
+
+		parent := filepath.Dir(p)
+
+		if parent == p {
+			return "", errors.WithStack(err)
+		}
+
+		rest = filepath.Join(filepath.Base(p), rest)
+		p = parent
+	}
+}
+
+// 按照 docker-compose.yml 部署所有服务, 依赖的服务先启动, 配置没有变化的服务保持运行
+func (r *Runtime) runCompose(ctx context.Context, rootPath string, ch chan error) error {
+	// compose 的服务使用 docker 的重启策略, 不需要监控
+	defer close(ch)
+
+	root, err := filepath.Abs(rootPath)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	file, err := inside(root, r.compose)
+
+	if err != nil {
+		return err
+	}
+
+	compose, err := readCompose(file)
+
+	if err != nil {
+		return err
+	}
+
+	order, err := compose.order()
+
+	if err != nil {
+		return err
+	}
+
+	images := map[string]string{}
+
+	for _, name := range order {
+		service := compose.Services[name]
+
+		if service.Build == nil {
+			images[name] = service.Image
+			continue
+		}
+
+		image, err := r.buildService(ctx, root, name, service.Build)
+
+		if err != nil {
+			return errors.Wrapf(err, "build service '%s'", name)
+		}
+
+		images[name] = image
+	}
+
+	// 构建完成之后不再响应取消, 避免停止了旧的服务却没有启动新的服务
+	if err := ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	ctx = context.Background()
+
+	containers, err := r.containers(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	existing := map[string]types.Container{}
+
+	for _, c := range containers {
+		service := c.Labels[LabelService]
+
+		if _, ok := compose.Services[service]; ok && containerName(c) == r.serviceContainer(service) {
+			existing[service] = c
+			continue
+		}
+
+		// 单个容器部署的容器, 或者已经从 compose 文件中删除的服务
+		for _, p := range parsePorts(c.Labels[LabelPorts]) {
+			unroute(p.MachinePort)
+		}
+
+		_, _ = fmt.Fprintf(r.writer, "Removing container '%s'\n", containerName(c))
+
+		if err := r.stop(ctx, c.ID); err != nil {
+			return err
+		}
+	}
+
+	labels := map[string]string{labelComposeProject: r.composeProject()}
+
+	// 没有指定网络的服务加入默认网络
+	for _, s := range compose.Services {
+		if _, ok := compose.Networks["default"]; !ok && len(s.Networks) == 0 {
+			if err := r.ensureNetwork(ctx, compose.networkName(r.composeProject(), "default"), "", labels); err != nil {
+				return err
+			}
+
+			break
+		}
+	}
+
+	for name, n := range compose.Networks {
+		if n != nil && n.External {
+			continue
+		}
+
+		driver := ""
+
+		if n != nil {
+			driver = n.Driver
+		}
+
+		if err := r.ensureNetwork(ctx, compose.networkName(r.composeProject(), name), driver, labels); err != nil {
+			return err
+		}
+	}
+
+	for name, v := range compose.Volumes {
+		if v != nil && v.External {
+			continue
+		}
+
+		driver := ""
+
+		if v != nil {
+			driver = v.Driver
+		}
+
+		if err := r.ensureVolume(ctx, compose.volumeName(r.composeProject(), name), driver, labels); err != nil {
+			return err
+		}
+	}
+
+	files, err := r.writeFiles()
+
+	if err != nil {
+		return err
+	}
+
+	for _, name := range order {
+		var old *types.Container
+
+		if c, ok := existing[name]; ok {
+			old = &c
+		}
+
+		if err := r.startService(ctx, compose, root, name, images[name], files, old); err != nil {
+			return errors.Wrapf(err, "start service '%s'", name)
+		}
+	}
+
+	if e := r.removeFiles(r.deployment); e != nil {
+		log.Printf("%+v\n", e)
+	}
+
+	if e := r.pruneServiceImages(ctx); e != nil {
+		log.Printf("%+v\n", e)
+	}
+
+	return nil
+}
+
+// 构建服务的镜像
+func (r *Runtime) buildService(ctx context.Context, root string, name string, build *Build) (string, error) {
+	dir, err := inside(root, build.Context)
+
+	if err != nil {
+		return "", err
+	}
+
+	reader, err := archive.TarWithOptions(dir, &archive.TarOptions{})
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	image := r.serviceImage(name)
+
+	args := map[string]*string{}
+
+	for _, a := range build.Args {
+		kv := strings.SplitN(a, "=", 2)
+
+		if len(kv) == 2 {
+			args[kv[0]] = &kv[1]
+		} else {
+			args[kv[0]] = nil
+		}
+	}
+
+	labels := r.labels()
+	labels[LabelService] = name
+
+	_, _ = fmt.Fprintf(r.writer, "Building service '%s'\n", name)
+
+	res, err := r.client.ImageBuild(ctx, reader, types.ImageBuildOptions{
+		Remove:      true,
+		ForceRemove: true,
+		PullParent:  true,
+		Tags:        []string{image},
+		Dockerfile:  build.Dockerfile,
+		BuildArgs:   args,
+		Labels:      labels,
+	})
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	if err := r.printProgress(res.Body); err != nil {
+		return "", err
+	}
+
+	return image, nil
+}
+
+// 拉取本机不存在的镜像, 返回镜像 ID
+func (r *Runtime) pullImage(ctx context.Context, image string) (string, error) {
+	info, _, err := r.client.ImageInspectWithRaw(ctx, image)
+
+	if err == nil {
+		return info.ID, nil
+	}
+
+	if !client.IsErrImageNotFound(err) {
+		return "", errors.WithStack(err)
+	}
+
+	_, _ = fmt.Fprintf(r.writer, "Pulling image '%s'\n", image)
+
+	output, err := r.client.ImagePull(ctx, image, types.ImagePullOptions{})
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	defer func() {
+		_ = output.Close()
+	}()
+
+	if err := r.printProgress(output); err != nil {
+		return "", err
+	}
+
+	info, _, err = r.client.ImageInspectWithRaw(ctx, image)
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return info.ID, nil
+}
+
+// 生成服务的容器配置
+func (r *Runtime) serviceConfig(compose *Compose, root string, name string, imageId string, image string) (*serviceConfig, error) {
+	service := compose.Services[name]
+
+	exposed, bindings, err := nat.ParsePortSpecs(service.Ports)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// 预览环境与默认环境使用同一个 compose 文件, 由 docker 分配本机端口, 避免端口冲突
+	if r.environment != "" {
+		bindings = ephemeralPorts(bindings)
+	}
+
+	var (
+		binds     []string
+		anonymous = map[string]struct{}{}
+	)
+
+	for _, v := range service.Volumes {
+		parts := strings.Split(v, ":")
+
+		// 只有容器中的路径, 为匿名卷
+		if len(parts) == 1 {
+			anonymous[parts[0]] = struct{}{}
+			continue
+		}
+
+		source := parts[0]
+
+		switch {
+		case strings.HasPrefix(source, "."):
+			// 仓库中的目录
+			if source, err = inside(root, source); err != nil {
+				return nil, err
+			}
+		case filepath.IsAbs(source):
+			if err := CheckMountSource(source); err != nil {
+				return nil, err
+			}
+		default:
+			if _, ok := compose.Volumes[source]; !ok {
+				return nil, errors.Errorf("volume '%s' is not defined", source)
+			}
+
+			source = compose.volumeName(r.composeProject(), source)
+		}
+
+		binds = append(binds, strings.Join(append([]string{source}, parts[1:]...), ":"))
+	}
+
+	networks := map[string][]string{}
+
+	if len(service.Networks) == 0 {
+		networks[compose.networkName(r.composeProject(), "default")] = []string{name}
+	}
+
+	for n, aliases := range service.Networks {
+		// 服务名也是网络中的别名
+		networks[compose.networkName(r.composeProject(), n)] = append([]string{name}, aliases...)
+	}
+
+	memory, err := ParseMemory(service.MemLimit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	restart := service.Restart
+
+	if restart == "no" {
+		restart = ""
+	}
+
+	files, _ := r.serviceFiles(service.Environment, nil)
+
+	config := &container.Config{
+		Image:        image,
+		Env:          r.serviceEnv(service.Environment),
+		Cmd:          strslice.StrSlice(service.Command),
+		Entrypoint:   strslice.StrSlice(service.Entrypoint),
+		WorkingDir:   service.WorkingDir,
+		User:         service.User,
+		ExposedPorts: exposed,
+		Volumes:      anonymous,
+	}
+
+	hostConfig := &container.HostConfig{
+		PortBindings:  bindings,
+		Binds:         binds,
+		RestartPolicy: container.RestartPolicy{Name: restart},
+		Resources: container.Resources{
+			NanoCPUs:  int64(service.CPUs * 1e9),
+			Memory:    memory,
+			PidsLimit: service.PidsLimit,
+		},
+	}
+
+	return &serviceConfig{
+		Config:     config,
+		HostConfig: hostConfig,
+		Networks:   networks,
+		ImageId:    imageId,
+		Files:      files,
+	}, nil
+}
+
+// 去掉发布的本机端口, 容器的端口发布到 docker 分配的随机端口
+func ephemeralPorts(bindings nat.PortMap) nat.PortMap {
+	result := nat.PortMap{}
+
+	for port, list := range bindings {
+		for _, b := range list {
+			result[port] = append(result[port], nat.PortBinding{HostIP: b.HostIP})
+		}
+	}
+
+	return result
+}
+
+// 配置的摘要, 不包含每次部署都会变化的内置变量
+func (c *serviceConfig) hash() string {
+	config := *c.Config
+	config.Env = nil
+
+	for _, e := range c.Config.Env {
+		if !strings.HasPrefix(e, "HOOKER_DEPLOYMENT=") && !strings.HasPrefix(e, "HOOKER_COMMIT=") && !strings.HasPrefix(e, "HOOKER_REF=") {
+			config.Env = append(config.Env, e)
+		}
+	}
+
+	b, _ := json.Marshal([]interface{}{config, c.HostConfig, c.Networks, c.ImageId, c.Files})
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// 启动或者替换服务的容器, 配置没有变化并且正在运行的容器保持不变
+func (r *Runtime) startService(ctx context.Context, compose *Compose, root string, name string, image string, files []string, old *types.Container) error {
+	imageId, err := r.pullImage(ctx, image)
+
+	if err != nil {
+		return err
+	}
+
+	config, err := r.serviceConfig(compose, root, name, imageId, image)
+
+	if err != nil {
+		return err
+	}
+
+	hash := config.hash()
+
+	_, mounts := r.serviceFiles(compose.Services[name].Environment, files)
+
+	config.HostConfig.Binds = append(config.HostConfig.Binds, mounts...)
+
+	if old != nil {
+		if old.State == "running" && old.Labels[LabelConfigHash] == hash {
+			_, _ = fmt.Fprintf(r.writer, "Service '%s' is up to date\n", name)
+			return nil
+		}
+
+		_, _ = fmt.Fprintf(r.writer, "Recreating service '%s'\n", name)
+
+		if err := r.stop(ctx, old.ID); err != nil {
+			return err
+		}
+	} else {
+		_, _ = fmt.Fprintf(r.writer, "Creating service '%s'\n", name)
+	}
+
+	labels := r.labels()
+	delete(labels, LabelPorts)
+	labels[LabelService] = name
+	labels[LabelConfigHash] = hash
+	labels[labelComposeProject] = r.composeProject()
+	labels[labelComposeService] = name
+	config.Config.Labels = labels
+
+	// 第一个网络在创建容器时加入, 其他网络在启动之前加入
+	var names []string
+
+	for n := range config.Networks {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	config.HostConfig.NetworkMode = container.NetworkMode(names[0])
+
+	resp, err := r.client.ContainerCreate(ctx, config.Config, config.HostConfig, &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			names[0]: {Aliases: config.Networks[names[0]]},
+		},
+	}, r.serviceContainer(name))
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, n := range names[1:] {
+		if err := r.client.NetworkConnect(ctx, n, resp.ID, &network.EndpointSettings{
+			Aliases: config.Networks[n],
+		}); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if err := r.client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return errors.WithStack(err)
+	}
+
+	// 镜像有 HEALTHCHECK 时等待健康, 否则等待容器运行
+	s := *r
+	s.health = HealthCheck{}
+
+	if err := s.waitHealthy(ctx, resp.ID); err != nil {
+		// 没有重启策略并且正常退出的服务为一次性任务, 例如数据库迁移
+		if info, e := r.client.ContainerInspect(ctx, resp.ID); e == nil && !info.State.Running && info.State.ExitCode == 0 && config.HostConfig.RestartPolicy.Name == "" {
+			_, _ = fmt.Fprintf(r.writer, "Service '%s' completed\n", name)
+			return nil
+		}
+
+		if lines, e := r.tail(ctx, resp.ID, exitLogLines); e == nil && len(lines) > 0 {
+			_, _ = fmt.Fprintf(r.writer, "Last logs of service '%s':\n%s\n", name, strings.Join(lines, "\n"))
+		}
+
+		return err
+	}
+
+	_, _ = fmt.Fprintf(r.writer, "Service '%s' is running\n", name)
+
+	return nil
+}
+
+// 删除服务的旧镜像, 正在被容器使用的镜像会被保留
+func (r *Runtime) pruneServiceImages(ctx context.Context) error {
+	images, err := environmentImages(ctx, r.client, r.project, r.environment)
+
+	if err != nil {
+		return err
+	}
+
+	for _, img := range images {
+		if img.Labels[LabelService] == "" || img.Labels[LabelCommit] == r.hash {
+			continue
+		}
+
+		if _, err := r.client.ImageRemove(ctx, img.ID, types.ImageRemoveOptions{
+			PruneChildren: true,
+		}); err != nil && !client.IsErrImageNotFound(err) {
+			log.Printf("%+v\n", errors.WithStack(err))
+		}
+	}
+
+	return nil
+}
+
+// 删除 compose 创建的网络, 命名卷始终保留
+func (r *Runtime) removeComposeNetworks(ctx context.Context) {
+	args := filters.NewArgs()
+	args.Add("label", labelComposeProject+"="+r.composeProject())
+
+	list, err := r.client.NetworkList(ctx, types.NetworkListOptions{Filters: args})
+
+	if err != nil {
+		log.Printf("%+v\n", errors.WithStack(err))
+		return
+	}
+
+	for _, n := range list {
+		_, _ = fmt.Fprintf(r.writer, "Removing network '%s'\n", n.Name)
+
+		if err := r.client.NetworkRemove(ctx, n.ID); err != nil && !client.IsErrNetworkNotFound(err) {
+			log.Printf("%+v\n", errors.WithStack(err))
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	units "github.com/docker/go-units"
+	"github.com/pkg/errors"
+)
+
+// 容器退出之后的重启策略
+const (
+	RestartNo        = "no"         // 不重启
+	RestartOnFailure = "on-failure" // 退出码不为 0 时重启
+	RestartAlways    = "always"     // 总是重启
+)
+
+const (
+	DefaultMaxRetries = 5               // 默认的最大连续重启次数
+	DefaultBackoff    = time.Second     // 默认的第一次重启的等待时间
+	maxBackoff        = 5 * time.Minute // 重启的最长等待时间
+	stableAfter       = 5 * time.Minute // 容器运行超过该时间之后重新计算重启次数
+	exitLogLines      = 20              // 退出时记录的日志行数
+)
+
+// 正在被监控的容器, 等待重启的容器不需要重新部署
+var supervised sync.Map
+
+// 容器是否正在被监控, 包括等待重启的容器
+func Supervised(id string) bool {
+	_, ok := supervised.Load(id)
+	return ok
+}
+
+// 容器的资源限制, 为 0 则不限制
+type Resources struct {
+	CPUs   float64 // CPU 核数, 例如 0.5
+	Memory int64   // 内存, 单位为字节
+	Pids   int64   // 进程数
+}
+
+// 容器退出之后的重启策略
+type RestartPolicy struct {
+	Policy     string        // no/on-failure/always, 为空则为 on-failure
+	MaxRetries int           // 最大连续重启次数, 超过之后视为不健康
+	Backoff    time.Duration // 第一次重启的等待时间, 之后每次翻倍
+}
+
+// 容器启动之后自己退出了, 不是被 hooker 停止的
+type ExitError struct {
+	Id       string    // 容器 ID
+	Code     int64     // 退出码
+	Logs     []string  // 退出前最后的日志
+	Restarts int       // 已经连续重启的次数
+	Restart  bool      // 是否会被重启, 为 false 则不再重启
+	ExitedAt time.Time // 退出时间
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("container '%s' exited with code %d", e.Id, e.Code)
+}
+
+// 解析内存限制, 例如 512m, 1g
+func ParseMemory(memory string) (int64, error) {
+	if memory == "" {
+		return 0, nil
+	}
+
+	bytes, err := units.RAMInBytes(memory)
+
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return bytes, nil
+}
+
+// 容器的资源限制
+func (r *Runtime) containerResources() container.Resources {
+	return container.Resources{
+		NanoCPUs:  int64(r.resources.CPUs * 1e9),
+		Memory:    r.resources.Memory,
+		PidsLimit: r.resources.Pids,
+	}
+}
+
+// 退出码为 code 的容器是否需要重启
+func (r *Runtime) shouldRestart(code int64) bool {
+	switch r.restart.Policy {
+	case RestartNo:
+		return false
+	case RestartAlways:
+		return true
+	default:
+		return code != 0
+	}
+}
+
+// 重新监控程序重启之前启动的容器, 返回容器的崩溃事件
+func Supervise(id string, restart RestartPolicy) (<-chan error, error) {
+	if Supervised(id) {
+		return nil, errors.Errorf("container '%s' is already supervised", id)
+	}
+
+	r, err := NewRuntime(Options{Restart: restart}, ioutil.Discard)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan error)
+
+	go r.supervise(id, ch)
+
+	return ch, nil
+}
+
+// 监控运行的容器, 每次崩溃都会发送到 ch, 并且按照重启策略等待之后重启.
+// 容器被 hooker 停止或者不再重启时关闭 ch
+func (r *Runtime) supervise(id string, ch chan error) {
+	supervised.Store(id, true)
+
+	defer func() {
+		supervised.Delete(id)
+		close(ch)
+	}()
+
+	ctx := context.Background()
+
+	maxRetries := r.restart.MaxRetries
+
+	if maxRetries <= 0 {
+		maxRetries = DefaultMaxRetries
+	}
+
+	initial := r.restart.Backoff
+
+	if initial <= 0 {
+		initial = DefaultBackoff
+	}
+
+	backoff := initial
+	restarts := 0
+
+	for {
+		started := time.Now()
+
+		// wait until container exit
+		code, err := r.client.ContainerWait(ctx, id)
+
+		if _, ok := stopping.Load(id); ok {
+			stopping.Delete(id)
+			return
+		}
+
+		if ok, resumed := awaitPaused(id); ok {
+			if !resumed {
+				return
+			}
+
+			continue
+		}
+
+		if err != nil {
+			ch <- errors.WithStack(err)
+			return
+		}
+
+		// 稳定运行了一段时间, 重新计算重启次数
+		if time.Since(started) > stableAfter {
+			restarts = 0
+			backoff = initial
+		}
+
+		exit := &ExitError{
+			Id:       id,
+			Code:     code,
+			Restarts: restarts,
+			Restart:  r.shouldRestart(code) && restarts < maxRetries,
+			ExitedAt: time.Now(),
+		}
+
+		if lines, e := r.tail(ctx, id, exitLogLines); e == nil {
+			exit.Logs = lines
+		}
+
+		ch <- exit
+
+		if !exit.Restart {
+			return
+		}
+
+		log.Printf("Restarting container '%s' in %s\n", id, backoff)
+
+		time.Sleep(backoff)
+
+		// 等待期间被新的部署停止了
+		if _, ok := stopping.Load(id); ok {
+			stopping.Delete(id)
+			return
+		}
+
+		// 等待期间被新的部署暂时停止了, 重新启动时由部署启动容器
+		if ok, resumed := awaitPaused(id); ok {
+			if !resumed {
+				return
+			}
+
+			continue
+		}
+
+		if err := r.client.ContainerStart(ctx, id, types.ContainerStartOptions{}); err != nil {
+			if !client.IsErrContainerNotFound(err) {
+				ch <- errors.WithStack(err)
+			}
+
+			return
+		}
+
+		restarts++
+		backoff *= 2
+
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// 容器被新的部署暂时停止时等待部署的结果, 返回容器是否被暂时停止以及是否已经重新启动
+func awaitPaused(id string) (bool, bool) {
+	v, ok := paused.Load(id)
+
+	if !ok {
+		return false, false
+	}
+
+	if resumed := <-v.(chan bool); !resumed {
+		stopping.Delete(id)
+		return true, false
+	}
+
+	paused.Delete(id)
+
+	return true, true
+}
@@ -0,0 +1,179 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+)
+
+// hooker 管理的容器, 信息来自容器的标签
+type Workload struct {
+	Id          string    `json:"id"`          // 容器 ID
+	Name        string    `json:"name"`        // 容器名称
+	Project     string    `json:"project"`     // 项目 ID
+	Repo        string    `json:"repo"`        // 仓库名称
+	Environment string    `json:"environment"` // 环境名称, 为空则为默认环境
+	Service     string    `json:"service"`     // compose 部署的服务名, 单个容器部署时为空
+	Deployment  string    `json:"deployment"`  // 部署 ID
+	Commit      string    `json:"commit"`      // 部署的 commit hash
+	Image       string    `json:"image"`       // 镜像名
+	State       string    `json:"state"`       // 容器状态, 例如 running/exited
+	Status      string    `json:"status"`      // 容器状态的描述, 例如 Up 2 hours
+	Ports       []string  `json:"ports"`       // 端口映射, 格式为 8080:80
+	CreatedAt   time.Time `json:"created_at"`  // 容器的创建时间, 即最后一次部署的时间
+}
+
+// 列出 hooker 管理的容器, project 为空则列出所有项目
+func ListWorkloads(ctx context.Context, project string) ([]Workload, error) {
+	cli, err := client.NewEnvClient()
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer func() {
+		_ = cli.Close()
+	}()
+
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: projectFilter(project),
+	})
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	list := make([]Workload, 0, len(containers))
+
+	for _, c := range containers {
+		var name string
+
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+
+		ports := make([]string, 0, len(c.Ports))
+
+		for _, p := range c.Ports {
+			if p.PublicPort > 0 {
+				ports = append(ports, fmt.Sprintf("%d:%d", p.PublicPort, p.PrivatePort))
+			}
+		}
+
+		list = append(list, Workload{
+			Id:          c.ID,
+			Name:        name,
+			Project:     c.Labels[LabelProject],
+			Repo:        c.Labels[LabelRepo],
+			Environment: c.Labels[LabelEnvironment],
+			Service:     c.Labels[LabelService],
+			Deployment:  c.Labels[LabelDeployment],
+			Commit:      c.Labels[LabelCommit],
+			Image:       c.Image,
+			State:       c.State,
+			Status:      c.Status,
+			Ports:       ports,
+			CreatedAt:   time.Unix(c.Created, 0),
+		})
+	}
+
+	return list, nil
+}
+
+// 停止并且删除 hooker 管理的容器, 用于清理孤立的容器. 删除的是环境当前的容器时同时不再转发本机端口
+func RemoveWorkload(ctx context.Context, w Workload) error {
+	r, err := NewRuntime(Options{Project: w.Project, Environment: w.Environment}, ioutil.Discard)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = r.client.Close()
+	}()
+
+	if w.Name == ContainerName(w.Project, w.Environment) {
+		info, err := r.client.ContainerInspect(ctx, w.Id)
+
+		if err != nil && !client.IsErrContainerNotFound(err) {
+			return errors.WithStack(err)
+		}
+
+		if err == nil && info.Config != nil {
+			for _, p := range parsePorts(info.Config.Labels[LabelPorts]) {
+				unroute(p.MachinePort)
+			}
+		}
+	}
+
+	return r.stop(ctx, w.Id)
+}
+
+// 容器是否为正常退出的一次性任务, 即没有重启策略并且退出码为 0, 例如 compose 中的数据库迁移
+func Completed(ctx context.Context, id string) bool {
+	cli, err := client.NewEnvClient()
+
+	if err != nil {
+		return false
+	}
+
+	defer func() {
+		_ = cli.Close()
+	}()
+
+	info, err := cli.ContainerInspect(ctx, id)
+
+	if err != nil || info.State == nil || info.HostConfig == nil {
+		return false
+	}
+
+	policy := info.HostConfig.RestartPolicy.Name
+
+	return !info.State.Running && info.State.ExitCode == 0 && (policy == "" || policy == "no")
+}
+
+// 停止环境的容器并且删除环境的镜像, 用于清理合并请求的预览环境. 默认环境不允许清理
+func (r *Runtime) Teardown(ctx context.Context) error {
+	if r.environment == "" {
+		return errors.New("can not tear down the default environment")
+	}
+
+	if err := r.beforeRun(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := r.removeFiles(""); err != nil {
+		return err
+	}
+
+	r.removeNetworks(ctx)
+	r.removeComposeNetworks(ctx)
+
+	images, err := environmentImages(ctx, r.client, r.project, r.environment)
+
+	if err != nil {
+		return err
+	}
+
+	for _, img := range images {
+		log.Printf("Removing image '%s'\n", img.ID)
+		_, _ = fmt.Fprintf(r.writer, "Removing image '%s' %v\n", img.ID, img.RepoTags)
+
+		if _, err := r.client.ImageRemove(ctx, img.ID, types.ImageRemoveOptions{
+			Force:         true,
+			PruneChildren: true,
+		}); err != nil && !client.IsErrImageNotFound(err) {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package delivery
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/axetroy/hooker/internal/app/hook"
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/schema"
+	"github.com/axetroy/hooker/internal/app/store"
+	"github.com/kataras/iris/v12/context"
+	"github.com/pkg/errors"
+)
+
+// 请求列表中的数据, 不包含请求头和请求体
+type Item struct {
+	Id         string    `json:"id"`
+	Provider   string    `json:"provider"`
+	DeliveryId string    `json:"delivery_id"`
+	Event      string    `json:"event"`
+	Repo       string    `json:"repo"`
+	Ip         string    `json:"ip"`
+	Verified   bool      `json:"verified"`
+	Error      string    `json:"error"`
+	ProjectId  string    `json:"project_id"`
+	LogId      string    `json:"log_id"`
+	ReplayOf   string    `json:"replay_of"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// 重新执行的结果
+type ReplayResponse struct {
+	Delivery model.Delivery `json:"delivery"`
+	Log      *model.Log     `json:"log"`
+}
+
+// 隐藏请求中的密钥, 旧版本保存的请求中可能还有密钥
+func public(delivery model.Delivery) model.Delivery {
+	return hook.MaskDelivery(delivery)
+}
+
+func getDelivery(id string) (*model.Delivery, error) {
+	delivery, err := store.Default.GetDelivery(id)
+
+	if errors.Cause(err) == store.ErrNotFound {
+		return nil, schema.NewError(http.StatusNotFound, "delivery not found")
+	}
+
+	return delivery, err
+}
+
+// Web Hook 请求列表
+//
+// ?page=0&limit=10&provider=github&repo=github.com/owner/repo&project=xxx
+func ListRouter(ctx context.Context) {
+	var (
+		err  error
+		data interface{}
+		meta *schema.Meta
+	)
+
+	defer func() {
+		schema.JSON(ctx, data, meta, err)
+	}()
+
+	page, limit := schema.Pagination(ctx)
+
+	provider := ctx.URLParam("provider")
+	repo := ctx.URLParam("repo")
+	projectId := ctx.URLParam("project")
+
+	deliveries, err := store.Default.ListDeliveries()
+
+	if err != nil {
+		return
+	}
+
+	filtered := make([]model.Delivery, 0)
+
+	for _, d := range deliveries {
+		if provider != "" && d.Provider != provider {
+			continue
+		}
+
+		if repo != "" && !strings.EqualFold(d.Repo, repo) {
+			continue
+		}
+
+		if projectId != "" && d.ProjectId != projectId {
+			continue
+		}
+
+		filtered = append(filtered, d)
+	}
+
+	list := make([]Item, 0)
+
+	start, end := schema.PageRange(page, limit, len(filtered))
+
+	for i := start; i < end; i++ {
+		d := filtered[i]
+
+		list = append(list, Item{
+			Id:         d.Id,
+			Provider:   d.Provider,
+			DeliveryId: d.DeliveryId,
+			Event:      d.Event,
+			Repo:       d.Repo,
+			Ip:         d.Ip,
+			Verified:   d.Verified,
+			Error:      d.Error,
+			ProjectId:  d.ProjectId,
+			LogId:      d.LogId,
+			ReplayOf:   d.ReplayOf,
+			CreatedAt:  d.CreatedAt,
+		})
+	}
+
+	data = list
+	meta = &schema.Meta{
+		Page:  page,
+		Limit: limit,
+		Num:   len(list),
+		Total: len(filtered),
+	}
+}
+
+// Web Hook 请求详情, 包含请求头和请求体
+func GetRouter(ctx context.Context) {
+	var (
+		err  error
+		data interface{}
+	)
+
+	defer func() {
+		schema.JSON(ctx, data, nil, err)
+	}()
+
+	delivery, err := getDelivery(ctx.Params().Get("id"))
+
+	if err != nil {
+		return
+	}
+
+	data = public(*delivery)
+}
+
+// 重新执行 Web Hook 请求, 不检查请求是否重复
+func ReplayRouter(ctx context.Context) {
+	var (
+		err  error
+		data interface{}
+	)
+
+	defer func() {
+		schema.JSON(ctx, data, nil, err)
+	}()
+
+	origin, err := getDelivery(ctx.Params().Get("id"))
+
+	if err != nil {
+		return
+	}
+
+	delivery, record, err := hook.Replay(origin.Id)
+
+	if err != nil {
+		err = schema.NewError(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	data = ReplayResponse{
+		Delivery: public(*delivery),
+		Log:      record,
+	}
+}
@@ -0,0 +1,204 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/forge"
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/store"
+	"github.com/pkg/errors"
+)
+
+// 一次部署任务
+type Task struct {
+	ProjectId string            // 项目 ID, 部署记录保存在该项目下
+	Trigger   string            // 触发方式, 例如 github/gitlab/project
+	Options   container.Options // 部署的配置
+	Auth      *container.Auth   `json:"-"` // 克隆仓库的认证信息, 不保存到数据目录
+	Teardown  bool              // 清理环境的容器和镜像, 例如合并请求关闭之后清理预览环境
+	Before    string            // 推送之前的 commit hash, 用于判断推送的先后顺序, 不是推送触发的任务为空
+	Query     bool              // 通过 URL 参数部署的没有注册的仓库, 不记录期望运行的版本, 容器不会被自动对比处理
+	Report    *forge.Target     `json:"-"` // 把部署状态回报给代码托管平台, 为 nil 则不回报. 包含 access token, 不保存到数据目录
+
+	Rollback    bool          // 部署失败并且没有容器在运行, 或者容器在宽限期内崩溃时, 自动部署上一个版本
+	GracePeriod time.Duration // 容器启动之后的宽限期
+
+	Variables []model.Variable // 项目的环境变量, 密钥在执行时才解密
+}
+
+// 把部署状态回报给代码托管平台, 清理环境的任务不回报
+func report(task Task, record *model.Log) {
+	if task.Report != nil && !task.Teardown {
+		forge.Report(*task.Report, *record)
+	}
+}
+
+// 执行部署, 并且更新部署记录. ctx 被取消说明有新的任务取代了该任务
+func execute(ctx context.Context, task Task, record *model.Log) {
+	record.Status = model.StatusRunning
+	record.StartedAt = time.Now()
+
+	if err := store.Default.UpdateLog(record); err != nil {
+		log.Printf("%+v\n", err)
+	}
+
+	stream := GetStream(record.Id)
+
+	// 程序重启之前入队的任务没有事件流
+	if stream == nil {
+		stream = newStream(record.Id)
+	}
+
+	defer func() {
+		stream.close()
+
+		// 部署结束后保留一段时间, 之后从部署记录中回放
+		time.AfterFunc(streamKeepAlive, func() {
+			removeStream(record.Id)
+		})
+	}()
+
+	stream.publish(Event{Type: EventStatus, Status: record.Status})
+
+	report(task, record)
+
+	output := newOutput()
+
+	exits, err := run(ctx, task, io.MultiWriter(os.Stdout, output, streamWriter{stream}), record)
+
+	record.Output = output.String()
+	record.FinishedAt = time.Now()
+
+	if errors.Cause(err) == container.ErrNoMatchedChanges {
+		record.Status = model.StatusSkipped
+		record.Error = err.Error()
+	} else if err != nil && ctx.Err() == context.Canceled {
+		record.Status = model.StatusCanceled
+		record.Error = "superseded by a newer deployment"
+	} else if err != nil {
+		record.Status = model.StatusFailure
+		record.Error = fmt.Sprintf("%v", err)
+	} else {
+		record.Status = model.StatusSuccess
+	}
+
+	if e := store.Default.UpdateLog(record); e != nil {
+		log.Printf("%+v\n", e)
+	}
+
+	if record.Status == model.StatusSuccess {
+		if e := desire(task, *record); e != nil {
+			log.Printf("%+v\n", e)
+		}
+	}
+
+	stream.publish(Event{Type: EventStatus, Status: record.Status})
+	stream.publish(Event{Type: EventResult, Status: record.Status, Error: record.Error})
+
+	report(task, record)
+
+	if e := Prune(task.ProjectId); e != nil {
+		log.Printf("%+v\n", e)
+	}
+
+	if record.Status == model.StatusFailure && task.Rollback && !task.Teardown {
+		go rollbackIfDown(task, *record)
+	}
+
+	// 部署记录保存之后才记录容器的崩溃, 避免被覆盖
+	if exits != nil {
+		go watch(task, *record, exits)
+	}
+}
+
+// 记录环境期望运行的版本, 清理之后的环境不再需要运行
+func desire(task Task, record model.Log) error {
+	if task.Query {
+		return nil
+	}
+
+	if task.Teardown {
+		if err := store.Default.DeleteDesired(record.ProjectId, record.Environment); err != nil && errors.Cause(err) != store.ErrNotFound {
+			return err
+		}
+
+		return nil
+	}
+
+	return store.Default.PutDesired(&model.Desired{
+		ProjectId:   record.ProjectId,
+		Environment: record.Environment,
+		Deployment:  record.Id,
+		Ref:         record.Ref,
+		Commit:      record.Commit,
+	})
+}
+
+// 克隆仓库对应的提交, 构建镜像并且运行容器, 返回容器的崩溃事件
+func run(ctx context.Context, task Task, writer io.Writer, record *model.Log) (exits <-chan error, err error) {
+	asyncErr := make(chan error)
+
+	c, cancel := context.WithTimeout(ctx, time.Minute*30)
+
+	defer cancel()
+
+	// 容器和镜像的标签中记录部署 ID
+	task.Options.Deployment = record.Id
+
+	variables, secrets, err := resolveVariables(task.Variables, task.Options.Environment)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range variables {
+		task.Options.Env = append(task.Options.Env, v.Name+"="+v.Value)
+
+		if v.File != "" {
+			task.Options.Files = append(task.Options.Files, container.File{Name: v.Name, Path: v.File, Content: v.Value})
+		}
+	}
+
+	masked := newMaskWriter(writer, secrets)
+
+	defer func() {
+		_ = masked.Flush()
+
+		// 错误信息会保存到部署记录并且回报给代码托管平台
+		err = maskError(err, secrets)
+	}()
+
+	writer = masked
+
+	runtime, err := container.NewRuntime(task.Options, writer)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if task.Teardown {
+		return nil, runtime.Teardown(c)
+	}
+
+	err = runtime.Run(c, task.Auth, asyncErr)
+
+	record.Commit = runtime.Hash()
+	record.ContainerId = runtime.ContainerID()
+
+	// 旧的容器已经停止之后失败, 新的容器会继续运行, 仍然需要记录它的崩溃
+	if err != nil && record.ContainerId != "" {
+		return asyncErr, err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return asyncErr, nil
+}
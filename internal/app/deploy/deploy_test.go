@@ -0,0 +1,73 @@
+package deploy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/store"
+)
+
+func TestDesire(t *testing.T) {
+	db, err := store.NewFileStore(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(s store.Store) {
+		store.Default = s
+	}(store.Default)
+
+	store.Default = db
+
+	tests := []struct {
+		name   string
+		task   Task
+		record model.Log
+		want   []string // 之后记录了期望版本的项目
+	}{
+		{
+			name:   "registered project",
+			task:   Task{ProjectId: "blog"},
+			record: model.Log{Id: "1", ProjectId: "blog", Commit: "abc"},
+			want:   []string{"blog"},
+		},
+		{
+			name:   "deployed with query",
+			task:   Task{ProjectId: "github.com_axetroy_blog", Query: true},
+			record: model.Log{Id: "2", ProjectId: "github.com_axetroy_blog", Commit: "abc"},
+			want:   []string{"blog"},
+		},
+		{
+			name:   "teardown",
+			task:   Task{ProjectId: "blog", Teardown: true},
+			record: model.Log{Id: "3", ProjectId: "blog"},
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := desire(tt.task, tt.record); err != nil {
+				t.Fatal(err)
+			}
+
+			list, err := db.ListDesired()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+
+			for _, d := range list {
+				got = append(got, d.ProjectId)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("desired projects = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package deploy
+
+import (
+	"bytes"
+	"sync"
+)
+
+// 构建输出的最大长度, 超出时丢弃最早的输出
+const maxOutputSize = 2 << 20
+
+// 保存部署过程中的输出
+type output struct {
+	sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func newOutput() *output {
+	return &output{}
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.buf.Write(p)
+
+	if o.buf.Len() > maxOutputSize {
+		b := o.buf.Bytes()
+		b = b[len(b)-maxOutputSize:]
+
+		o.buf.Reset()
+		o.buf.Write(b)
+		o.truncated = true
+	}
+
+	return len(p), nil
+}
+
+func (o *output) String() string {
+	o.Lock()
+	defer o.Unlock()
+
+	if o.truncated {
+		return truncatedMarker + o.buf.String()
+	}
+
+	return o.buf.String()
+}
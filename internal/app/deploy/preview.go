@@ -0,0 +1,89 @@
+package deploy
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/store"
+	"github.com/pkg/errors"
+)
+
+// 检查预览环境是否过期的间隔
+var previewInterval = time.Minute * 10
+
+// 清理超过有效期没有更新的预览环境, 容器的创建时间即预览环境最后一次部署的时间
+func expirePreviews() error {
+	workloads, err := container.ListWorkloads(context.Background(), "")
+
+	if err != nil {
+		return err
+	}
+
+	expired := map[string]bool{}
+
+	for _, env := range workloads {
+		key := env.Project + "/" + env.Environment
+
+		if env.Environment == "" || env.Project == "" || expired[key] {
+			continue
+		}
+
+		project, err := store.Default.GetProject(env.Project)
+
+		if err != nil {
+			if errors.Cause(err) == store.ErrNotFound {
+				continue
+			}
+
+			return err
+		}
+
+		if project.Preview.TTL == "" {
+			continue
+		}
+
+		ttl, err := time.ParseDuration(project.Preview.TTL)
+
+		if err != nil || time.Since(env.CreatedAt) < ttl {
+			continue
+		}
+
+		expired[key] = true
+
+		log.Printf("Preview environment '%s' of project '%s' expired\n", env.Environment, env.Project)
+
+		// 清理环境独有的网络
+		networks := make([]container.Network, 0, len(project.Networks))
+
+		for _, n := range project.Networks {
+			networks = append(networks, container.Network{Name: n.Name, Aliases: n.Aliases})
+		}
+
+		if _, err := Enqueue(Task{
+			ProjectId: env.Project,
+			Trigger:   "ttl",
+			Options: container.Options{
+				Project:     env.Project,
+				Environment: env.Environment,
+				Repo:        env.Repo,
+				Networks:    networks,
+			},
+			Teardown: true,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 定时清理过期的预览环境
+func watchPreviews() {
+	for range time.Tick(previewInterval) {
+		if err := expirePreviews(); err != nil {
+			log.Printf("%+v\n", err)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/store"
+	"github.com/pkg/errors"
+)
+
+var (
+	// 同时执行的部署任务数量
+	Concurrency = 2
+
+	// 入队后等待的时间, 期间同一个项目的新任务会取代旧任务, 用于合并短时间内的多次推送
+	Debounce = time.Second * 5
+
+	// 程序重启之后恢复任务的认证信息, 认证信息不保存到数据目录, 需要根据项目的配置重新生成
+	Credentials func(task *Task) error
+)
+
+// 等待执行的任务
+type pending struct {
+	job     model.Job
+	task    Task      // 任务的配置, 包含不保存到数据目录的认证信息
+	readyAt time.Time // 在该时间之后才会执行
+}
+
+// 正在执行的任务
+type active struct {
+	id         string
+	task       Task
+	cancel     context.CancelFunc
+	superseded bool // 已经被新的任务取代
+}
+
+// 等待执行的部署任务, 任务同时保存在数据目录中, 程序重启之后会重新入队.
+// 同一个项目的同一个环境同时只会执行一个任务, 新的任务会取代还没有执行的任务, 并且取消正在执行的任务
+type queue struct {
+	sync.Mutex
+	cond    *sync.Cond
+	jobs    []pending
+	running map[string]*active // 项目 ID 和环境 -> 正在执行的任务
+}
+
+// 同一个项目的同一个环境同时只会执行一个任务
+func jobKey(job model.Job) string {
+	return job.ProjectId + "/" + job.Environment
+}
+
+func newQueue() *queue {
+	q := &queue{running: map[string]*active{}}
+	q.cond = sync.NewCond(&q.Mutex)
+	return q
+}
+
+// 任务的推送是否在另一个任务的推送之后, 即推送之前的提交是另一个任务部署的提交. 手动部署等不是推送触发的任务不比较先后.
+// 需要通过 git diff 判断改动的文件的任务可能不会部署, 不能取代其他任务
+func follows(task Task, other Task) bool {
+	if task.Before == "" || other.Before == "" || len(task.Options.Paths) > 0 {
+		return false
+	}
+
+	return task.Before == other.Options.Hash && task.Options.Ref == other.Options.Ref
+}
+
+// 加入任务, 返回被取代的等待中的任务.
+// 需要在克隆之后通过 git diff 判断改动的文件的任务可能不会部署, 不取代其他任务, 只在它们之后执行.
+// Web Hook 到达的顺序可能与推送的顺序不同, 等待中或者正在执行的任务的推送在新任务的推送之后时, 新任务不会加入队列, 返回取代它的任务的 ID
+func (q *queue) push(job model.Job, task Task, delay time.Duration) (superseded []pending, by string) {
+	q.Lock()
+
+	for _, p := range q.jobs {
+		if jobKey(p.job) == jobKey(job) && follows(p.task, task) {
+			q.Unlock()
+			return nil, p.job.Id
+		}
+	}
+
+	if a, ok := q.running[jobKey(job)]; ok && !a.superseded && follows(a.task, task) {
+		q.Unlock()
+		return nil, a.id
+	}
+
+	replace := len(task.Options.Paths) == 0
+	jobs := q.jobs[:0]
+
+	for _, p := range q.jobs {
+		if replace && jobKey(p.job) == jobKey(job) {
+			superseded = append(superseded, p)
+		} else {
+			jobs = append(jobs, p)
+		}
+	}
+
+	q.jobs = append(jobs, pending{job: job, task: task, readyAt: time.Now().Add(delay)})
+
+	if a, ok := q.running[jobKey(job)]; ok && replace && !a.superseded {
+		a.superseded = true
+		a.cancel()
+	}
+
+	q.Unlock()
+
+	if delay > 0 {
+		time.AfterFunc(delay, q.cond.Broadcast)
+	}
+
+	q.cond.Broadcast()
+
+	return superseded, ""
+}
+
+// 取出最早可以执行的任务, 没有任务时阻塞. 项目有正在执行的任务或者还在等待合并推送时跳过
+func (q *queue) pop() (pending, context.Context) {
+	q.Lock()
+	defer q.Unlock()
+
+	for {
+		now := time.Now()
+
+		for i, p := range q.jobs {
+			if _, busy := q.running[jobKey(p.job)]; busy || p.readyAt.After(now) {
+				continue
+			}
+
+			q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+
+			ctx, cancel := context.WithCancel(context.Background())
+
+			q.running[jobKey(p.job)] = &active{id: p.job.Id, task: p.task, cancel: cancel}
+
+			return p, ctx
+		}
+
+		q.cond.Wait()
+	}
+}
+
+// 任务执行结束, 项目的下一个任务可以开始执行
+func (q *queue) done(job model.Job) {
+	q.Lock()
+
+	if a, ok := q.running[jobKey(job)]; ok && a.id == job.Id {
+		a.cancel()
+		delete(q.running, jobKey(job))
+	}
+
+	q.Unlock()
+
+	q.cond.Broadcast()
+}
+
+// 项目的环境是否有等待中或者正在执行的任务
+func (q *queue) busy(key string) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	if _, ok := q.running[key]; ok {
+		return true
+	}
+
+	for _, p := range q.jobs {
+		if jobKey(p.job) == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+var jobs = newQueue()
+
+// 项目的环境是否有等待中或者正在部署的任务
+func Busy(projectId string, environment string) bool {
+	return jobs.busy(jobKey(model.Job{ProjectId: projectId, Environment: environment}))
+}
+
+// 标记被取代的任务, 不再执行
+func supersede(p pending, by string) {
+	job := p.job
+
+	if err := store.Default.DeleteJob(job.Id); err != nil {
+		log.Printf("%+v\n", err)
+	}
+
+	record, err := store.Default.GetLog(job.ProjectId, job.Id)
+
+	if err != nil {
+		log.Printf("%+v\n", err)
+		return
+	}
+
+	record.Status = model.StatusCanceled
+	record.Error = fmt.Sprintf("superseded by deployment '%s'", by)
+	record.FinishedAt = time.Now()
+
+	if err := store.Default.UpdateLog(record); err != nil {
+		log.Printf("%+v\n", err)
+	}
+
+	report(p.task, record)
+
+	if stream := GetStream(record.Id); stream != nil {
+		stream.publish(Event{Type: EventStatus, Status: record.Status})
+		stream.publish(Event{Type: EventResult, Status: record.Status, Error: record.Error})
+		stream.close()
+
+		time.AfterFunc(streamKeepAlive, func() {
+			removeStream(record.Id)
+		})
+	}
+}
+
+// 把部署任务加入队列, 返回等待部署的部署记录
+func Enqueue(task Task) (*model.Log, error) {
+	b, err := json.Marshal(task)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	record := model.Log{
+		ProjectId:   task.ProjectId,
+		Trigger:     task.Trigger,
+		Environment: task.Options.Environment,
+		Ref:         task.Options.Ref,
+		Commit:      task.Options.Hash,
+		Status:      model.StatusPending,
+	}
+
+	if err := store.Default.CreateLog(&record); err != nil {
+		return nil, err
+	}
+
+	job := model.Job{
+		Id:          record.Id,
+		ProjectId:   record.ProjectId,
+		Environment: record.Environment,
+		Task:        b,
+	}
+
+	if err := store.Default.CreateJob(&job); err != nil {
+		return nil, err
+	}
+
+	newStream(record.Id).publish(Event{Type: EventStatus, Status: record.Status})
+
+	report(task, &record)
+
+	superseded, by := jobs.push(job, task, Debounce)
+
+	for _, old := range superseded {
+		supersede(old, job.Id)
+	}
+
+	if by != "" {
+		supersede(pending{job: job, task: task}, by)
+	}
+
+	return &record, nil
+}
+
+// 记录没有部署的事件, 例如推送的分支不符合项目的规则
+func Skip(task Task, reason string) (*model.Log, error) {
+	now := time.Now()
+
+	record := model.Log{
+		ProjectId:   task.ProjectId,
+		Trigger:     task.Trigger,
+		Environment: task.Options.Environment,
+		Ref:         task.Options.Ref,
+		Commit:      task.Options.Hash,
+		Status:      model.StatusSkipped,
+		Error:       reason,
+		FinishedAt:  now,
+	}
+
+	if err := store.Default.CreateLog(&record); err != nil {
+		return nil, err
+	}
+
+	if err := Prune(task.ProjectId); err != nil {
+		log.Printf("%+v\n", err)
+	}
+
+	return &record, nil
+}
+
+// 重新加入程序退出之前没有执行完的任务, 并且启动 worker
+func Start() error {
+	// 本机端口由 hooker 转发到容器, 重启之后需要恢复, Docker 不可用时不影响启动
+	if err := container.RestoreRoutes(context.Background()); err != nil {
+		log.Printf("%+v\n", err)
+	}
+
+	list, err := store.Default.ListJobs()
+
+	if err != nil {
+		return err
+	}
+
+	for _, job := range list {
+		record, err := store.Default.GetLog(job.ProjectId, job.Id)
+
+		if err != nil {
+			if errors.Cause(err) != store.ErrNotFound {
+				return err
+			}
+
+			// 部署记录已经被删除, 不再执行
+			if err := store.Default.DeleteJob(job.Id); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		task, err := resume(job)
+
+		if err != nil {
+			record.Status = model.StatusFailure
+			record.Error = err.Error()
+			record.FinishedAt = time.Now()
+
+			if err := store.Default.UpdateLog(record); err != nil {
+				return err
+			}
+
+			if err := store.Default.DeleteJob(job.Id); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if record.Status != model.StatusPending {
+			record.Status = model.StatusPending
+
+			if err := store.Default.UpdateLog(record); err != nil {
+				return err
+			}
+		}
+
+		// 任务按照创建时间排序, 同一个项目只保留最新的任务
+		superseded, by := jobs.push(job, *task, 0)
+
+		for _, old := range superseded {
+			supersede(old, job.Id)
+		}
+
+		if by != "" {
+			supersede(pending{job: job, task: *task}, by)
+		}
+	}
+
+	concurrency := Concurrency
+
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	for i := 0; i < concurrency; i++ {
+		go worker()
+	}
+
+	go watchPreviews()
+	go watchRetention()
+
+	return nil
+}
+
+// 从数据目录中恢复任务的配置, 并且重新生成认证信息
+func resume(job model.Job) (*Task, error) {
+	var task Task
+
+	if err := json.Unmarshal(job.Task, &task); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if Credentials != nil {
+		if err := Credentials(&task); err != nil {
+			return nil, err
+		}
+	}
+
+	return &task, nil
+}
+
+func worker() {
+	for {
+		p, ctx := jobs.pop()
+
+		process(ctx, p.job, p.task)
+	}
+}
+
+func process(ctx context.Context, job model.Job, task Task) {
+	defer func() {
+		if err := store.Default.DeleteJob(job.Id); err != nil {
+			log.Printf("%+v\n", err)
+		}
+
+		jobs.done(job)
+	}()
+
+	record, err := store.Default.GetLog(job.ProjectId, job.Id)
+
+	if err != nil {
+		log.Printf("%+v\n", err)
+		return
+	}
+
+	execute(ctx, task, record)
+}
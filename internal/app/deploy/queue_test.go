@@ -0,0 +1,216 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/forge"
+	"github.com/axetroy/hooker/internal/app/model"
+)
+
+func TestResumeCredentials(t *testing.T) {
+	task := Task{
+		ProjectId: "blog",
+		Options:   container.Options{Project: "blog", Repo: "github.com/axetroy/blog"},
+		Auth:      &container.Auth{Username: "axetroy", Password: "clone-password"},
+		Report:    &forge.Target{Provider: "github", Repo: "github.com/axetroy/blog", Token: "forge-token"},
+	}
+
+	b, err := json.Marshal(task)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"clone-password", "forge-token"} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("persisted task contains %s: %s", secret, b)
+		}
+	}
+
+	defer func(fn func(task *Task) error) {
+		Credentials = fn
+	}(Credentials)
+
+	Credentials = func(task *Task) error {
+		task.Auth = &container.Auth{Username: "axetroy", Password: "new-password"}
+		return nil
+	}
+
+	resumed, err := resume(model.Job{Id: "1", ProjectId: "blog", Task: b})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resumed.Auth == nil || resumed.Auth.Password != "new-password" || resumed.Options.Repo != task.Options.Repo {
+		t.Errorf("resume() = %+v, want credentials from the project", resumed)
+	}
+
+	if _, err := resume(model.Job{Id: "2", ProjectId: "blog", Task: []byte("{")}); err == nil {
+		t.Error("resume() with invalid task error = nil")
+	}
+}
+
+func TestQueueSupersede(t *testing.T) {
+	job := func(id string) model.Job {
+		return model.Job{Id: id, ProjectId: "blog"}
+	}
+
+	deferred := Task{Options: container.Options{Paths: []string{"src/**"}}}
+
+	tests := []struct {
+		name           string
+		task           Task
+		wantSuperseded []string // 被取代的等待中的任务
+		wantCanceled   bool     // 正在执行的任务是否被取消
+		wantPending    []string // 之后等待执行的任务
+	}{
+		{
+			name:           "newer push",
+			task:           Task{},
+			wantSuperseded: []string{"2"},
+			wantCanceled:   true,
+			wantPending:    []string{"3"},
+		},
+		{
+			name:        "push filtered by paths after clone",
+			task:        deferred,
+			wantPending: []string{"2", "3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newQueue()
+
+			q.push(job("1"), Task{}, 0)
+
+			running, ctx := q.pop()
+
+			if running.job.Id != "1" {
+				t.Fatalf("pop() = %s, want 1", running.job.Id)
+			}
+
+			// 需要通过 git diff 判断的任务不取代正在执行的任务
+			q.push(job("2"), deferred, time.Hour)
+
+			if ctx.Err() != nil {
+				t.Fatal("running job canceled by a push filtered by paths after clone")
+			}
+
+			var superseded []string
+
+			list, _ := q.push(job("3"), tt.task, time.Hour)
+
+			for _, p := range list {
+				superseded = append(superseded, p.job.Id)
+			}
+
+			if !reflect.DeepEqual(superseded, tt.wantSuperseded) {
+				t.Errorf("push() superseded = %v, want %v", superseded, tt.wantSuperseded)
+			}
+
+			if canceled := ctx.Err() != nil; canceled != tt.wantCanceled {
+				t.Errorf("running job canceled = %v, want %v", canceled, tt.wantCanceled)
+			}
+
+			var ids []string
+
+			for _, p := range q.jobs {
+				ids = append(ids, p.job.Id)
+			}
+
+			if !reflect.DeepEqual(ids, tt.wantPending) {
+				t.Errorf("pending = %v, want %v", ids, tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestQueueOutOfOrderPushes(t *testing.T) {
+	push := func(before string, hash string) Task {
+		return Task{Before: before, Options: container.Options{Ref: "refs/heads/master", Hash: hash}}
+	}
+
+	tests := []struct {
+		name    string
+		running Task   // 正在执行的任务
+		pending Task   // 等待中的任务
+		task    Task   // 新的任务
+		wantBy  string // 取代新任务的任务, 为空则新任务加入队列
+	}{
+		{name: "in order", running: push("a", "b"), pending: push("b", "c"), task: push("c", "d")},
+		{name: "older than pending push", running: push("a", "b"), pending: push("c", "d"), task: push("b", "c"), wantBy: "2"},
+		{name: "older than running push", running: push("b", "c"), task: push("a", "b"), wantBy: "1"},
+		{name: "force push to an older commit", running: push("a", "b"), pending: push("b", "a"), task: push("a", "c")},
+		{name: "unrelated pushes", running: push("a", "b"), pending: push("x", "y"), task: push("c", "d")},
+		{name: "other branch", pending: Task{Before: "b", Options: container.Options{Ref: "refs/heads/dev", Hash: "c"}}, task: push("a", "b")},
+		{name: "manual deployment", pending: push("b", "c"), task: Task{Options: container.Options{Ref: "refs/heads/master", Hash: "b"}}},
+		{name: "pending push filtered by paths after clone", pending: Task{Before: "b", Options: container.Options{Ref: "refs/heads/master", Hash: "c", Paths: []string{"src/**"}}}, task: push("a", "b")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newQueue()
+
+			var ctx context.Context
+
+			if tt.running.Options.Hash != "" {
+				q.push(model.Job{Id: "1", ProjectId: "blog"}, tt.running, 0)
+				_, ctx = q.pop()
+			}
+
+			if tt.pending.Options.Hash != "" {
+				q.push(model.Job{Id: "2", ProjectId: "blog"}, tt.pending, time.Hour)
+			}
+
+			pending := len(q.jobs)
+			canceled := ctx != nil && ctx.Err() != nil
+
+			superseded, by := q.push(model.Job{Id: "3", ProjectId: "blog"}, tt.task, time.Hour)
+
+			if by != tt.wantBy {
+				t.Fatalf("push() by = %q, want %q", by, tt.wantBy)
+			}
+
+			if by == "" {
+				return
+			}
+
+			// 过时的推送不会取代或者取消其他任务
+			if len(superseded) != 0 || len(q.jobs) != pending || (ctx != nil && ctx.Err() != nil) != canceled {
+				t.Errorf("stale push changed the queue: superseded = %v, pending = %d", superseded, len(q.jobs))
+			}
+		})
+	}
+}
+
+func TestQueueOneJobPerEnvironment(t *testing.T) {
+	q := newQueue()
+
+	q.push(model.Job{Id: "1", ProjectId: "blog"}, Task{}, 0)
+	q.push(model.Job{Id: "2", ProjectId: "blog", Environment: "pr-1"}, Task{}, 0)
+
+	first, _ := q.pop()
+	second, _ := q.pop()
+
+	if first.job.Id != "1" || second.job.Id != "2" {
+		t.Fatalf("pop() = %s, %s, want 1, 2", first.job.Id, second.job.Id)
+	}
+
+	if !q.busy("blog/") || !q.busy("blog/pr-1") || q.busy("other/") {
+		t.Error("busy() does not match running jobs")
+	}
+
+	q.done(first.job)
+
+	if q.busy("blog/") {
+		t.Error("busy() = true after done()")
+	}
+}
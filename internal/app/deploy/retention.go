@@ -0,0 +1,101 @@
+package deploy
+
+import (
+	"log"
+	"time"
+
+	"github.com/axetroy/hooker/internal/app/store"
+)
+
+// 部署记录的保留策略
+type RetentionPolicy struct {
+	MaxCount      int           // 每个项目最多保留的记录数, 0 为不限制
+	MaxAge        time.Duration // 记录最长保留的时间, 0 为不限制
+	MaxDeliveries int           // 最多保留的 Web Hook 请求数, 0 为不限制, 保留时间同 MaxAge
+}
+
+var Retention = RetentionPolicy{
+	MaxCount:      100,
+	MaxAge:        time.Hour * 24 * 30,
+	MaxDeliveries: 1000,
+}
+
+// 按照保留策略删除项目的旧部署记录, 正在部署中的记录不会被删除
+func Prune(projectId string) error {
+	logs, err := store.Default.ListLogs(projectId)
+
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	// 记录按照时间倒序排列
+	for i, l := range logs {
+		if l.FinishedAt.IsZero() {
+			continue
+		}
+
+		expired := Retention.MaxAge > 0 && now.Sub(l.CreatedAt) > Retention.MaxAge
+		overflow := Retention.MaxCount > 0 && i >= Retention.MaxCount
+
+		if expired || overflow {
+			if err := store.Default.DeleteLog(projectId, l.Id); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// 按照保留策略删除旧的 Web Hook 请求
+func PruneDeliveries() error {
+	deliveries, err := store.Default.ListDeliveries()
+
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	// 请求按照时间倒序排列
+	for i, d := range deliveries {
+		expired := Retention.MaxAge > 0 && now.Sub(d.CreatedAt) > Retention.MaxAge
+		overflow := Retention.MaxDeliveries > 0 && i >= Retention.MaxDeliveries
+
+		if expired || overflow {
+			if err := store.Default.DeleteDelivery(d.Id); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// 删除所有项目的旧部署记录和旧的 Web Hook 请求
+func PruneAll() error {
+	projects, err := store.Default.ListProjects()
+
+	if err != nil {
+		return err
+	}
+
+	for _, p := range projects {
+		if err := Prune(p.Id); err != nil {
+			return err
+		}
+	}
+
+	return PruneDeliveries()
+}
+
+// 定时执行保留策略, 没有新部署的项目的记录也会过期
+func watchRetention() {
+	for range time.Tick(time.Hour) {
+		if err := PruneAll(); err != nil {
+			log.Printf("%+v\n", err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/store"
+)
+
+// 使用保留的镜像回滚到上一个版本, 并且在原来的部署记录中说明
+func rollback(task Task, logId string, commit string, reason string) {
+	releases, err := container.ListReleases(context.Background(), task.Options.Project, task.Options.Environment)
+
+	if err != nil {
+		log.Printf("%+v\n", err)
+		return
+	}
+
+	var previous *container.Release
+
+	for _, r := range releases {
+		if r.Commit != commit {
+			release := r
+			previous = &release
+			break
+		}
+	}
+
+	if previous == nil {
+		log.Printf("No release of project '%s' to roll back to\n", task.ProjectId)
+		return
+	}
+
+	t := task
+	t.Trigger = "rollback"
+	t.Options.Image = previous.Image
+	t.Options.Hash = previous.Commit
+	t.Options.Ref = ""
+	t.Options.Paths = nil
+	t.Options.Before = ""
+	// 回滚的版本失败时不再回滚, 避免循环
+	t.Rollback = false
+
+	record, err := Enqueue(t)
+
+	if err != nil {
+		log.Printf("%+v\n", err)
+		return
+	}
+
+	log.Printf("Roll back project '%s' to '%s' by deployment '%s'\n", task.ProjectId, previous.Commit, record.Id)
+
+	l, err := store.Default.GetLog(task.ProjectId, logId)
+
+	if err != nil {
+		log.Printf("%+v\n", err)
+		return
+	}
+
+	l.Error = fmt.Sprintf("%s, rolled back to '%s' by deployment '%s'", reason, previous.Commit, record.Id)
+
+	if err := store.Default.UpdateLog(l); err != nil {
+		log.Printf("%+v\n", err)
+	}
+}
+
+// 部署失败并且环境中没有正在运行的容器时回滚, 旧的容器还在运行则不需要回滚
+func rollbackIfDown(task Task, record model.Log) {
+	workloads, err := container.ListWorkloads(context.Background(), task.Options.Project)
+
+	if err != nil {
+		log.Printf("%+v\n", err)
+		return
+	}
+
+	name := container.ContainerName(task.Options.Project, task.Options.Environment)
+
+	for _, w := range workloads {
+		if w.Name == name && w.State == "running" {
+			return
+		}
+	}
+
+	rollback(task, record.Id, record.Commit, record.Error)
+}
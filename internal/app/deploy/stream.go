@@ -0,0 +1,169 @@
+package deploy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/axetroy/hooker/internal/app/model"
+)
+
+const (
+	EventOutput = "output" // 构建输出
+	EventStatus = "status" // 状态变化
+	EventResult = "result" // 部署结果, 之后不会再有任何事件
+)
+
+// 部署过程中的事件
+type Event struct {
+	Type   string       `json:"type"`
+	Output string       `json:"output,omitempty"`
+	Status model.Status `json:"status,omitempty"`
+	Error  string       `json:"error,omitempty"`
+	Time   time.Time    `json:"time"`
+}
+
+// 部署结束后保留事件流的时间, 之后从部署记录中回放
+const streamKeepAlive = time.Minute
+
+// 截断之后的输出开头的标记
+const truncatedMarker = "... (truncated)\n"
+
+// 部署的事件流, 新的订阅者会先收到之前的事件. 保留的输出超过 maxOutputSize 时丢弃最早的事件
+type Stream struct {
+	sync.Mutex
+	events      []Event
+	size        int // 保留的事件中输出的长度
+	dropped     int // 丢弃的事件数量, 即 events[0] 的序号
+	subscribers map[chan Event]struct{}
+	closed      bool
+}
+
+var (
+	streams     = map[string]*Stream{}
+	streamsLock sync.RWMutex
+)
+
+// 获取正在部署中的事件流, 不存在则返回 nil
+func GetStream(logId string) *Stream {
+	streamsLock.RLock()
+	defer streamsLock.RUnlock()
+
+	return streams[logId]
+}
+
+func newStream(logId string) *Stream {
+	s := &Stream{subscribers: map[chan Event]struct{}{}}
+
+	streamsLock.Lock()
+	streams[logId] = s
+	streamsLock.Unlock()
+
+	return s
+}
+
+func removeStream(logId string) {
+	streamsLock.Lock()
+	delete(streams, logId)
+	streamsLock.Unlock()
+}
+
+func (s *Stream) publish(e Event) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	e.Time = time.Now()
+
+	s.events = append(s.events, e)
+	s.size += len(e.Output)
+
+	// 至少保留最新的事件
+	n := 0
+
+	for s.size > maxOutputSize && n < len(s.events)-1 {
+		s.size -= len(s.events[n].Output)
+		n++
+	}
+
+	if n > 0 {
+		s.events = append([]Event{}, s.events[n:]...)
+		s.dropped += n
+	}
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- e:
+		default:
+			// 订阅者处理不过来, 断开订阅者, 避免阻塞部署
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// 结束事件流, 所有订阅者的 channel 会被关闭
+func (s *Stream) close() {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+
+	for ch := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// 订阅事件流, 返回保留的之前的事件, 第一个事件的序号和后续事件的 channel, 事件流结束时 channel 会被关闭.
+// 序号大于 0 时说明更早的事件已经被丢弃
+func (s *Stream) Subscribe() ([]Event, int, <-chan Event, func()) {
+	s.Lock()
+	defer s.Unlock()
+
+	history := make([]Event, len(s.events))
+	copy(history, s.events)
+
+	ch := make(chan Event, 256)
+
+	if s.closed {
+		close(ch)
+		return history, s.dropped, ch, func() {}
+	}
+
+	s.subscribers[ch] = struct{}{}
+
+	cancel := func() {
+		s.Lock()
+		defer s.Unlock()
+
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return history, s.dropped, ch, cancel
+}
+
+// 更早的事件被丢弃时, 代替它们推送的事件
+func TruncatedEvent() Event {
+	return Event{Type: EventOutput, Output: truncatedMarker, Time: time.Now()}
+}
+
+// 把构建输出写入事件流
+type streamWriter struct {
+	stream *Stream
+}
+
+func (w streamWriter) Write(p []byte) (int, error) {
+	w.stream.publish(Event{Type: EventOutput, Output: string(p)})
+
+	return len(p), nil
+}
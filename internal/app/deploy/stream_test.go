@@ -0,0 +1,58 @@
+package deploy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStreamHistoryLimit(t *testing.T) {
+	s := &Stream{subscribers: map[chan Event]struct{}{}}
+
+	chunk := strings.Repeat("x", 1024)
+
+	// 输出的总长度是 maxOutputSize 的两倍
+	count := maxOutputSize / len(chunk) * 2
+
+	for i := 0; i < count; i++ {
+		s.publish(Event{Type: EventOutput, Output: chunk})
+	}
+
+	s.publish(Event{Type: EventStatus})
+
+	history, first, _, cancel := s.Subscribe()
+
+	defer cancel()
+
+	size := 0
+
+	for _, e := range history {
+		size += len(e.Output)
+	}
+
+	if size > maxOutputSize {
+		t.Errorf("history size = %d, want at most %d", size, maxOutputSize)
+	}
+
+	if first == 0 || first+len(history) != count+1 {
+		t.Errorf("first = %d, history = %d, want %d events in total", first, len(history), count+1)
+	}
+
+	if history[len(history)-1].Type != EventStatus {
+		t.Errorf("last event = %s, want the latest event", history[len(history)-1].Type)
+	}
+}
+
+func TestStreamKeepsLatestEvent(t *testing.T) {
+	s := &Stream{subscribers: map[chan Event]struct{}{}}
+
+	s.publish(Event{Type: EventOutput, Output: "small"})
+	s.publish(Event{Type: EventOutput, Output: strings.Repeat("x", maxOutputSize+1)})
+
+	history, first, _, cancel := s.Subscribe()
+
+	defer cancel()
+
+	if first != 1 || len(history) != 1 {
+		t.Errorf("first = %d, history = %d, want only the latest event", first, len(history))
+	}
+}
@@ -0,0 +1,79 @@
+package deploy
+
+import (
+	"log"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/store"
+	"github.com/pkg/errors"
+)
+
+// 部署记录中保留的崩溃记录数量
+const maxExits = 10
+
+// 记录容器的崩溃, 在宽限期内崩溃时回滚到上一个版本. 容器被 hooker 停止或者不再重启时结束
+func watch(task Task, record model.Log, exits <-chan error) {
+	rolledBack := false
+
+	for e := range exits {
+		exit, ok := errors.Cause(e).(*container.ExitError)
+
+		if !ok {
+			log.Printf("%+v\n", e)
+			continue
+		}
+
+		log.Printf("%s, restart: %v\n", exit.Error(), exit.Restart)
+
+		if err := recordExit(record.ProjectId, record.Id, exit); err != nil {
+			log.Printf("%+v\n", err)
+		}
+
+		if task.Rollback && !rolledBack && exit.ExitedAt.Sub(record.FinishedAt) < task.GracePeriod {
+			rolledBack = true
+			rollback(task, record.Id, record.Commit, exit.Error())
+		}
+	}
+}
+
+// 重新监控程序重启之前部署的容器, 容器的崩溃继续按照重启策略处理并且记录到部署记录中
+func Supervise(task Task, record model.Log, id string) error {
+	exits, err := container.Supervise(id, task.Options.Restart)
+
+	if err != nil {
+		return err
+	}
+
+	go watch(task, record, exits)
+
+	return nil
+}
+
+// 在部署记录中保存容器的崩溃, 不再重启时标记为不健康
+func recordExit(projectId string, logId string, exit *container.ExitError) error {
+	l, err := store.Default.GetLog(projectId, logId)
+
+	if err != nil {
+		return err
+	}
+
+	l.Exits = append(l.Exits, model.Exit{
+		Code:      exit.Code,
+		Logs:      exit.Logs,
+		Restarted: exit.Restart,
+		ExitedAt:  exit.ExitedAt,
+	})
+
+	if len(l.Exits) > maxExits {
+		l.Exits = l.Exits[len(l.Exits)-maxExits:]
+	}
+
+	if exit.Restart {
+		l.Restarts++
+	} else {
+		l.Unhealthy = true
+	}
+
+	return store.Default.UpdateLog(l)
+}
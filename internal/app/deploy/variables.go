@@ -0,0 +1,206 @@
+package deploy
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/axetroy/hooker/internal/app/glob"
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/vault"
+)
+
+// 部署日志中替换密钥的字符串
+const secretMask = "******"
+
+// 变量规则中默认环境的名称
+const defaultEnvironment = "default"
+
+// 当前环境生效的环境变量, 同名的变量中指定了环境的优先. 返回解密之后的变量和需要隐藏的密钥, 其他环境的密钥也会被隐藏.
+// 预览环境部署的是合并请求的代码, 可能来自 fork 的仓库, 没有指定环境的密钥只在默认环境生效
+func resolveVariables(variables []model.Variable, environment string) ([]model.Variable, []string, error) {
+	if environment == "" {
+		environment = defaultEnvironment
+	}
+
+	resolved := map[string]model.Variable{}
+	secrets := make([]string, 0)
+
+	for _, v := range variables {
+		if v.Secret {
+			value, err := vault.Decrypt(v.Value)
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if value != "" {
+				secrets = append(secrets, value)
+			}
+		}
+
+		if v.Environment != "" && !glob.Match(v.Environment, environment) {
+			continue
+		}
+
+		if v.Secret && v.Environment == "" && environment != defaultEnvironment {
+			continue
+		}
+
+		if old, ok := resolved[v.Name]; ok && old.Environment != "" && v.Environment == "" {
+			continue
+		}
+
+		resolved[v.Name] = v
+	}
+
+	list := make([]model.Variable, 0, len(resolved))
+
+	for _, v := range resolved {
+		// 只有密钥是加密保存的, 普通变量的值保持不变
+		if v.Secret {
+			value, err := vault.Decrypt(v.Value)
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			v.Value = value
+		}
+
+		list = append(list, v)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list, secrets, nil
+}
+
+// 替换了密钥的错误, errors.Cause 仍然返回原来的错误
+type maskedError struct {
+	cause   error
+	message string
+}
+
+func (e *maskedError) Error() string {
+	return e.message
+}
+
+func (e *maskedError) Cause() error {
+	return e.cause
+}
+
+// 替换错误信息中的密钥, 执行失败的命令可能在错误信息中输出密钥
+func maskError(err error, secrets []string) error {
+	if err == nil || len(secrets) == 0 {
+		return err
+	}
+
+	var buf bytes.Buffer
+
+	w := newMaskWriter(&buf, secrets)
+
+	_, _ = w.Write([]byte(err.Error()))
+	_ = w.Flush()
+
+	if buf.String() == err.Error() {
+		return err
+	}
+
+	return &maskedError{cause: err, message: buf.String()}
+}
+
+// 把写入的内容中的密钥替换为 ******. 密钥可能被拆分到多次写入中,
+// 结尾可能是密钥开头的部分会保留到下一次写入, 部署结束之后需要调用 Flush
+type maskWriter struct {
+	sync.Mutex
+	writer  io.Writer
+	secrets [][]byte // 按照长度从长到短排序, 先替换长的密钥, 避免密钥之间互相包含
+	pending []byte   // 还不能确定是否为密钥的内容
+}
+
+func newMaskWriter(writer io.Writer, secrets []string) *maskWriter {
+	w := &maskWriter{writer: writer}
+
+	for _, s := range secrets {
+		if s != "" {
+			w.secrets = append(w.secrets, []byte(s))
+		}
+	}
+
+	sort.Slice(w.secrets, func(i, j int) bool {
+		return len(w.secrets[i]) > len(w.secrets[j])
+	})
+
+	return w
+}
+
+func (w *maskWriter) Write(p []byte) (int, error) {
+	if len(w.secrets) == 0 {
+		return w.writer.Write(p)
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	w.pending = append(w.pending, p...)
+
+	if out := w.mask(false); len(out) > 0 {
+		if _, err := w.writer.Write(out); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// 写入保留的内容
+func (w *maskWriter) Flush() error {
+	w.Lock()
+	defer w.Unlock()
+
+	if len(w.pending) == 0 {
+		return nil
+	}
+
+	_, err := w.writer.Write(w.mask(true))
+
+	return err
+}
+
+// 替换 pending 中的密钥, 返回可以写入的内容. final 为 false 时, 结尾可能是密钥开头的部分继续保留
+func (w *maskWriter) mask(final bool) []byte {
+	out := make([]byte, 0, len(w.pending))
+	i := 0
+
+scan:
+	for i < len(w.pending) {
+		rest := w.pending[i:]
+
+		if !final {
+			for _, s := range w.secrets {
+				if len(s) > len(rest) && bytes.HasPrefix(s, rest) {
+					break scan
+				}
+			}
+		}
+
+		for _, s := range w.secrets {
+			if bytes.HasPrefix(rest, s) {
+				out = append(out, secretMask...)
+				i += len(s)
+				continue scan
+			}
+		}
+
+		out = append(out, w.pending[i])
+		i++
+	}
+
+	w.pending = append(w.pending[:0], w.pending[i:]...)
+
+	return out
+}
@@ -0,0 +1,172 @@
+package deploy
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/pkg/errors"
+)
+
+func TestMaskWriter(t *testing.T) {
+	secrets := []string{"s3cr3t-token", "s3cr3t", "multi\nline"}
+
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{
+			name:   "single write",
+			writes: []string{"token=s3cr3t-token password=s3cr3t\n"},
+			want:   "token=****** password=******\n",
+		},
+		{
+			name:   "secret split across writes",
+			writes: []string{"token=s3c", "r3t-to", "ken\n"},
+			want:   "token=******\n",
+		},
+		{
+			name:   "secret split byte by byte",
+			writes: []string{"a", "s", "3", "c", "r", "3", "t", "b"},
+			want:   "a******b",
+		},
+		{
+			name:   "shorter secret at the end",
+			writes: []string{"password=s3cr3t"},
+			want:   "password=******",
+		},
+		{
+			name:   "prefix of a secret",
+			writes: []string{"s3cr", "et"},
+			want:   "s3cret",
+		},
+		{
+			name:   "multiline secret",
+			writes: []string{"key: multi\n", "line\n"},
+			want:   "key: ******\n",
+		},
+		{
+			name:   "no secret",
+			writes: []string{"Step 1/3 : FROM golang\n", "Step 2/3\n"},
+			want:   "Step 1/3 : FROM golang\nStep 2/3\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			w := newMaskWriter(&buf, secrets)
+
+			for _, s := range tt.writes {
+				if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+					t.Fatalf("Write() = %d, %v", n, err)
+				}
+			}
+
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			if buf.String() != tt.want {
+				t.Errorf("output = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskWriterWithoutSecrets(t *testing.T) {
+	var buf bytes.Buffer
+
+	w := newMaskWriter(&buf, []string{""})
+
+	_, _ = w.Write([]byte("s3c"))
+
+	if buf.String() != "s3c" {
+		t.Errorf("output = %q, want written immediately", buf.String())
+	}
+}
+
+func TestMaskError(t *testing.T) {
+	cause := errors.New("no changes")
+	err := maskError(errors.Wrap(cause, "exit code 1: password=s3cr3t"), []string{"s3cr3t"})
+
+	if err.Error() != "exit code 1: password=******: no changes" {
+		t.Errorf("Error() = %s", err.Error())
+	}
+
+	if errors.Cause(err) != cause {
+		t.Errorf("Cause() = %v, want the original cause", errors.Cause(err))
+	}
+
+	if maskError(cause, []string{"s3cr3t"}) != cause {
+		t.Error("maskError() changed an error without secrets")
+	}
+}
+
+func TestResolveVariables(t *testing.T) {
+	variables := []model.Variable{
+		{Name: "MODE", Value: "production"},
+		{Name: "MODE", Value: "preview", Environment: "pr-*"},
+		{Name: "DATABASE_URL", Value: "postgres://production", Secret: true},
+		{Name: "PREVIEW_TOKEN", Value: "preview-token", Secret: true, Environment: "pr-*"},
+		{Name: "DEFAULT_TOKEN", Value: "default-token", Secret: true, Environment: "default"},
+	}
+
+	tests := []struct {
+		name        string
+		environment string
+		want        map[string]string
+	}{
+		{
+			name: "default environment",
+			want: map[string]string{"MODE": "production", "DATABASE_URL": "postgres://production", "DEFAULT_TOKEN": "default-token"},
+		},
+		{
+			// 没有指定环境的密钥不会注入到合并请求的代码中
+			name:        "preview environment",
+			environment: "pr-1",
+			want:        map[string]string{"MODE": "preview", "PREVIEW_TOKEN": "preview-token"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, secrets, err := resolveVariables(variables, tt.environment)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string]string{}
+
+			for _, v := range list {
+				got[v.Name] = v.Value
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("variables = %v, want %v", got, tt.want)
+			}
+
+			// 所有环境的密钥都会被隐藏
+			if len(secrets) != 3 {
+				t.Errorf("secrets = %v, want all secrets", secrets)
+			}
+		})
+	}
+}
+
+func TestResolvePlainVariable(t *testing.T) {
+	// 普通变量不会被解密, 即使值以加密的前缀开头
+	variables, _, err := resolveVariables([]model.Variable{{Name: "MODE", Value: "enc:production"}}, "")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(variables) != 1 || variables[0].Value != "enc:production" {
+		t.Errorf("variables = %+v", variables)
+	}
+}
@@ -0,0 +1,34 @@
+package forge
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// 在 issue 或者合并请求中发表评论, 用于回复评论中的部署命令
+func Comment(t Target, number int64, pullRequest bool, body string) error {
+	if t.Token == "" {
+		return errors.New("access token is required to comment")
+	}
+
+	_, path := t.split()
+	data := map[string]interface{}{"body": body}
+
+	switch t.Provider {
+	case "github", "gitea":
+		// 合并请求也是 issue, 使用同样的接口
+		return t.request("POST", fmt.Sprintf("/repos/%s/issues/%d/comments", path, number), data, nil)
+	case "gitlab":
+		kind := "issues"
+
+		if pullRequest {
+			kind = "merge_requests"
+		}
+
+		return t.request("POST", fmt.Sprintf("/projects/%s/%s/%d/notes", url.PathEscape(path), kind, number), data, nil)
+	default:
+		return errors.Errorf("provider '%s' does not support comments", t.Provider)
+	}
+}
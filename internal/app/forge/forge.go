@@ -0,0 +1,153 @@
+package forge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// hooker 的访问地址, 例如 https://hooker.example.com, 用于生成部署记录的链接
+var PublicURL string
+
+var httpClient = &http.Client{Timeout: time.Second * 10}
+
+// 访问代码托管平台 API 需要的信息
+type Target struct {
+	Provider string `json:"provider"` // 代码托管平台, 支持 github/gitlab/gitea
+	ApiURL   string `json:"api_url"`  // API 地址, 为空则根据仓库推导, 例如 https://api.github.com
+	Repo     string `json:"repo"`     // 仓库名称, 例如 github.com/owner/repo
+	Token    string `json:"token"`    // access token
+	URL      string `json:"url"`      // 环境的访问地址, {environment} 会被替换为环境名称
+}
+
+// 是否支持该代码托管平台
+func Supported(provider string) bool {
+	switch provider {
+	case "github", "gitlab", "gitea":
+		return true
+	default:
+		return false
+	}
+}
+
+// 仓库所在的域名和路径, 例如 github.com 和 owner/repo
+func (t Target) split() (host string, path string) {
+	arr := strings.SplitN(t.Repo, "/", 2)
+
+	if len(arr) != 2 {
+		return t.Repo, ""
+	}
+
+	return arr[0], arr[1]
+}
+
+// API 地址, 自建的 Github Enterprise/Gitlab/Gitea 根据仓库的域名推导
+func (t Target) api() string {
+	if t.ApiURL != "" {
+		return strings.TrimSuffix(t.ApiURL, "/")
+	}
+
+	host, _ := t.split()
+
+	switch t.Provider {
+	case "github":
+		if host == "github.com" {
+			return "https://api.github.com"
+		}
+
+		return fmt.Sprintf("https://%s/api/v3", host)
+	case "gitlab":
+		return fmt.Sprintf("https://%s/api/v4", host)
+	default:
+		return fmt.Sprintf("https://%s/api/v1", host)
+	}
+}
+
+// 请求头中的认证信息
+func (t Target) authorization() (string, string) {
+	switch t.Provider {
+	case "github", "gitea":
+		return "Authorization", "token " + t.Token
+	default:
+		return "Authorization", "Bearer " + t.Token
+	}
+}
+
+// 环境的访问地址
+func (t Target) environmentURL(environment string) string {
+	return strings.Replace(t.URL, "{environment}", environment, -1)
+}
+
+// 部署记录的链接, 没有设置 PublicURL 时为空. 该接口同时支持 cookie, 在浏览器中登录之后可以直接打开
+func LogURL(projectId string, logId string) string {
+	if PublicURL == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s/v1/project/%s/log/%s", strings.TrimSuffix(PublicURL, "/"), projectId, logId)
+}
+
+// 调用代码托管平台的 API, result 不为 nil 时解析响应
+func (t Target) request(method string, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+
+	if body != nil {
+		b, err := json.Marshal(body)
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, t.api()+path, reader)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	key, value := t.authorization()
+
+	req.Header.Set(key, value)
+	req.Header.Set("Accept", "application/json")
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := httpClient.Do(req)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	b, err := ioutil.ReadAll(res.Body)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if res.StatusCode >= 300 {
+		return errors.Errorf("%s %s: %s %s", method, req.URL.Path, res.Status, strings.TrimSpace(string(b)))
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(b, result); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,309 @@
+package forge
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/axetroy/hooker/internal/app/model"
+)
+
+// 代码托管平台收到的请求
+type call struct {
+	Method        string
+	Path          string // 转义之后的路径, 例如 Gitlab 的 owner%2Frepo
+	Authorization string
+	Body          map[string]interface{}
+}
+
+// 模拟代码托管平台的 API, 记录收到的请求
+func newForge(t *testing.T, status int) (*httptest.Server, func() []call) {
+	var (
+		mu    sync.Mutex
+		calls []call
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+
+		if err != nil {
+			t.Error(err)
+		}
+
+		c := call{Method: r.Method, Path: r.URL.EscapedPath(), Authorization: r.Header.Get("Authorization")}
+
+		if len(b) > 0 {
+			if err := json.Unmarshal(b, &c.Body); err != nil {
+				t.Errorf("invalid body %s: %v", b, err)
+			}
+
+			if r.Header.Get("Content-Type") != "application/json" {
+				t.Errorf("Content-Type = %s, want application/json", r.Header.Get("Content-Type"))
+			}
+		}
+
+		mu.Lock()
+		calls = append(calls, c)
+		mu.Unlock()
+
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"id": 42}`))
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server, func() []call {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]call(nil), calls...)
+	}
+}
+
+func TestSend(t *testing.T) {
+	defer func(url string) {
+		PublicURL = url
+	}(PublicURL)
+
+	PublicURL = "https://hooker.example.com/"
+
+	record := model.Log{
+		Id:          "20201010",
+		ProjectId:   "blog",
+		Environment: "pr-3",
+		Ref:         "refs/heads/feature",
+		Commit:      "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+		Status:      model.StatusSuccess,
+	}
+
+	logURL := "https://hooker.example.com/v1/project/blog/log/20201010"
+
+	tests := []struct {
+		name     string
+		provider string
+		want     []call
+	}{
+		{
+			name:     "github",
+			provider: "github",
+			want: []call{
+				{
+					Method:        "POST",
+					Path:          "/repos/axetroy/blog/statuses/" + record.Commit,
+					Authorization: "token secret-token",
+					Body: map[string]interface{}{
+						"state":       "success",
+						"target_url":  logURL,
+						"description": "Deployed successfully",
+						"context":     "hooker/pr-3",
+					},
+				},
+				{
+					Method:        "POST",
+					Path:          "/repos/axetroy/blog/deployments",
+					Authorization: "token secret-token",
+					Body: map[string]interface{}{
+						"ref":                    record.Commit,
+						"environment":            "pr-3",
+						"description":            "Deployment '20201010' of hooker",
+						"auto_merge":             false,
+						"required_contexts":      []interface{}{},
+						"transient_environment":  true,
+						"production_environment": false,
+					},
+				},
+				{
+					Method:        "POST",
+					Path:          "/repos/axetroy/blog/deployments/42/statuses",
+					Authorization: "token secret-token",
+					Body: map[string]interface{}{
+						"state":           "success",
+						"log_url":         logURL,
+						"environment_url": "https://pr-3.example.com",
+						"description":     "Deployed successfully",
+					},
+				},
+			},
+		},
+		{
+			name:     "gitlab",
+			provider: "gitlab",
+			want: []call{
+				{
+					Method:        "POST",
+					Path:          "/projects/axetroy%2Fblog/statuses/" + record.Commit,
+					Authorization: "Bearer secret-token",
+					Body: map[string]interface{}{
+						"state":       "success",
+						"name":        "hooker/pr-3",
+						"ref":         "feature",
+						"target_url":  logURL,
+						"description": "Deployed successfully",
+					},
+				},
+			},
+		},
+		{
+			name:     "gitea",
+			provider: "gitea",
+			want: []call{
+				{
+					Method:        "POST",
+					Path:          "/repos/axetroy/blog/statuses/" + record.Commit,
+					Authorization: "token secret-token",
+					Body: map[string]interface{}{
+						"state":       "success",
+						"target_url":  logURL,
+						"description": "Deployed successfully",
+						"context":     "hooker/pr-3",
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, calls := newForge(t, http.StatusCreated)
+
+			target := Target{
+				Provider: tt.provider,
+				ApiURL:   server.URL + "/",
+				Repo:     "example.com/axetroy/blog",
+				Token:    "secret-token",
+				URL:      "https://{environment}.example.com",
+			}
+
+			if err := send(target, record); err != nil {
+				t.Fatalf("send() error = %v", err)
+			}
+
+			if got := calls(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("send() requests = %+v, want %+v", got, tt.want)
+			}
+
+			if _, ok := deployments[record.Id]; ok {
+				t.Error("deployment id is kept after the deployment finished")
+			}
+		})
+	}
+}
+
+func TestSendSkipped(t *testing.T) {
+	server, calls := newForge(t, http.StatusCreated)
+
+	tests := []struct {
+		name   string
+		target Target
+		record model.Log
+	}{
+		{"no token", Target{Provider: "github", ApiURL: server.URL}, model.Log{Id: "1", Commit: "abc"}},
+		{"no commit", Target{Provider: "github", ApiURL: server.URL, Token: "token"}, model.Log{Id: "1"}},
+		{"unsupported provider", Target{Provider: "gitee", ApiURL: server.URL, Token: "token"}, model.Log{Id: "1", Commit: "abc"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := send(tt.target, tt.record); err != nil {
+				t.Errorf("send() error = %v", err)
+			}
+		})
+	}
+
+	if got := calls(); len(got) != 0 {
+		t.Errorf("send() requests = %+v, want none", got)
+	}
+}
+
+func TestSendError(t *testing.T) {
+	server, _ := newForge(t, http.StatusUnauthorized)
+
+	target := Target{Provider: "gitea", ApiURL: server.URL, Repo: "example.com/axetroy/blog", Token: "expired"}
+
+	if err := send(target, model.Log{Id: "1", Commit: "abc", Status: model.StatusRunning}); err == nil {
+		t.Error("send() error = nil, want error for 401 response")
+	}
+}
+
+func TestComment(t *testing.T) {
+	tests := []struct {
+		name        string
+		provider    string
+		pullRequest bool
+		want        call
+	}{
+		{
+			name:        "github pull request",
+			provider:    "github",
+			pullRequest: true,
+			want:        call{Method: "POST", Path: "/repos/axetroy/blog/issues/3/comments", Authorization: "token secret-token"},
+		},
+		{
+			name:     "gitea issue",
+			provider: "gitea",
+			want:     call{Method: "POST", Path: "/repos/axetroy/blog/issues/3/comments", Authorization: "token secret-token"},
+		},
+		{
+			name:        "gitlab merge request",
+			provider:    "gitlab",
+			pullRequest: true,
+			want:        call{Method: "POST", Path: "/projects/axetroy%2Fblog/merge_requests/3/notes", Authorization: "Bearer secret-token"},
+		},
+		{
+			name:     "gitlab issue",
+			provider: "gitlab",
+			want:     call{Method: "POST", Path: "/projects/axetroy%2Fblog/issues/3/notes", Authorization: "Bearer secret-token"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, calls := newForge(t, http.StatusCreated)
+
+			target := Target{Provider: tt.provider, ApiURL: server.URL, Repo: "example.com/axetroy/blog", Token: "secret-token"}
+
+			if err := Comment(target, 3, tt.pullRequest, "Deployed"); err != nil {
+				t.Fatalf("Comment() error = %v", err)
+			}
+
+			tt.want.Body = map[string]interface{}{"body": "Deployed"}
+
+			if got := calls(); !reflect.DeepEqual(got, []call{tt.want}) {
+				t.Errorf("Comment() requests = %+v, want %+v", got, []call{tt.want})
+			}
+		})
+	}
+}
+
+func TestCommentWithoutToken(t *testing.T) {
+	if err := Comment(Target{Provider: "github", Repo: "github.com/axetroy/blog"}, 3, true, "Deployed"); err == nil {
+		t.Error("Comment() error = nil, want error without access token")
+	}
+
+	if err := Comment(Target{Provider: "gitee", Token: "token"}, 3, true, "Deployed"); err == nil {
+		t.Error("Comment() error = nil, want error for unsupported provider")
+	}
+}
+
+func TestApi(t *testing.T) {
+	tests := []struct {
+		target Target
+		want   string
+	}{
+		{Target{Provider: "github", Repo: "github.com/axetroy/blog"}, "https://api.github.com"},
+		{Target{Provider: "github", Repo: "git.example.com/axetroy/blog"}, "https://git.example.com/api/v3"},
+		{Target{Provider: "gitlab", Repo: "gitlab.com/axetroy/blog"}, "https://gitlab.com/api/v4"},
+		{Target{Provider: "gitea", Repo: "gitea.com/axetroy/blog"}, "https://gitea.com/api/v1"},
+		{Target{Provider: "gitea", ApiURL: "http://localhost:3000/api/v1/", Repo: "gitea.com/axetroy/blog"}, "http://localhost:3000/api/v1"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.target.api(); got != tt.want {
+			t.Errorf("api() of %s = %s, want %s", tt.target.Repo, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package forge
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/axetroy/hooker/internal/app/model"
+)
+
+// 默认环境在代码托管平台中的名称
+const defaultEnvironment = "production"
+
+type report struct {
+	target Target
+	record model.Log
+}
+
+var (
+	reports = make(chan report, 1024)
+	once    sync.Once
+
+	// 部署记录 ID -> Github Deployment ID, 部署结束后删除
+	deployments = map[string]int64{}
+)
+
+// 把部署状态回报给代码托管平台, 在后台按照顺序发送, 失败只记录日志
+func Report(target Target, record model.Log) {
+	once.Do(func() {
+		go worker()
+	})
+
+	select {
+	case reports <- report{target: target, record: record}:
+	default:
+		log.Printf("Drop status report of deployment '%s'\n", record.Id)
+	}
+}
+
+func worker() {
+	for r := range reports {
+		if err := send(r.target, r.record); err != nil {
+			log.Printf("%+v\n", err)
+		}
+	}
+}
+
+func send(t Target, record model.Log) error {
+	// 没有指定 commit 的部署在克隆之后才知道 commit
+	if record.Commit == "" || t.Token == "" {
+		return nil
+	}
+
+	switch t.Provider {
+	case "github":
+		return githubStatus(t, record)
+	case "gitlab":
+		return gitlabStatus(t, record)
+	case "gitea":
+		return giteaStatus(t, record)
+	default:
+		return nil
+	}
+}
+
+func environment(record model.Log) string {
+	if record.Environment == "" {
+		return defaultEnvironment
+	}
+
+	return record.Environment
+}
+
+func description(record model.Log) string {
+	switch record.Status {
+	case model.StatusPending:
+		return "Deployment is pending"
+	case model.StatusRunning:
+		return "Deploying"
+	case model.StatusSuccess:
+		return "Deployed successfully"
+	case model.StatusFailure:
+		return "Deployment failed"
+	case model.StatusCanceled:
+		return "Superseded by a newer deployment"
+	case model.StatusSkipped:
+		return "Deployment skipped"
+	default:
+		return string(record.Status)
+	}
+}
+
+// 删除值为空字符串的字段, 例如没有设置 PublicURL 时的 target_url
+func compact(body map[string]interface{}) map[string]interface{} {
+	for key, value := range body {
+		if value == "" {
+			delete(body, key)
+		}
+	}
+
+	return body
+}
+
+// 部署是否已经结束
+func finished(status model.Status) bool {
+	return status != model.StatusPending && status != model.StatusRunning
+}
+
+// Github 的 commit status 和 deployment status
+func githubState(status model.Status) (string, string) {
+	switch status {
+	case model.StatusPending:
+		return "pending", "queued"
+	case model.StatusRunning:
+		return "pending", "in_progress"
+	case model.StatusSuccess:
+		return "success", "success"
+	case model.StatusSkipped:
+		return "success", "inactive"
+	case model.StatusCanceled:
+		return "error", "error"
+	default:
+		return "failure", "failure"
+	}
+}
+
+func githubStatus(t Target, record model.Log) error {
+	_, path := t.split()
+	env := environment(record)
+	state, deploymentState := githubState(record.Status)
+
+	if err := t.request("POST", fmt.Sprintf("/repos/%s/statuses/%s", path, record.Commit), compact(map[string]interface{}{
+		"state":       state,
+		"target_url":  LogURL(record.ProjectId, record.Id),
+		"description": description(record),
+		"context":     "hooker/" + env,
+	}), nil); err != nil {
+		return err
+	}
+
+	id, ok := deployments[record.Id]
+
+	if !ok {
+		var deployment struct {
+			Id int64 `json:"id"`
+		}
+
+		if err := t.request("POST", fmt.Sprintf("/repos/%s/deployments", path), map[string]interface{}{
+			"ref":                    record.Commit,
+			"environment":            env,
+			"description":            fmt.Sprintf("Deployment '%s' of hooker", record.Id),
+			"auto_merge":             false,
+			"required_contexts":      []string{},
+			"transient_environment":  record.Environment != "",
+			"production_environment": record.Environment == "",
+		}, &deployment); err != nil {
+			return err
+		}
+
+		id = deployment.Id
+		deployments[record.Id] = id
+	}
+
+	if finished(record.Status) {
+		delete(deployments, record.Id)
+	}
+
+	return t.request("POST", fmt.Sprintf("/repos/%s/deployments/%d/statuses", path, id), compact(map[string]interface{}{
+		"state":           deploymentState,
+		"log_url":         LogURL(record.ProjectId, record.Id),
+		"environment_url": t.environmentURL(env),
+		"description":     description(record),
+	}), nil)
+}
+
+func gitlabState(status model.Status) string {
+	switch status {
+	case model.StatusPending:
+		return "pending"
+	case model.StatusRunning:
+		return "running"
+	case model.StatusSuccess:
+		return "success"
+	case model.StatusSkipped:
+		return "skipped"
+	case model.StatusCanceled:
+		return "canceled"
+	default:
+		return "failed"
+	}
+}
+
+func gitlabStatus(t Target, record model.Log) error {
+	_, path := t.split()
+	env := environment(record)
+
+	body := map[string]interface{}{
+		"state":       gitlabState(record.Status),
+		"name":        "hooker/" + env,
+		"target_url":  LogURL(record.ProjectId, record.Id),
+		"description": description(record),
+	}
+
+	// Gitlab 只接受分支或者标签的名称
+	for _, prefix := range []string{"refs/heads/", "refs/tags/"} {
+		if strings.HasPrefix(record.Ref, prefix) {
+			body["ref"] = strings.TrimPrefix(record.Ref, prefix)
+		}
+	}
+
+	return t.request("POST", fmt.Sprintf("/projects/%s/statuses/%s", url.PathEscape(path), record.Commit), compact(body), nil)
+}
+
+func giteaState(status model.Status) string {
+	switch status {
+	case model.StatusPending, model.StatusRunning:
+		return "pending"
+	case model.StatusSuccess, model.StatusSkipped:
+		return "success"
+	case model.StatusCanceled:
+		return "error"
+	default:
+		return "failure"
+	}
+}
+
+func giteaStatus(t Target, record model.Log) error {
+	_, path := t.split()
+
+	return t.request("POST", fmt.Sprintf("/repos/%s/statuses/%s", path, record.Commit), compact(map[string]interface{}{
+		"state":       giteaState(record.Status),
+		"target_url":  LogURL(record.ProjectId, record.Id),
+		"description": description(record),
+		"context":     "hooker/" + environment(record),
+	}), nil)
+}
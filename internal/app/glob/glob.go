@@ -0,0 +1,77 @@
+package glob
+
+import (
+	"regexp"
+	"strings"
+)
+
+// 把 glob 转换为正则, '*' 匹配除了 '/' 之外的任意字符, '**' 匹配任意字符, '?' 匹配除了 '/' 之外的单个字符
+func compile(pattern string) *regexp.Regexp {
+	var b strings.Builder
+
+	b.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString("$")
+
+	return regexp.MustCompile(b.String())
+}
+
+// 判断名称是否匹配 glob
+func Match(pattern string, name string) bool {
+	return compile(pattern).MatchString(name)
+}
+
+// 判断名称是否符合规则, 以 '!' 开头的规则表示排除.
+// 被任意排除规则匹配则不符合, 没有包含规则时默认符合, 否则需要被任意包含规则匹配
+func MatchRules(rules []string, name string) bool {
+	included := true
+
+	for _, rule := range rules {
+		if strings.HasPrefix(rule, "!") {
+			if Match(strings.TrimPrefix(rule, "!"), name) {
+				return false
+			}
+		} else {
+			included = false
+		}
+	}
+
+	if included {
+		return true
+	}
+
+	for _, rule := range rules {
+		if !strings.HasPrefix(rule, "!") && Match(rule, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 判断是否有任意名称符合规则
+func MatchAny(rules []string, names []string) bool {
+	for _, name := range names {
+		if MatchRules(rules, name) {
+			return true
+		}
+	}
+
+	return false
+}
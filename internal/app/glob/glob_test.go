@@ -0,0 +1,69 @@
+package glob
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{pattern: "src/*.go", name: "src/main.go", want: true},
+		{pattern: "src/*.go", name: "src/app/main.go", want: false},
+		{pattern: "src/**", name: "src/app/main.go", want: true},
+		{pattern: "src/**/*.go", name: "src/app/main.go", want: true},
+		{pattern: "*.md", name: "docs/README.md", want: false},
+		{pattern: "**.md", name: "docs/README.md", want: true},
+		{pattern: "v?.?", name: "v1.0", want: true},
+		{pattern: "v?.?", name: "v1/0", want: false},
+		{pattern: "release/v1.0", name: "release/v1x0", want: false},
+		{pattern: "feat-(1)", name: "feat-(1)", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMatchRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []string
+		path  string
+		want  bool
+	}{
+		{name: "no rules", path: "src/main.go", want: true},
+		{name: "included", rules: []string{"docs/**", "src/**"}, path: "src/main.go", want: true},
+		{name: "not included", rules: []string{"src/**"}, path: "docs/README.md", want: false},
+		{name: "excluded", rules: []string{"src/**", "!src/**/*_test.go"}, path: "src/app/main_test.go", want: false},
+		{name: "only exclusions", rules: []string{"!docs/**"}, path: "src/main.go", want: true},
+		{name: "only exclusions excluded", rules: []string{"!docs/**"}, path: "docs/README.md", want: false},
+		{name: "exclusion before inclusion", rules: []string{"!**.md", "**"}, path: "README.md", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchRules(tt.rules, tt.path); got != tt.want {
+				t.Errorf("MatchRules() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchAny(t *testing.T) {
+	rules := []string{"src/**", "!**.md"}
+
+	if !MatchAny(rules, []string{"README.md", "src/main.go"}) {
+		t.Error("MatchAny() = false, want true when any changed file matches")
+	}
+
+	if MatchAny(rules, []string{"README.md", "src/README.md", "docs/index.html"}) {
+		t.Error("MatchAny() = true, want false when all changed files are filtered")
+	}
+
+	if MatchAny(rules, nil) {
+		t.Error("MatchAny() = true without changed files")
+	}
+}
@@ -0,0 +1,227 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/deploy"
+	"github.com/axetroy/hooker/internal/app/forge"
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/store"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrCommandDenied     = errors.New("user is not allowed to run the command")
+	ErrNoRelease         = errors.New("no successful deployment to redeploy")
+	ErrNoPreviousRelease = errors.New("no previous successful deployment to roll back to")
+)
+
+// 评论中支持的命令
+const (
+	commandDeploy   = "/deploy"   // 部署合并请求的最新提交, 或者指定的分支, 例如 /deploy master
+	commandRedeploy = "/redeploy" // 重新部署最近一次成功部署的提交
+	commandRollback = "/rollback" // 部署上一次成功部署的提交
+)
+
+// 没有指定角色时允许执行命令的仓库角色
+var defaultRoles = []string{"OWNER", "MEMBER", "COLLABORATOR"}
+
+// issue 或者合并请求中的评论
+type Comment struct {
+	Number      int64  // issue 或者合并请求的编号
+	PullRequest bool   // 是否是合并请求的评论
+	User        string // 评论的用户
+	Association string // 用户在仓库中的角色, 例如 OWNER/MEMBER/COLLABORATOR
+	Body        string // 评论的内容
+}
+
+// 解析评论第一行中的命令, 例如 /deploy master, 不是命令则返回空
+func parseCommand(body string) (command string, args []string) {
+	line := strings.SplitN(strings.TrimSpace(body), "\n", 2)[0]
+	fields := strings.Fields(line)
+
+	if len(fields) == 0 {
+		return "", nil
+	}
+
+	switch fields[0] {
+	case commandDeploy, commandRedeploy, commandRollback:
+		return fields[0], fields[1:]
+	default:
+		return "", nil
+	}
+}
+
+// 评论的用户是否允许执行命令, 在用户列表中或者拥有指定的仓库角色
+func allowed(commands model.Commands, comment *Comment) bool {
+	for _, user := range commands.Users {
+		if strings.EqualFold(user, comment.User) {
+			return true
+		}
+	}
+
+	if comment.Association == "" {
+		return false
+	}
+
+	roles := commands.Roles
+
+	if len(roles) == 0 {
+		roles = defaultRoles
+	}
+
+	for _, role := range roles {
+		if strings.EqualFold(role, comment.Association) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 执行评论中的命令, 并且把结果回复到评论所在的 issue 或者合并请求中
+func runCommand(project model.Project, provider string, comment *Comment) (*model.Log, error) {
+	command, args := parseCommand(comment.Body)
+
+	if command == "" {
+		return nil, nil
+	}
+
+	target := projectTarget(project)
+
+	if target.Provider == "" {
+		target.Provider = provider
+	}
+
+	var (
+		record *model.Log
+		err    error
+	)
+
+	if !allowed(project.Commands, comment) {
+		err = errors.WithStack(ErrCommandDenied)
+	} else {
+		record, err = commandDeployment(project, command, args, comment)
+	}
+
+	body := commandReply(comment, command, record, err)
+
+	go func() {
+		if e := forge.Comment(target, comment.Number, comment.PullRequest, body); e != nil {
+			log.Printf("%+v\n", e)
+		}
+	}()
+
+	return record, err
+}
+
+// 命令执行结果的回复, 部署记录的链接在浏览器中登录之后可以直接打开
+func commandReply(comment *Comment, command string, record *model.Log, err error) string {
+	if errors.Cause(err) == ErrCommandDenied {
+		return fmt.Sprintf("@%s is not allowed to run `%s`", comment.User, command)
+	} else if err != nil {
+		return fmt.Sprintf("@%s `%s` failed: %s", comment.User, command, err.Error())
+	}
+
+	body := fmt.Sprintf("@%s deployment `%s` is queued", comment.User, record.Id)
+
+	if record.Environment != "" {
+		body += fmt.Sprintf(" for environment `%s`", record.Environment)
+	}
+
+	if u := forge.LogURL(record.ProjectId, record.Id); u != "" {
+		body += fmt.Sprintf(", see the [log](%s)", u)
+	}
+
+	return body
+}
+
+// 根据命令生成部署任务并加入队列
+func commandDeployment(project model.Project, command string, args []string, comment *Comment) (*model.Log, error) {
+	trigger := "comment"
+
+	// 开启了预览环境的合并请求部署到预览环境, 否则部署到默认环境
+	preview := comment.PullRequest && project.Preview.Enabled
+
+	environment := ""
+
+	if preview {
+		environment = previewEnvironment(comment.Number)
+	}
+
+	var ref, commit string
+
+	switch command {
+	case commandDeploy:
+		if comment.PullRequest {
+			ref = fmt.Sprintf("refs/pull/%d/head", comment.Number)
+		} else if len(args) > 0 {
+			ref = args[0]
+
+			if !strings.HasPrefix(ref, "refs/") {
+				ref = branchPrefix + ref
+			}
+		}
+	case commandRedeploy, commandRollback:
+		release, err := findRelease(project.Id, environment, command == commandRollback)
+
+		if err != nil {
+			return nil, err
+		}
+
+		ref = release.Ref
+		commit = release.Commit
+	}
+
+	task, err := environmentTask(project, trigger, environment, ref, commit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// 保留了镜像的版本不需要重新构建
+	if commit != "" {
+		if release, err := container.FindRelease(context.Background(), project.Id, environment, commit); err == nil {
+			task.Options.Image = release.Image
+		}
+	}
+
+	return deploy.Enqueue(*task)
+}
+
+// 查找环境中最近一次成功部署的记录, previous 为 true 时查找与之不同的上一个提交
+func findRelease(projectId string, environment string, previous bool) (*model.Log, error) {
+	logs, err := store.Default.ListLogs(projectId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var current string
+
+	for _, l := range logs {
+		if l.Environment != environment || l.Status != model.StatusSuccess || l.Commit == "" {
+			continue
+		}
+
+		if !previous {
+			return &l, nil
+		}
+
+		if current == "" {
+			current = l.Commit
+		} else if l.Commit != current {
+			return &l, nil
+		}
+	}
+
+	if previous {
+		return nil, ErrNoPreviousRelease
+	}
+
+	return nil, ErrNoRelease
+}
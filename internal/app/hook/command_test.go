@@ -0,0 +1,46 @@
+package hook
+
+import (
+	"testing"
+
+	"github.com/axetroy/hooker/internal/app/forge"
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/pkg/errors"
+)
+
+func TestCommandReply(t *testing.T) {
+	defer func(url string) {
+		forge.PublicURL = url
+	}(forge.PublicURL)
+
+	forge.PublicURL = "https://hooker.example.com/"
+
+	comment := &Comment{User: "axetroy"}
+	record := &model.Log{Id: "abc", ProjectId: "blog", Environment: "pr-1"}
+
+	tests := []struct {
+		name    string
+		command string
+		record  *model.Log
+		err     error
+		want    string
+	}{
+		{
+			name:    "queued",
+			command: "/deploy",
+			record:  record,
+			// 部署记录详情支持 cookie, 不需要 Authorization 请求头
+			want: "@axetroy deployment `abc` is queued for environment `pr-1`, see the [log](https://hooker.example.com/v1/project/blog/log/abc)",
+		},
+		{name: "denied", command: "/rollback", err: errors.WithStack(ErrCommandDenied), want: "@axetroy is not allowed to run `/rollback`"},
+		{name: "failed", command: "/rollback", err: errors.New("no release"), want: "@axetroy `/rollback` failed: no release"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := commandReply(comment, tt.command, tt.record, tt.err); got != tt.want {
+				t.Errorf("commandReply() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
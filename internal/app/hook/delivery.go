@@ -0,0 +1,122 @@
+package hook
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/store"
+	"github.com/pkg/errors"
+)
+
+var ErrDuplicateDelivery = errors.New("duplicate delivery")
+
+// 替换请求中的密钥的字符串
+const deliveryMask = "******"
+
+// 请求中包含密钥的请求头和 URL 参数, 保存之前被隐藏
+var (
+	secretHeaders = []string{"Authorization", "X-Gitlab-Token", "X-Gitee-Token", "X-Hooker-Token"}
+	secretQueries = []string{"auth"}
+)
+
+// 隐藏请求中的密钥, 返回的请求使用新的请求头, 不会修改原来的请求头
+func MaskDelivery(delivery model.Delivery) model.Delivery {
+	header := http.Header{}
+
+	for key, values := range delivery.Header {
+		header[key] = values
+	}
+
+	for _, key := range secretHeaders {
+		if header.Get(key) != "" {
+			header.Set(key, deliveryMask)
+		}
+	}
+
+	delivery.Header = header
+
+	if query, err := url.ParseQuery(delivery.Query); err == nil {
+		for _, key := range secretQueries {
+			if query.Get(key) != "" {
+				query.Set(key, deliveryMask)
+			}
+		}
+
+		delivery.Query = query.Encode()
+	}
+
+	return delivery
+}
+
+// 通过校验的请求使用请求 ID 生成固定的 ID, 用于识别重复的请求
+func deliveryKey(provider string, deliveryId string) string {
+	sum := sha256.Sum256([]byte(provider + ":" + deliveryId))
+	return hex.EncodeToString(sum[:12])
+}
+
+// 检查是否已经处理过同样的请求, 重新执行的请求不检查.
+// 只有通过校验的请求会占用请求 ID, 避免伪造的请求导致真实的请求被忽略
+func checkDuplicate(delivery *model.Delivery) error {
+	if delivery.DeliveryId == "" || delivery.ReplayOf != "" {
+		return nil
+	}
+
+	delivery.Id = deliveryKey(delivery.Provider, delivery.DeliveryId)
+
+	// 先保存请求占用请求 ID, 同时收到的重复请求只有一个可以保存成功
+	if err := store.Default.CreateDelivery(delivery); err != nil {
+		if _, e := store.Default.GetDelivery(delivery.Id); e == nil {
+			return ErrDuplicateDelivery
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// 保存请求和处理的结果
+func saveDelivery(delivery *model.Delivery) error {
+	if delivery.CreatedAt.IsZero() {
+		return store.Default.CreateDelivery(delivery)
+	}
+
+	return store.Default.UpdateDelivery(delivery)
+}
+
+// 重新执行保存的请求, 返回新的请求和产生的部署记录, 处理失败的原因保存在新的请求中.
+// 保存的请求中的密钥已经被隐藏, 原来的请求通过了校验时不再校验, 通过 URL 参数传递的克隆认证信息不再可用
+func Replay(id string) (*model.Delivery, *model.Log, error) {
+	origin, err := store.Default.GetDelivery(id)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p, ok := Providers[origin.Provider]
+
+	if !ok {
+		return nil, nil, errors.Errorf("invalid provider '%s'", origin.Provider)
+	}
+
+	delivery := model.Delivery{
+		Provider:   origin.Provider,
+		DeliveryId: origin.DeliveryId,
+		Event:      origin.Event,
+		Route:      origin.Route,
+		Header:     origin.Header,
+		Query:      origin.Query,
+		Payload:    origin.Payload,
+		Ip:         origin.Ip,
+		Verified:   origin.Verified,
+		ReplayOf:   origin.Id,
+	}
+
+	// 处理失败的原因已经保存在请求中
+	record, _ := handle(p, &delivery)
+
+	return &delivery, record, nil
+}
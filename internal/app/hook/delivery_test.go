@@ -0,0 +1,168 @@
+package hook
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/store"
+)
+
+func TestMaskDelivery(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Gitlab-Token", mockSecret)
+	header.Set("X-Gitlab-Event", "Push Hook")
+
+	delivery := MaskDelivery(model.Delivery{Header: header, Query: "auth=token%3A%2F%2Fxxx&port=8080%3A80"})
+
+	if got := http.Header(delivery.Header).Get("X-Gitlab-Token"); got != deliveryMask {
+		t.Errorf("X-Gitlab-Token = %s, want masked", got)
+	}
+
+	if got := http.Header(delivery.Header).Get("X-Gitlab-Event"); got != "Push Hook" {
+		t.Errorf("X-Gitlab-Event = %s", got)
+	}
+
+	if header.Get("X-Gitlab-Token") != mockSecret {
+		t.Error("MaskDelivery() modified the original header")
+	}
+
+	query, _ := url.ParseQuery(delivery.Query)
+
+	if query.Get("auth") != deliveryMask || query.Get("port") != "8080:80" {
+		t.Errorf("query = %s", delivery.Query)
+	}
+}
+
+func TestHandleMasksPersistedDelivery(t *testing.T) {
+	db, err := store.NewFileStore(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(s store.Store) {
+		store.Default = s
+	}(store.Default)
+
+	store.Default = db
+
+	if err := db.CreateProject(&model.Project{Id: "hook-example", Name: "hook-example", Repo: "gitlab.com/axetroy/hook-example", Secret: mockSecret}); err != nil {
+		t.Fatal(err)
+	}
+
+	header := http.Header{}
+	header.Set("X-Gitlab-Token", mockSecret)
+	header.Set("X-Gitlab-Event", "Push Hook")
+
+	delivery := model.Delivery{
+		Provider: "gitlab",
+		Route:    "gitlab.com/axetroy/hook-example",
+		Event:    Gitlab{}.Event(header),
+		Header:   header,
+		Query:    "auth=token%3A%2F%2Fxxx",
+		Payload:  string(readPayload(t, "gitlab/delete.json")),
+	}
+
+	if _, err := handle(Gitlab{}, &delivery); err != nil {
+		t.Fatalf("handle() error = %v", err)
+	}
+
+	saved, err := db.GetDelivery(delivery.Id)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := http.Header(saved.Header).Get("X-Gitlab-Token"); got != deliveryMask {
+		t.Errorf("persisted X-Gitlab-Token = %s, want masked", got)
+	}
+
+	if query, _ := url.ParseQuery(saved.Query); query.Get("auth") != deliveryMask {
+		t.Errorf("persisted query = %s, want masked", saved.Query)
+	}
+
+	if !saved.Verified {
+		t.Error("persisted delivery is not verified")
+	}
+
+	// 保存的请求中没有原来的密钥, 重新执行时使用原来的请求的校验结果
+	replay, _, err := Replay(saved.Id)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if replay.Error != "" || !replay.Verified {
+		t.Errorf("replay error = %s, verified = %v", replay.Error, replay.Verified)
+	}
+}
+
+func TestHandleDuplicateDelivery(t *testing.T) {
+	db, err := store.NewFileStore(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(s store.Store) {
+		store.Default = s
+	}(store.Default)
+
+	store.Default = db
+
+	if err := db.CreateProject(&model.Project{Id: "hook-example", Name: "hook-example", Repo: "gitlab.com/axetroy/hook-example", Secret: mockSecret}); err != nil {
+		t.Fatal(err)
+	}
+
+	receive := func() error {
+		header := http.Header{}
+		header.Set("X-Gitlab-Token", mockSecret)
+		header.Set("X-Gitlab-Event", "Push Hook")
+		header.Set("X-Gitlab-Event-UUID", "3d2b4d7e-6d0c-4f5a-9a8f-1c2d3e4f5a6b")
+
+		delivery := model.Delivery{
+			Provider:   "gitlab",
+			Route:      "gitlab.com/axetroy/hook-example",
+			DeliveryId: Gitlab{}.Delivery(header, nil),
+			Event:      Gitlab{}.Event(header),
+			Header:     header,
+			Payload:    string(readPayload(t, "gitlab/delete.json")),
+		}
+
+		_, err := handle(Gitlab{}, &delivery)
+
+		return err
+	}
+
+	if err := receive(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := receive(); err != ErrDuplicateDelivery {
+			t.Fatalf("handle() error = %v, want %v", err, ErrDuplicateDelivery)
+		}
+	}
+
+	list, err := db.ListDeliveries()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := map[model.DeliveryStatus]int{}
+
+	for _, d := range list {
+		status[d.Status]++
+
+		if got := http.Header(d.Header).Get("X-Gitlab-Token"); got != deliveryMask {
+			t.Errorf("persisted X-Gitlab-Token = %s, want masked", got)
+		}
+	}
+
+	if status[model.DeliveryProcessed] != 1 || status[model.DeliveryDuplicate] != 2 {
+		t.Errorf("deliveries = %v, want 1 processed and 2 duplicates", status)
+	}
+}
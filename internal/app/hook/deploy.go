@@ -0,0 +1,104 @@
+package hook
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/deploy"
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/schema"
+	irisContext "github.com/kataras/iris/v12/context"
+	"github.com/pkg/errors"
+)
+
+// 通过 URL 参数部署, 端口映射和认证信息都在 URL 中
+func deployWithQuery(p Provider, payload *Payload, query RouterQuery) (*model.Log, error) {
+	ports, err := query.ParsePort()
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	username, password, accessToken, err := query.ParseAuth()
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return deploy.Enqueue(deploy.Task{
+		ProjectId: repoLogId(payload.Repo),
+		Trigger:   providerName(p),
+		Options: container.Options{
+			Project: repoLogId(payload.Repo),
+			Repo:    payload.Repo,
+			URL:     p.CloneURL(payload.Repo),
+			Ref:     payload.Ref,
+			Hash:    payload.Commit,
+			Ports:   ports,
+			Proxy:   query.Proxy,
+		},
+		Auth:   p.CloneAuth(username, password, accessToken),
+		Query:  true,
+		Before: payload.Before,
+	})
+}
+
+// 没有注册的仓库, 部署记录保存在仓库名对应的 ID 下, 例如 github.com_owner_repo
+func repoLogId(repo string) string {
+	return strings.Replace(repo, "/", "_", -1)
+}
+
+// 代码托管平台的名称, 例如 github
+func providerName(p Provider) string {
+	for name, provider := range Providers {
+		if provider == p {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// 输出响应, 部署任务入队后返回 202 和部署记录, 没有部署或者重复的请求返回 200 和原因
+func response(ctx irisContext.Context, record *model.Log, err error) {
+	if errors.Cause(err) == ErrDuplicateDelivery {
+		ctx.StatusCode(http.StatusOK)
+		_, _ = ctx.WriteString("Duplicate delivery, ignored")
+	} else if err != nil {
+		ctx.StatusCode(statusCode(err))
+		msg := fmt.Sprintf("%+v", err)
+		_, _ = ctx.WriteString(msg)
+	} else if record != nil && record.Status == model.StatusSkipped {
+		ctx.StatusCode(http.StatusOK)
+		_, _ = ctx.JSON(schema.Response{
+			Message: fmt.Sprintf("Skipped: %s", record.Error),
+			Data:    record,
+			Status:  schema.StatusSuccess,
+		})
+	} else if record != nil {
+		ctx.StatusCode(http.StatusAccepted)
+		_, _ = ctx.JSON(schema.Response{
+			Data:   record,
+			Status: schema.StatusSuccess,
+		})
+	} else {
+		ctx.StatusCode(http.StatusOK)
+		_, _ = ctx.WriteString("Success!")
+	}
+}
+
+// 根据错误获取响应的状态码
+func statusCode(err error) int {
+	switch errors.Cause(err) {
+	case ErrMissingSignature, ErrInvalidSignature, ErrNoSecret:
+		return http.StatusUnauthorized
+	case ErrProjectNotFound:
+		return http.StatusNotFound
+	case ErrCommandDenied:
+		return http.StatusForbidden
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,89 @@
+package hook
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/deploy"
+	"github.com/axetroy/hooker/internal/app/glob"
+	"github.com/axetroy/hooker/internal/app/model"
+)
+
+const (
+	branchPrefix = "refs/heads/"
+	tagPrefix    = "refs/tags/"
+)
+
+// 判断项目是否需要部署该分支或者标签, 不需要部署时返回原因
+func matchRef(project model.Project, ref string) (bool, string) {
+	switch {
+	case strings.HasPrefix(ref, branchPrefix):
+		branch := strings.TrimPrefix(ref, branchPrefix)
+
+		if !glob.MatchRules(project.Branches, branch) {
+			return false, fmt.Sprintf("branch '%s' does not match the branch rules", branch)
+		}
+	case strings.HasPrefix(ref, tagPrefix):
+		tag := strings.TrimPrefix(ref, tagPrefix)
+
+		if !glob.MatchRules(project.Tags, tag) {
+			return false, fmt.Sprintf("tag '%s' does not match the tag rules", tag)
+		}
+	}
+
+	return true, ""
+}
+
+// 推送的提交
+type Commit struct {
+	Id       string   `json:"id"`
+	Added    []string `json:"added"`
+	Removed  []string `json:"removed"`
+	Modified []string `json:"modified"`
+}
+
+// 获取推送的提交中改动的文件
+func changedFiles(commits []Commit) []string {
+	files := make([]string, 0)
+
+	for _, c := range commits {
+		files = append(files, c.Added...)
+		files = append(files, c.Removed...)
+		files = append(files, c.Modified...)
+	}
+
+	return files
+}
+
+// 设置推送改动的文件, 没有提交时 (例如强制推送) 无法确定改动的文件
+func setChanges(payload *Payload, before string, commits []Commit, truncated bool) {
+	payload.Before = before
+	payload.Changes = changedFiles(commits)
+	payload.Truncated = truncated || len(commits) == 0
+}
+
+// 判断改动的文件是否需要部署, 不需要部署时返回原因.
+// 新建的分支或者标签无法确定改动的文件, 直接部署. payload 中的提交不完整时交给部署任务通过 git diff 判断
+func matchPaths(project model.Project, payload *Payload, task *deploy.Task) (bool, string) {
+	if len(project.Paths) == 0 || payload.Before == "" || isNullCommit(payload.Before) {
+		return true, ""
+	}
+
+	if payload.Truncated {
+		task.Options.Paths = project.Paths
+		task.Options.Before = payload.Before
+		return true, ""
+	}
+
+	if !glob.MatchAny(project.Paths, payload.Changes) {
+		return false, container.ErrNoMatchedChanges.Error()
+	}
+
+	return true, ""
+}
+
+// 删除分支或者标签时推送的 commit 为全 0
+func isNullCommit(commit string) bool {
+	return commit != "" && strings.Trim(commit, "0") == ""
+}
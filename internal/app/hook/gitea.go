@@ -0,0 +1,133 @@
+package hook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/pkg/errors"
+)
+
+type GiteaRepository struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Private  bool   `json:"private"`
+	HtmlUrl  string `json:"html_url"`
+	CloneUrl string `json:"clone_url"`
+}
+
+type GiteaPullRequest struct {
+	Number int64 `json:"number"`
+	Merged bool  `json:"merged"`
+	Head   struct {
+		Ref string `json:"ref"`
+		Sha string `json:"sha"`
+	} `json:"head"`
+}
+
+// Gitea 和 Gogs 的 payload 格式相同, Gogs 的合并请求没有 head.sha, 不会部署预览环境
+type GiteaHookPostData struct {
+	Ref          string           `json:"ref"`
+	Before       string           `json:"before"`
+	After        string           `json:"after"`
+	Commits      []Commit         `json:"commits"`
+	TotalCommits int              `json:"total_commits"`
+	Action       string           `json:"action"`
+	Number       int64            `json:"number"`
+	PullRequest  GiteaPullRequest `json:"pull_request"`
+	Repository   GiteaRepository  `json:"repository"`
+}
+
+// 仓库名称, 例如 gitea.com/owner/repo, 自建的 Gitea 则为 gitea.example.com/owner/repo
+func (d GiteaHookPostData) Name(defaultHost string) (string, error) {
+	host := defaultHost
+
+	if d.Repository.HtmlUrl != "" {
+		u, err := url.Parse(d.Repository.HtmlUrl)
+
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		host = u.Host
+	}
+
+	if d.Repository.FullName == "" {
+		return "", errors.New("invalid repository of payload")
+	}
+
+	return fmt.Sprintf("%s/%s", host, d.Repository.FullName), nil
+}
+
+func parseGiteaPayload(defaultHost string, event string, body []byte) (*Payload, error) {
+	var data GiteaHookPostData
+
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	name, err := data.Name(defaultHost)
+
+	if err != nil {
+		return nil, err
+	}
+
+	payload := Payload{
+		Repo: name,
+	}
+
+	switch event {
+	case "push":
+		payload.Ref = data.Ref
+		payload.Commit = data.After
+		setChanges(&payload, data.Before, data.Commits, data.TotalCommits > len(data.Commits))
+	case "pull_request":
+		payload.PullRequest = data.Number
+
+		switch data.Action {
+		case "opened", "reopened", "synchronized":
+			payload.Ref = fmt.Sprintf("refs/pull/%d/head", data.Number)
+			payload.Commit = data.PullRequest.Head.Sha
+		case "closed":
+			payload.Closed = true
+		}
+	default:
+		return nil, errors.Errorf("Invalid event '%s'", event)
+	}
+
+	return &payload, nil
+}
+
+type Gitea struct{}
+
+// event:
+// push
+// pull_request
+func (Gitea) Event(header http.Header) string {
+	return header.Get("X-Gitea-Event")
+}
+
+func (Gitea) Delivery(header http.Header, body []byte) string {
+	return header.Get("X-Gitea-Delivery")
+}
+
+func (Gitea) Verify(secret string, header http.Header, body []byte) error {
+	return VerifyHexSignature(secret, body, header.Get("X-Gitea-Signature"))
+}
+
+func (Gitea) Parse(event string, body []byte) (*Payload, error) {
+	return parseGiteaPayload("gitea.com", event, body)
+}
+
+func (Gitea) CloneURL(repo string) string {
+	return fmt.Sprintf("https://%s.git", repo)
+}
+
+func (Gitea) CloneAuth(username string, password string, accessToken string) *container.Auth {
+	return basicAuth(username, password, "oauth2", accessToken)
+}
+
+var GiteaRouter = Router(Gitea{})
@@ -0,0 +1,112 @@
+package hook
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestGiteaVerify(t *testing.T) {
+	body := readPayload(t, "gitea/pull_request.json")
+
+	tests := []struct {
+		name      string
+		provider  Provider
+		header    string
+		secret    string
+		signature string
+		want      error
+	}{
+		{"gitea", Gitea{}, "X-Gitea-Signature", mockSecret, signHex(mockSecret, body), nil},
+		{"gogs", Gogs{}, "X-Gogs-Signature", mockSecret, signHex(mockSecret, body), nil},
+		{"gogs header on gitea", Gitea{}, "X-Gogs-Signature", mockSecret, signHex(mockSecret, body), ErrMissingSignature},
+		{"wrong secret", Gitea{}, "X-Gitea-Signature", "other_secret", signHex(mockSecret, body), ErrInvalidSignature},
+		{"unsigned", Gogs{}, "X-Gogs-Signature", mockSecret, "", ErrMissingSignature},
+		{"no secret", Gitea{}, "X-Gitea-Signature", "", signHex(mockSecret, body), ErrNoSecret},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+
+			if tt.signature != "" {
+				header.Set(tt.header, tt.signature)
+			}
+
+			if err := tt.provider.Verify(tt.secret, header, body); err != tt.want {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGiteaParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		event    string
+		file     string
+		want     Payload
+		wantErr  bool
+	}{
+		{
+			name:     "pull request on self-hosted gitea",
+			provider: Gitea{},
+			event:    "pull_request",
+			file:     "gitea/pull_request.json",
+			want: Payload{
+				Repo:        "git.example.com/axetroy/hook-example",
+				Ref:         "refs/pull/2/head",
+				Commit:      "5f6d3f5d41b1e7a7a3c0c7a9d6e2f1b0c9a8d7e6",
+				PullRequest: 2,
+			},
+		},
+		{
+			name:     "deletion",
+			provider: Gitea{},
+			event:    "push",
+			file:     "gitea/delete.json",
+			want: Payload{
+				Repo:      "gitea.com/axetroy/hook-example",
+				Ref:       "refs/heads/feature",
+				Commit:    "0000000000000000000000000000000000000000",
+				Before:    "01fa2a3efeeeaddf8c9f0fafe94944ba0415d48e",
+				Changes:   []string{},
+				Truncated: true,
+			},
+		},
+		{
+			name:     "unknown event",
+			provider: Gogs{},
+			event:    "create",
+			file:     "gitea/delete.json",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.provider.Parse(tt.event, readPayload(t, tt.file))
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err == nil && !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGogsParseDefaultHost(t *testing.T) {
+	got, err := (Gogs{}).Parse("push", []byte(`{"ref": "refs/heads/master", "after": "01fa2a3e", "repository": {"full_name": "axetroy/hook-example"}}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Repo != "gogs.com/axetroy/hook-example" {
+		t.Errorf("Parse() repo = %s, want gogs.com/axetroy/hook-example", got.Repo)
+	}
+}
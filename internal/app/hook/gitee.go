@@ -0,0 +1,95 @@
+package hook
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/pkg/errors"
+)
+
+type GiteeHookPostData struct {
+	HookName          string     `json:"hook_name"`
+	Ref               string     `json:"ref"`
+	Before            string     `json:"before"`
+	After             string     `json:"after"`
+	Commits           []Commit   `json:"commits"`
+	TotalCommitsCount int        `json:"total_commits_count"`
+	Repository        Repository `json:"repository"`
+}
+
+type Gitee struct{}
+
+// event:
+// Push Hook
+// Tag Push Hook
+func (Gitee) Event(header http.Header) string {
+	return header.Get("X-Gitee-Event")
+}
+
+// Gitee 没有请求 ID, 重试的请求的 X-Gitee-Timestamp 和请求体都相同, 使用它们生成请求 ID
+func (Gitee) Delivery(header http.Header, body []byte) string {
+	timestamp := header.Get("X-Gitee-Timestamp")
+
+	if timestamp == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256(body)
+
+	return timestamp + "-" + hex.EncodeToString(sum[:])
+}
+
+// Gitee 支持两种方式, 直接携带密码, 或者携带签名 base64(hmac_sha256(timestamp + "\n" + secret))
+func (Gitee) Verify(secret string, header http.Header, body []byte) error {
+	token := header.Get("X-Gitee-Token")
+	timestamp := header.Get("X-Gitee-Timestamp")
+
+	// 密码模式
+	if timestamp == "" || VerifyToken(secret, token) == nil {
+		return VerifyToken(secret, token)
+	}
+
+	// 签名模式
+	return VerifyGiteeSignature(secret, timestamp, token)
+}
+
+func (Gitee) Parse(event string, body []byte) (*Payload, error) {
+	var data GiteeHookPostData
+
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if data.Repository.FullName == "" {
+		return nil, errors.New("invalid repository of payload")
+	}
+
+	payload := Payload{
+		Repo: fmt.Sprintf("gitee.com/%s", data.Repository.FullName),
+	}
+
+	switch event {
+	case "Push Hook", "Tag Push Hook":
+		payload.Ref = data.Ref
+		payload.Commit = data.After
+		setChanges(&payload, data.Before, data.Commits, data.TotalCommitsCount > len(data.Commits))
+	default:
+		return nil, errors.Errorf("Invalid event '%s'", event)
+	}
+
+	return &payload, nil
+}
+
+func (Gitee) CloneURL(repo string) string {
+	return fmt.Sprintf("https://%s.git", repo)
+}
+
+func (Gitee) CloneAuth(username string, password string, accessToken string) *container.Auth {
+	return basicAuth(username, password, "oauth2", accessToken)
+}
+
+var GiteeRouter = Router(Gitee{})
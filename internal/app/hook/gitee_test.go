@@ -0,0 +1,123 @@
+package hook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestGiteeVerify(t *testing.T) {
+	const timestamp = "1593503162000"
+
+	mac := hmac.New(sha256.New, []byte(mockSecret))
+	_, _ = mac.Write([]byte(timestamp + "\n" + mockSecret))
+	sign := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name      string
+		secret    string
+		token     string
+		timestamp string
+		want      error
+	}{
+		{"password", mockSecret, mockSecret, "", nil},
+		{"password with timestamp", mockSecret, mockSecret, timestamp, nil},
+		{"sign", mockSecret, sign, timestamp, nil},
+		{"sign with wrong timestamp", mockSecret, sign, "1593503162001", ErrInvalidSignature},
+		{"wrong password", mockSecret, "other_secret", "", ErrInvalidSignature},
+		{"unsigned", mockSecret, "", "", ErrMissingSignature},
+		{"no secret", "", mockSecret, "", ErrNoSecret},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+
+			if tt.token != "" {
+				header.Set("X-Gitee-Token", tt.token)
+			}
+
+			if tt.timestamp != "" {
+				header.Set("X-Gitee-Timestamp", tt.timestamp)
+			}
+
+			if err := (Gitee{}).Verify(tt.secret, header, nil); err != tt.want {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGiteeDelivery(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Gitee-Timestamp", "1593503162000")
+
+	body := readPayload(t, "gitee/tag.json")
+	id := (Gitee{}).Delivery(header, body)
+
+	if id == "" || id != (Gitee{}).Delivery(header, body) {
+		t.Fatalf("Delivery() = %s, want the same id for a retried request", id)
+	}
+
+	if (Gitee{}).Delivery(header, append(body, ' ')) == id {
+		t.Error("Delivery() returns the same id for another body")
+	}
+
+	other := http.Header{}
+	other.Set("X-Gitee-Timestamp", "1593503162001")
+
+	if (Gitee{}).Delivery(other, body) == id {
+		t.Error("Delivery() returns the same id for another timestamp")
+	}
+
+	if got := (Gitee{}).Delivery(http.Header{}, body); got != "" {
+		t.Errorf("Delivery() without timestamp = %s, want empty", got)
+	}
+}
+
+func TestGiteeParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   string
+		file    string
+		want    Payload
+		wantErr bool
+	}{
+		{
+			name:  "tag",
+			event: "Tag Push Hook",
+			file:  "gitee/tag.json",
+			want: Payload{
+				Repo:      "gitee.com/axetroy/hook-example",
+				Ref:       "refs/tags/v1.0.0",
+				Commit:    "01fa2a3efeeeaddf8c9f0fafe94944ba0415d48e",
+				Before:    "0000000000000000000000000000000000000000",
+				Changes:   []string{},
+				Truncated: true,
+			},
+		},
+		{
+			name:    "unknown event",
+			event:   "Issue Hook",
+			file:    "gitee/tag.json",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (Gitee{}).Parse(tt.event, readPayload(t, tt.file))
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err == nil && !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
@@ -1,19 +1,11 @@
 package hook
 
 import (
-	"context"
-	"encoding/base64"
+	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
-	"os"
-	"regexp"
-	"strconv"
-	"strings"
-	"time"
 
 	"github.com/axetroy/hooker/internal/app/container"
-	irisContext "github.com/kataras/iris/v12/context"
 	"github.com/pkg/errors"
 )
 
@@ -30,190 +22,114 @@ type Owner struct {
 	AvatarUrl string `json:"avatar_url"`
 }
 
-type GithubHookPostData struct {
-	Ref        string     `json:"ref"`
-	After      string     `json:"after"`
-	Repository Repository `json:"repository"`
-}
-
-type GithubRouterQuery struct {
-	Port []string `url:"port"` // 端口映射, 格式为 8080:80, 本机端口:容器端口
-	Auth string   `url:"auth"` // 认证方式, basic://username:password 或者 token://xxxxxx
+type GithubPullRequest struct {
+	Number int64 `json:"number"`
+	Merged bool  `json:"merged"`
+	Head   struct {
+		Ref string `json:"ref"`
+		Sha string `json:"sha"`
+	} `json:"head"`
 }
 
-// 解析端口
-func (q GithubRouterQuery) ParsePort() (ports []container.ExposePort, err error) {
-	var (
-		machinePort   uint64
-		containerPort uint64
-	)
-
-	for _, p := range q.Port {
-		arr := strings.Split(p, ":")
-
-		machinePort, err = strconv.ParseUint(arr[0], 0, 0)
-
-		if err != nil {
-			err = errors.WithStack(err)
-			return
-		}
+// Github 的 payload 最多包含 2048 个提交
+const githubMaxCommits = 2048
 
-		containerPort, err = strconv.ParseUint(arr[1], 0, 0)
-
-		if err != nil {
-			err = errors.WithStack(err)
-			return
-		}
-
-		ports = append(ports, container.ExposePort{
-			MachinePort:   machinePort,
-			ContainerPort: containerPort,
-		})
-	}
-
-	return
+type GithubIssue struct {
+	Number      int64           `json:"number"`
+	PullRequest json.RawMessage `json:"pull_request"` // 合并请求的评论才有该字段
 }
 
-// 解析认证方式，用于克隆项目，公开项目不需要设置，私有项目需要设置
-func (q GithubRouterQuery) ParseAuth() (username string, password string, secretKey string, err error) {
-	if q.Auth == "" {
-		return
-	}
-
-	b, err := base64.URLEncoding.DecodeString(q.Auth)
-
-	if err != nil {
-		err = errors.WithStack(err)
-		return
-	}
-
-	reg, err := regexp.CompilePOSIX(`^(basic|token)://(.{3,})$`)
-
-	if err != nil {
-		err = errors.WithStack(err)
-		return
-	}
-
-	matchers := reg.FindAllStringSubmatch(string(b), 1)
-
-	if len(matchers) == 0 {
-		err = errors.New("invalid format of auth")
-		return
-	}
-
-	matcher := matchers[0]
+type GithubComment struct {
+	Body              string `json:"body"`
+	AuthorAssociation string `json:"author_association"`
+	User              struct {
+		Login string `json:"login"`
+	} `json:"user"`
+}
 
-	if len(matcher) != 3 {
-		err = errors.New("invalid format of auth")
-		return
-	}
+type GithubHookPostData struct {
+	Ref         string            `json:"ref"`
+	Before      string            `json:"before"`
+	After       string            `json:"after"`
+	Commits     []Commit          `json:"commits"`
+	Action      string            `json:"action"`
+	Number      int64             `json:"number"`
+	PullRequest GithubPullRequest `json:"pull_request"`
+	Issue       GithubIssue       `json:"issue"`
+	Comment     GithubComment     `json:"comment"`
+	Repository  Repository        `json:"repository"`
+}
 
-	schema := matcher[1]
-	value := matcher[2]
-
-	switch schema {
-	// basic://username:password
-	case "basic":
-		arr := strings.Split(value, ":")
-		username = arr[0]
-		password = strings.Join(arr[1:], ":")
-	// token://the_token_str
-	case "token":
-		secretKey = value
-	}
+type Github struct{}
 
-	return
+// event:
+// ping
+// push
+// pull_request
+// issue_comment
+func (Github) Event(header http.Header) string {
+	return header.Get("X-GitHub-Event")
 }
 
-func GithubRouter(ctx irisContext.Context) {
-	var (
-		err         error
-		data        GithubHookPostData
-		query       GithubRouterQuery
-		ports       []container.ExposePort
-		username    string
-		password    string
-		accessToken string
-		runtime     *container.Runtime
-	)
-
-	defer func() {
-		if err != nil {
-			ctx.StatusCode(http.StatusBadRequest)
-			msg := fmt.Sprintf("%+v", err)
-			_, _ = ctx.WriteString(msg)
-		} else {
-			ctx.StatusCode(http.StatusOK)
-			_, _ = ctx.WriteString("Success!")
-		}
-	}()
-
-	// event:
-	// ping
-	// push
-	event := ctx.GetHeader("X-GitHub-Event")
+func (Github) Delivery(header http.Header, body []byte) string {
+	return header.Get("X-GitHub-Delivery")
+}
 
-	if err = ctx.ReadQuery(&query); err != nil {
-		err = errors.WithStack(err)
-		return
-	}
+func (Github) Verify(secret string, header http.Header, body []byte) error {
+	return VerifyGithubSignature(secret, body, header.Get("X-Hub-Signature-256"), header.Get("X-Hub-Signature"))
+}
 
-	ports, err = query.ParsePort()
+func (Github) Parse(event string, body []byte) (*Payload, error) {
+	var data GithubHookPostData
 
-	if err != nil {
-		err = errors.WithStack(err)
-		return
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, errors.WithStack(err)
 	}
 
-	username, password, accessToken, err = query.ParseAuth()
-
-	if err != nil {
-		err = errors.WithStack(err)
-		return
+	payload := Payload{
+		Repo: fmt.Sprintf("github.com/%s", data.Repository.FullName),
 	}
 
 	switch event {
+	case "ping":
 	case "push":
-		if err = ctx.ReadJSON(&data); err != nil {
-			err = errors.WithStack(err)
-			return
-		}
-
-		name := fmt.Sprintf("github.com/%s", data.Repository.FullName)
-
-		asyncErr := make(chan error)
-
-		c, cancel := context.WithTimeout(context.Background(), time.Minute*30)
-
-		defer cancel()
-
-		runtime, err = container.NewRuntime(name, data.After, ports, os.Stdout)
-
-		if err != nil {
-			err = errors.WithStack(err)
-			return
+		payload.Ref = data.Ref
+		payload.Commit = data.After
+		setChanges(&payload, data.Before, data.Commits, len(data.Commits) >= githubMaxCommits)
+	case "pull_request":
+		payload.PullRequest = data.Number
+
+		switch data.Action {
+		case "opened", "reopened", "synchronize":
+			payload.Ref = fmt.Sprintf("refs/pull/%d/head", data.Number)
+			payload.Commit = data.PullRequest.Head.Sha
+		case "closed":
+			payload.Closed = true
 		}
-
-		err = runtime.Run(c, username, password, accessToken, asyncErr)
-
-		if err != nil {
-			return
-		}
-
-		go func() {
-			e := <-asyncErr
-
-			log.Printf("%+v\n", e)
-		}()
-
-		select {
-		case <-time.After(time.Second * 1):
-			//err = errors.New("Timeout")
-		case <-c.Done():
-			err = errors.WithStack(c.Err())
+	case "issue_comment":
+		// 编辑或者删除评论不会执行命令
+		if data.Action == "created" {
+			payload.Comment = &Comment{
+				Number:      data.Issue.Number,
+				PullRequest: len(data.Issue.PullRequest) > 0 && string(data.Issue.PullRequest) != "null",
+				User:        data.Comment.User.Login,
+				Association: data.Comment.AuthorAssociation,
+				Body:        data.Comment.Body,
+			}
 		}
 	default:
-		err = errors.Errorf("Invalid event '%s'", event)
+		return nil, errors.Errorf("Invalid event '%s'", event)
 	}
 
+	return &payload, nil
+}
+
+func (Github) CloneURL(repo string) string {
+	return fmt.Sprintf("https://%s.git", repo)
 }
+
+func (Github) CloneAuth(username string, password string, accessToken string) *container.Auth {
+	return basicAuth(username, password, "access", accessToken)
+}
+
+var GithubRouter = Router(Github{})
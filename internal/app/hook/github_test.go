@@ -0,0 +1,151 @@
+package hook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestGithubVerify(t *testing.T) {
+	body := readPayload(t, "github/ping.json")
+
+	mac := hmac.New(sha1.New, []byte(mockSecret))
+	_, _ = mac.Write(body)
+	sha1Signature := "sha1=" + hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name   string
+		secret string
+		header map[string]string
+		want   error
+	}{
+		{"sha256", mockSecret, map[string]string{"X-Hub-Signature-256": "sha256=" + signHex(mockSecret, body)}, nil},
+		{"sha1", mockSecret, map[string]string{"X-Hub-Signature": sha1Signature}, nil},
+		{"sha256 first", mockSecret, map[string]string{"X-Hub-Signature-256": "sha256=" + signHex(mockSecret, body), "X-Hub-Signature": "sha1=00"}, nil},
+		{"wrong secret", "other_secret", map[string]string{"X-Hub-Signature-256": "sha256=" + signHex(mockSecret, body)}, ErrInvalidSignature},
+		{"wrong prefix", mockSecret, map[string]string{"X-Hub-Signature-256": signHex(mockSecret, body)}, ErrInvalidSignature},
+		{"not hex", mockSecret, map[string]string{"X-Hub-Signature-256": "sha256=xyz"}, ErrInvalidSignature},
+		{"unsigned", mockSecret, map[string]string{}, ErrMissingSignature},
+		{"no secret", "", map[string]string{"X-Hub-Signature-256": "sha256=" + signHex(mockSecret, body)}, ErrNoSecret},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+
+			for k, v := range tt.header {
+				header.Set(k, v)
+			}
+
+			if err := (Github{}).Verify(tt.secret, header, body); err != tt.want {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGithubParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   string
+		file    string
+		want    Payload
+		wantErr bool
+	}{
+		{
+			name:  "ping",
+			event: "ping",
+			file:  "github/ping.json",
+			want:  Payload{Repo: "github.com/axetroy/hooker-example"},
+		},
+		{
+			name:  "tag",
+			event: "push",
+			file:  "github/tag.json",
+			want: Payload{
+				Repo:      "github.com/axetroy/hooker-example",
+				Ref:       "refs/tags/v1.0.0",
+				Commit:    "01fa2a3efeeeaddf8c9f0fafe94944ba0415d48e",
+				Before:    "0000000000000000000000000000000000000000",
+				Changes:   []string{},
+				Truncated: true,
+			},
+		},
+		{
+			name:  "deletion",
+			event: "push",
+			file:  "github/delete.json",
+			want: Payload{
+				Repo:      "github.com/axetroy/hooker-example",
+				Ref:       "refs/heads/feature",
+				Commit:    "0000000000000000000000000000000000000000",
+				Before:    "01fa2a3efeeeaddf8c9f0fafe94944ba0415d48e",
+				Changes:   []string{},
+				Truncated: true,
+			},
+		},
+		{
+			name:  "pull request",
+			event: "pull_request",
+			file:  "github/pull_request.json",
+			want: Payload{
+				Repo:        "github.com/axetroy/hooker-example",
+				Ref:         "refs/pull/7/head",
+				Commit:      "9c1a4e3f2b7d8a6c5e4f3a2b1c0d9e8f7a6b5c4d",
+				PullRequest: 7,
+			},
+		},
+		{
+			name:  "comment",
+			event: "issue_comment",
+			file:  "github/issue_comment.json",
+			want: Payload{
+				Repo: "github.com/axetroy/hooker-example",
+				Comment: &Comment{
+					Number:      7,
+					PullRequest: true,
+					User:        "axetroy",
+					Association: "OWNER",
+					Body:        "/deploy\r\nplease",
+				},
+			},
+		},
+		{
+			name:    "unknown event",
+			event:   "star",
+			file:    "github/ping.json",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (Github{}).Parse(tt.event, readPayload(t, tt.file))
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err == nil && !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGithubParseClosedPullRequest(t *testing.T) {
+	body := []byte(`{"action": "closed", "number": 7, "pull_request": {"merged": true}, "repository": {"full_name": "axetroy/hooker-example"}}`)
+
+	got, err := (Github{}).Parse("pull_request", body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !got.Closed || got.Commit != "" || got.PullRequest != 7 {
+		t.Errorf("Parse() = %+v, want closed pull request 7 without commit", *got)
+	}
+}
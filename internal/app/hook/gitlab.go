@@ -0,0 +1,149 @@
+package hook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/pkg/errors"
+)
+
+type GitlabProject struct {
+	ID                int64  `json:"id"`
+	Name              string `json:"name"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	WebUrl            string `json:"web_url"`
+	GitHttpUrl        string `json:"git_http_url"`
+}
+
+type GitlabMergeRequest struct {
+	Iid        int64  `json:"iid"`
+	Action     string `json:"action"`
+	OldRev     string `json:"oldrev"`
+	LastCommit struct {
+		Id string `json:"id"`
+	} `json:"last_commit"`
+}
+
+type GitlabHookPostData struct {
+	ObjectKind        string             `json:"object_kind"`
+	ObjectAttributes  GitlabMergeRequest `json:"object_attributes"`
+	Ref               string             `json:"ref"`
+	Before            string             `json:"before"`
+	After             string             `json:"after"`
+	Commits           []Commit           `json:"commits"`
+	TotalCommitsCount int                `json:"total_commits_count"`
+	CheckoutSha       string             `json:"checkout_sha"`
+	Project           GitlabProject      `json:"project"`
+}
+
+// 仓库名称, 例如 gitlab.com/owner/repo, 自建的 Gitlab 则为 gitlab.example.com/owner/repo
+func (d GitlabHookPostData) Name() (string, error) {
+	host := "gitlab.com"
+
+	if d.Project.WebUrl != "" {
+		u, err := url.Parse(d.Project.WebUrl)
+
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		host = u.Host
+	}
+
+	if d.Project.PathWithNamespace == "" {
+		return "", errors.New("invalid project of payload")
+	}
+
+	return fmt.Sprintf("%s/%s", host, d.Project.PathWithNamespace), nil
+}
+
+// 自建的 Gitlab 的域名, 例如 gitlab.example.com, 通过 /v1/hook/gitlab 部署的仓库使用该域名克隆
+var GitlabHost string
+
+var ErrNoGitlabHost = errors.New("the host of self-hosted gitlab is not configured, use with '--gitlab-host gitlab.example.com'")
+
+type Gitlab struct{}
+
+// event:
+// Push Hook
+// Tag Push Hook
+// Merge Request Hook
+func (Gitlab) Event(header http.Header) string {
+	return header.Get("X-Gitlab-Event")
+}
+
+func (Gitlab) Delivery(header http.Header, body []byte) string {
+	return header.Get("X-Gitlab-Event-UUID")
+}
+
+func (Gitlab) Verify(secret string, header http.Header, body []byte) error {
+	return VerifyToken(secret, header.Get("X-Gitlab-Token"))
+}
+
+func (Gitlab) Parse(event string, body []byte) (*Payload, error) {
+	var data GitlabHookPostData
+
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	name, err := data.Name()
+
+	if err != nil {
+		return nil, err
+	}
+
+	payload := Payload{
+		Repo: name,
+	}
+
+	switch event {
+	case "Push Hook", "Tag Push Hook":
+		payload.Ref = data.Ref
+		payload.Commit = data.CheckoutSha
+
+		// 删除分支或者标签时 checkout_sha 为 null, after 为 0000000, 不会部署
+		if payload.Commit == "" {
+			payload.Commit = data.After
+		}
+
+		setChanges(&payload, data.Before, data.Commits, data.TotalCommitsCount > len(data.Commits))
+	case "Merge Request Hook":
+		mr := data.ObjectAttributes
+
+		payload.PullRequest = mr.Iid
+
+		switch mr.Action {
+		case "open", "reopen":
+			payload.Ref = fmt.Sprintf("refs/merge-requests/%d/head", mr.Iid)
+			payload.Commit = mr.LastCommit.Id
+		case "update":
+			// 只有推送了新的提交才会有 oldrev
+			if mr.OldRev != "" {
+				payload.Ref = fmt.Sprintf("refs/merge-requests/%d/head", mr.Iid)
+				payload.Commit = mr.LastCommit.Id
+			}
+		case "close", "merge":
+			payload.Closed = true
+		}
+	default:
+		return nil, errors.Errorf("Invalid event '%s'", event)
+	}
+
+	return &payload, nil
+}
+
+func (Gitlab) CloneURL(repo string) string {
+	return fmt.Sprintf("https://%s.git", repo)
+}
+
+// Gitlab 的 oauth2 token 和 personal access token 都使用 oauth2 作为用户名
+// Deploy Token 则使用 basic://username:token
+func (Gitlab) CloneAuth(username string, password string, accessToken string) *container.Auth {
+	return basicAuth(username, password, "oauth2", accessToken)
+}
+
+var GitlabRouter = Router(Gitlab{})
@@ -0,0 +1,187 @@
+package hook
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGitlabVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		token  string
+		want   error
+	}{
+		{"token", mockSecret, mockSecret, nil},
+		{"wrong token", mockSecret, "other_secret", ErrInvalidSignature},
+		{"unsigned", mockSecret, "", ErrMissingSignature},
+		{"no secret", "", mockSecret, ErrNoSecret},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+
+			if tt.token != "" {
+				header.Set("X-Gitlab-Token", tt.token)
+			}
+
+			if err := (Gitlab{}).Verify(tt.secret, header, nil); err != tt.want {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitlabParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		event   string
+		file    string
+		want    Payload
+		wantErr bool
+	}{
+		{
+			name:  "tag on self-hosted gitlab",
+			event: "Tag Push Hook",
+			file:  "gitlab/tag.json",
+			want: Payload{
+				Repo:      "gitlab.example.com/axetroy/hook-example",
+				Ref:       "refs/tags/v1.0.0",
+				Commit:    "82b3d5ae55f7080f1e6022629cdb57bfae7cccc7",
+				Before:    "0000000000000000000000000000000000000000",
+				Changes:   []string{},
+				Truncated: true,
+			},
+		},
+		{
+			name:  "merge request update",
+			event: "Merge Request Hook",
+			file:  "gitlab/merge_request.json",
+			want: Payload{
+				Repo:        "gitlab.com/axetroy/hook-example",
+				Ref:         "refs/merge-requests/3/head",
+				Commit:      "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+				PullRequest: 3,
+			},
+		},
+		{
+			name:  "branch deletion",
+			event: "Push Hook",
+			file:  "gitlab/delete.json",
+			want: Payload{
+				Repo:      "gitlab.com/axetroy/hook-example",
+				Ref:       "refs/heads/feature",
+				Commit:    "0000000000000000000000000000000000000000",
+				Before:    "01fa2a3efeeeaddf8c9f0fafe94944ba0415d48e",
+				Changes:   []string{},
+				Truncated: true,
+			},
+		},
+		{
+			name:  "tag deletion",
+			event: "Tag Push Hook",
+			file:  "gitlab/delete_tag.json",
+			want: Payload{
+				Repo:      "gitlab.com/axetroy/hook-example",
+				Ref:       "refs/tags/v0.1.0",
+				Commit:    "0000000000000000000000000000000000000000",
+				Before:    "01fa2a3efeeeaddf8c9f0fafe94944ba0415d48e",
+				Changes:   []string{},
+				Truncated: true,
+			},
+		},
+		{
+			name:    "unknown event",
+			event:   "Issue Hook",
+			file:    "gitlab/tag.json",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (Gitlab{}).Parse(tt.event, readPayload(t, tt.file))
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err == nil && !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", *got, tt.want)
+			}
+
+			// 删除分支或者标签的推送不会部署
+			if err == nil && strings.Contains(tt.file, "delete") && !isNullCommit(got.Commit) {
+				t.Errorf("Parse() commit = %s, want null commit for deletion", got.Commit)
+			}
+		})
+	}
+}
+
+func TestGitlabParseInvalidProject(t *testing.T) {
+	if _, err := (Gitlab{}).Parse("Push Hook", []byte(`{"object_kind": "push", "project": {}}`)); err == nil {
+		t.Error("Parse() error = nil, want error for payload without project")
+	}
+}
+
+func TestGitlabRoute(t *testing.T) {
+	defer func(host string) {
+		GitlabHost = host
+	}(GitlabHost)
+
+	tests := []struct {
+		name    string
+		host    string // --gitlab-host
+		route   string
+		payload string // payload 中的仓库
+		want    string
+		err     bool
+	}{
+		{name: "gitlab.com", route: "gitlab.com/axetroy/hook-example", payload: "gitlab.com/axetroy/hook-example", want: "gitlab.com/axetroy/hook-example"},
+		{name: "case insensitive", route: "gitlab.com/Axetroy/hook-example", payload: "gitlab.com/axetroy/hook-example", want: "gitlab.com/axetroy/hook-example"},
+		{name: "another repository", route: "gitlab.com/axetroy/blog", payload: "gitlab.com/axetroy/hook-example", err: true},
+		{name: "host of payload is ignored", route: "gitlab.com/axetroy/hook-example", payload: "evil.example.com/axetroy/hook-example", want: "gitlab.com/axetroy/hook-example"},
+		{name: "self-hosted", host: "gitlab.example.com", payload: "evil.example.com/axetroy/hook-example", want: "gitlab.example.com/axetroy/hook-example"},
+		{name: "self-hosted without host", payload: "gitlab.example.com/axetroy/hook-example", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			GitlabHost = tt.host
+
+			payload := &Payload{Repo: tt.payload}
+
+			err := checkRoute(Gitlab{}, tt.route, payload)
+
+			if tt.err {
+				if err == nil {
+					t.Errorf("checkRoute() repo = %s, want error", payload.Repo)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("checkRoute() error = %v", err)
+			}
+
+			if payload.Repo != tt.want {
+				t.Errorf("repo = %s, want %s", payload.Repo, tt.want)
+			}
+		})
+	}
+
+	// 其他代码托管平台只校验仓库, 保留 payload 中的域名
+	payload := &Payload{Repo: "try.gogs.io/axetroy/hook-example"}
+
+	if err := checkRoute(Gogs{}, "gogs.com/axetroy/hook-example", payload); err != nil || payload.Repo != "try.gogs.io/axetroy/hook-example" {
+		t.Errorf("checkRoute() = %s, %v", payload.Repo, err)
+	}
+
+	if err := checkRoute(Gitea{}, "gitea.com/axetroy/blog", &Payload{Repo: "gitea.com/axetroy/hook-example"}); err == nil {
+		t.Error("checkRoute() error = nil for another repository")
+	}
+}
@@ -0,0 +1,38 @@
+package hook
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/axetroy/hooker/internal/app/container"
+)
+
+type Gogs struct{}
+
+// event:
+// push
+func (Gogs) Event(header http.Header) string {
+	return header.Get("X-Gogs-Event")
+}
+
+func (Gogs) Delivery(header http.Header, body []byte) string {
+	return header.Get("X-Gogs-Delivery")
+}
+
+func (Gogs) Verify(secret string, header http.Header, body []byte) error {
+	return VerifyHexSignature(secret, body, header.Get("X-Gogs-Signature"))
+}
+
+func (Gogs) Parse(event string, body []byte) (*Payload, error) {
+	return parseGiteaPayload("gogs.com", event, body)
+}
+
+func (Gogs) CloneURL(repo string) string {
+	return fmt.Sprintf("https://%s.git", repo)
+}
+
+func (Gogs) CloneAuth(username string, password string, accessToken string) *container.Auth {
+	return basicAuth(username, password, "oauth2", accessToken)
+}
+
+var GogsRouter = Router(Gogs{})
@@ -0,0 +1,82 @@
+package hook
+
+import (
+	"fmt"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/deploy"
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/pkg/errors"
+)
+
+var ErrPreviewPortBase = errors.New("port_base of preview is required to expose ports of preview environments")
+
+// 合并请求的预览环境名称, 例如 pr-1
+func previewEnvironment(number int64) string {
+	return fmt.Sprintf("pr-%d", number)
+}
+
+// 部署合并请求的预览环境, 合并请求关闭或者合并之后清理预览环境
+func deployPreview(project model.Project, trigger string, payload *Payload) (*model.Log, error) {
+	// 清理预览环境不需要端口, 没有配置 port_base 的项目也可以清理
+	if payload.Closed {
+		task, err := projectTask(project, trigger, "", "")
+
+		if err != nil {
+			return nil, err
+		}
+
+		task.Options.Environment = previewEnvironment(payload.PullRequest)
+		task.Options.Ports = nil
+		task.Teardown = true
+
+		return deploy.Enqueue(*task)
+	}
+
+	if payload.Commit == "" {
+		return nil, nil
+	}
+
+	task, err := previewTask(project, trigger, payload.PullRequest, payload.Ref, payload.Commit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return deploy.Enqueue(*task)
+}
+
+// 生成合并请求预览环境的部署任务
+func previewTask(project model.Project, trigger string, number int64, ref string, commit string) (*deploy.Task, error) {
+	task, err := projectTask(project, trigger, ref, commit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	task.Options.Environment = previewEnvironment(number)
+
+	// 预览环境使用单独的端口, 避免与默认环境冲突. compose 的服务发布到 docker 分配的随机端口
+	var ports []container.ExposePort
+
+	if len(task.Options.Ports) > 0 && task.Options.Compose == "" {
+		if project.Preview.PortBase == 0 {
+			return nil, ErrPreviewPortBase
+		}
+
+		port := project.Preview.PortBase + uint64(number)
+
+		if port > 65535 {
+			return nil, errors.Errorf("port %d of preview environment is out of range", port)
+		}
+
+		ports = append(ports, container.ExposePort{
+			MachinePort:   port,
+			ContainerPort: task.Options.Ports[0].ContainerPort,
+		})
+	}
+
+	task.Options.Ports = ports
+
+	return task, nil
+}
@@ -0,0 +1,71 @@
+package hook
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/model"
+)
+
+func TestPreviewTask(t *testing.T) {
+	tests := []struct {
+		name    string
+		project model.Project
+		number  int64
+		want    []container.ExposePort
+		wantErr error
+	}{
+		{
+			name:    "port base",
+			project: model.Project{Ports: []string{"8080:80", "8443:443"}, Preview: model.Preview{Enabled: true, PortBase: 10000}},
+			number:  1,
+			want:    []container.ExposePort{{MachinePort: 10001, ContainerPort: 80}},
+		},
+		{
+			name:    "without port base",
+			project: model.Project{Ports: []string{"8080:80"}, Preview: model.Preview{Enabled: true}},
+			number:  1,
+			wantErr: ErrPreviewPortBase,
+		},
+		{
+			name:    "without ports",
+			project: model.Project{Preview: model.Preview{Enabled: true}},
+			number:  1,
+		},
+		{
+			name:    "compose",
+			project: model.Project{Ports: []string{"8080:80"}, Compose: model.Compose{Enabled: true}, Preview: model.Preview{Enabled: true}},
+			number:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.project.Id = "blog"
+			tt.project.Repo = "github.com/axetroy/blog"
+
+			task, err := previewTask(tt.project, "github", tt.number, "refs/pull/1/head", "01fa2a3e")
+
+			if err != tt.wantErr {
+				t.Fatalf("previewTask() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if task.Options.Environment != "pr-1" {
+				t.Errorf("previewTask() environment = %s, want pr-1", task.Options.Environment)
+			}
+
+			if !reflect.DeepEqual(task.Options.Ports, tt.want) {
+				t.Errorf("previewTask() ports = %v, want %v", task.Options.Ports, tt.want)
+			}
+		})
+	}
+
+	if _, err := previewTask(model.Project{Id: "blog", Repo: "github.com/axetroy/blog", Ports: []string{"8080:80"}, Preview: model.Preview{PortBase: 65535}}, "github", 1, "", ""); err == nil {
+		t.Error("previewTask() with port out of range error = nil")
+	}
+}
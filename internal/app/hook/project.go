@@ -0,0 +1,402 @@
+package hook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/deploy"
+	"github.com/axetroy/hooker/internal/app/forge"
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/store"
+	irisContext "github.com/kataras/iris/v12/context"
+	"github.com/pkg/errors"
+)
+
+var ErrProjectNotFound = errors.New("project not found")
+
+// 项目不存在时用于比较的 token
+var dummyToken = func() string {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}()
+
+// 自动回滚的默认宽限期
+const defaultGracePeriod = time.Minute * 5
+
+// 根据项目 ID 或者项目名称获取项目
+func getProject(id string) (*model.Project, error) {
+	project, err := store.Default.GetProject(id)
+
+	if err == nil {
+		return project, nil
+	} else if errors.Cause(err) != store.ErrNotFound {
+		return nil, err
+	}
+
+	projects, err := store.Default.ListProjects()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range projects {
+		if p.Name == id {
+			project := p
+			return &project, nil
+		}
+	}
+
+	return nil, ErrProjectNotFound
+}
+
+// 根据仓库名获取项目, 例如 github.com/owner/repo, 没有注册的仓库返回 nil
+func findProjectByRepo(repo string) (*model.Project, error) {
+	projects, err := store.Default.ListProjects()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range projects {
+		if name, _ := projectRepo(p); strings.EqualFold(name, repo) {
+			project := p
+			return &project, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// 获取项目的仓库名和克隆地址, 仓库可以是 github.com/owner/repo 或者完整的 URL
+func projectRepo(project model.Project) (name string, cloneURL string) {
+	if !strings.Contains(project.Repo, "://") {
+		name = strings.TrimSuffix(project.Repo, ".git")
+
+		if p, ok := Providers[project.Provider]; ok {
+			cloneURL = p.CloneURL(name)
+		} else {
+			cloneURL = fmt.Sprintf("https://%s.git", name)
+		}
+
+		return
+	}
+
+	cloneURL = project.Repo
+
+	if u, err := url.Parse(project.Repo); err == nil {
+		name = u.Host + strings.TrimSuffix(u.Path, ".git")
+	}
+
+	return
+}
+
+// 根据项目的配置生成部署任务
+func projectTask(project model.Project, trigger string, ref string, commit string) (*deploy.Task, error) {
+	ports, err := ParsePort(project.Ports)
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	name, cloneURL := projectRepo(project)
+
+	health, err := healthCheck(project.Health)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resources, restart, err := supervision(project)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var compose string
+
+	if project.Compose.Enabled {
+		compose = project.Compose.File
+
+		if compose == "" {
+			compose = container.DefaultComposeFile
+		}
+	}
+
+	gracePeriod := defaultGracePeriod
+
+	if project.Releases.GracePeriod != "" {
+		if gracePeriod, err = time.ParseDuration(project.Releases.GracePeriod); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return &deploy.Task{
+		ProjectId:   project.Id,
+		Trigger:     trigger,
+		Report:      projectReport(project),
+		Rollback:    project.Releases.AutoRollback,
+		GracePeriod: gracePeriod,
+		Variables:   project.Variables,
+		Options: container.Options{
+			Project:    project.Id,
+			Repo:       name,
+			URL:        cloneURL,
+			Ref:        ref,
+			Hash:       commit,
+			Ports:      ports,
+			Dockerfile: project.Dockerfile,
+			Health:     health,
+			Proxy:      project.Health.Proxy,
+			Keep:       project.Releases.Keep,
+			Volumes:    volumes(project.Volumes),
+			Networks:   networks(project.Networks),
+			Resources:  resources,
+			Restart:    restart,
+			Compose:    compose,
+		},
+		Auth: projectAuth(project),
+	}, nil
+}
+
+// 克隆项目的仓库的认证信息
+func projectAuth(project model.Project) *container.Auth {
+	if p, ok := Providers[project.Provider]; ok {
+		return p.CloneAuth(project.Username, project.Password, project.AccessToken)
+	}
+
+	return basicAuth(project.Username, project.Password, "oauth2", project.AccessToken)
+}
+
+// 回报部署状态的代码托管平台, 项目没有开启回报时为 nil
+func projectReport(project model.Project) *forge.Target {
+	if !project.Report || !forge.Supported(project.Provider) {
+		return nil
+	}
+
+	target := projectTarget(project)
+
+	return &target
+}
+
+// 根据项目当前的配置恢复任务的认证信息, 用于程序重启之后重新执行的任务.
+// 没有注册的仓库通过 URL 参数部署, 认证信息无法恢复, 只能部署公开的仓库
+func TaskCredentials(task *deploy.Task) error {
+	project, err := store.Default.GetProject(task.ProjectId)
+
+	if err != nil {
+		if errors.Cause(err) == store.ErrNotFound {
+			return nil
+		}
+
+		return err
+	}
+
+	task.Auth = projectAuth(*project)
+	task.Report = projectReport(*project)
+
+	return nil
+}
+
+// 解析项目的资源限制和重启策略
+func supervision(project model.Project) (container.Resources, container.RestartPolicy, error) {
+	resources := container.Resources{
+		CPUs: project.Resources.CPUs,
+		Pids: project.Resources.Pids,
+	}
+
+	restart := container.RestartPolicy{
+		Policy:     project.Restart.Policy,
+		MaxRetries: project.Restart.MaxRetries,
+	}
+
+	var err error
+
+	if resources.Memory, err = container.ParseMemory(project.Resources.Memory); err != nil {
+		return resources, restart, err
+	}
+
+	if project.Restart.Backoff != "" {
+		if restart.Backoff, err = time.ParseDuration(project.Restart.Backoff); err != nil {
+			return resources, restart, errors.WithStack(err)
+		}
+	}
+
+	return resources, restart, nil
+}
+
+// 项目中挂载的卷
+func volumes(list []model.Volume) []container.Volume {
+	result := make([]container.Volume, 0, len(list))
+
+	for _, v := range list {
+		result = append(result, container.Volume{
+			Name:     v.Name,
+			Source:   v.Source,
+			Target:   v.Target,
+			ReadOnly: v.ReadOnly,
+		})
+	}
+
+	return result
+}
+
+// 项目中容器加入的网络
+func networks(list []model.Network) []container.Network {
+	result := make([]container.Network, 0, len(list))
+
+	for _, n := range list {
+		result = append(result, container.Network{
+			Name:    n.Name,
+			Aliases: n.Aliases,
+		})
+	}
+
+	return result
+}
+
+// 解析项目的健康检查配置, 时间为空则使用默认值
+func healthCheck(health model.Health) (container.HealthCheck, error) {
+	check := container.HealthCheck{
+		Type: health.Type,
+		Path: health.Path,
+		Port: health.Port,
+	}
+
+	if health.Timeout != "" {
+		timeout, err := time.ParseDuration(health.Timeout)
+
+		if err != nil {
+			return check, errors.WithStack(err)
+		}
+
+		check.Timeout = timeout
+	}
+
+	if health.Interval != "" {
+		interval, err := time.ParseDuration(health.Interval)
+
+		if err != nil {
+			return check, errors.WithStack(err)
+		}
+
+		check.Interval = interval
+	}
+
+	return check, nil
+}
+
+// 调用代码托管平台 API 需要的信息, 使用项目的 access token
+func projectTarget(project model.Project) forge.Target {
+	name, _ := projectRepo(project)
+
+	return forge.Target{
+		Provider: project.Provider,
+		ApiURL:   project.ApiURL,
+		Repo:     name,
+		Token:    project.AccessToken,
+		URL:      project.URL,
+	}
+}
+
+// 根据项目的配置部署
+func deployProject(project model.Project, trigger string, ref string, commit string) (*model.Log, error) {
+	task, err := projectTask(project, trigger, ref, commit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return deploy.Enqueue(*task)
+}
+
+type ProjectHookPostData struct {
+	Ref    string `json:"ref"`    // 分支或者标签, 例如 refs/heads/master, 为空则部署默认分支
+	Commit string `json:"commit"` // 需要部署的 commit hash, 为空则部署分支的最新提交
+}
+
+// 从请求头中获取触发部署的 token, 支持 X-Hooker-Token 和 Authorization: Bearer xxx
+func projectToken(ctx irisContext.Context) string {
+	if token := ctx.GetHeader("X-Hooker-Token"); token != "" {
+		return token
+	}
+
+	authorization := ctx.GetHeader("Authorization")
+
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimPrefix(authorization, "Bearer ")
+	}
+
+	return ""
+}
+
+// 获取项目并且校验触发部署的 token. 项目不存在或者没有设置 token 时与 token 错误返回同样的错误, 避免通过响应判断项目是否存在
+func authorizeProject(id string, token string) (*model.Project, error) {
+	project, err := getProject(id)
+
+	if err != nil && errors.Cause(err) != ErrProjectNotFound {
+		return nil, err
+	}
+
+	secret := dummyToken
+
+	if project != nil && project.Token != "" {
+		secret = project.Token
+	}
+
+	if err := VerifyToken(secret, token); err != nil {
+		return nil, err
+	}
+
+	if secret == dummyToken {
+		return nil, ErrInvalidSignature
+	}
+
+	return project, nil
+}
+
+// 触发项目的部署, 用于 CI 或者定时任务等
+func ProjectRouter(ctx irisContext.Context) {
+	var (
+		err    error
+		data   ProjectHookPostData
+		record *model.Log
+	)
+
+	defer func() {
+		response(ctx, record, err)
+	}()
+
+	project, err := authorizeProject(ctx.Params().Get("project"), projectToken(ctx))
+
+	if err != nil {
+		return
+	}
+
+	body, err := ioutil.ReadAll(ctx.Request().Body)
+
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+
+	if len(body) > 0 {
+		if err = json.Unmarshal(body, &data); err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+	}
+
+	record, err = deployProject(*project, "project", data.Ref, data.Commit)
+}
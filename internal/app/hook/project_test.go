@@ -0,0 +1,62 @@
+package hook
+
+import (
+	"testing"
+
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/store"
+)
+
+func TestAuthorizeProject(t *testing.T) {
+	db, err := store.NewFileStore(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(s store.Store) {
+		store.Default = s
+	}(store.Default)
+
+	store.Default = db
+
+	for _, p := range []model.Project{
+		{Id: "blog", Name: "my-blog", Repo: "github.com/axetroy/blog", Token: "blog-token"},
+		{Id: "docs", Name: "docs", Repo: "github.com/axetroy/docs"},
+	} {
+		p := p
+
+		if err := db.CreateProject(&p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		id    string
+		token string
+		want  error
+	}{
+		{name: "valid token", id: "blog", token: "blog-token"},
+		{name: "project name", id: "my-blog", token: "blog-token"},
+		{name: "wrong token", id: "blog", token: "other-token", want: ErrInvalidSignature},
+		{name: "unknown project", id: "other", token: "other-token", want: ErrInvalidSignature},
+		{name: "project without token", id: "docs", token: "other-token", want: ErrInvalidSignature},
+		{name: "without token", id: "blog", want: ErrMissingSignature},
+		{name: "unknown project without token", id: "other", want: ErrMissingSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			project, err := authorizeProject(tt.id, tt.token)
+
+			if err != tt.want {
+				t.Fatalf("authorizeProject() error = %v, want %v", err, tt.want)
+			}
+
+			if err == nil && project.Id != "blog" {
+				t.Errorf("authorizeProject() = %s, want blog", project.Id)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package hook
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/deploy"
+	"github.com/axetroy/hooker/internal/app/model"
+	irisContext "github.com/kataras/iris/v12/context"
+	"github.com/pkg/errors"
+)
+
+// 从 Web Hook 中解析出来的部署信息
+type Payload struct {
+	Repo   string // 仓库名称, 例如 github.com/owner/repo
+	Ref    string // 分支或者标签, 例如 refs/heads/master
+	Commit string // 需要部署的 commit hash, 为空则表示该事件不需要部署, 例如 ping
+
+	Before    string   // 推送之前的 commit hash
+	Changes   []string // 推送的提交中改动的文件
+	Truncated bool     // payload 中的提交不完整, 需要通过 git diff 获取改动的文件
+
+	PullRequest int64 // 合并请求的编号, 不是合并请求的事件为 0
+	Closed      bool  // 合并请求已经关闭或者合并, 需要清理预览环境
+
+	Comment *Comment // issue 或者合并请求中新建的评论, 不是评论的事件为 nil
+}
+
+// 代码托管平台, 例如 Github/Gitlab/Gitea/Gogs/Gitee
+type Provider interface {
+	// 从请求头中获取事件类型
+	Event(header http.Header) string
+	// 获取请求 ID, 代码托管平台重试的请求 ID 相同, 没有则返回空
+	Delivery(header http.Header, body []byte) string
+	// 校验请求的签名或者 token
+	Verify(secret string, header http.Header, body []byte) error
+	// 解析事件的 payload
+	Parse(event string, body []byte) (*Payload, error)
+	// 仓库的克隆地址
+	CloneURL(repo string) string
+	// 克隆仓库的认证信息, 公开项目返回 nil
+	CloneAuth(username string, password string, accessToken string) *container.Auth
+}
+
+// 路径中指定了仓库的路由对应的域名
+var routeHosts = map[string]string{
+	"gitlab": "gitlab.com",
+	"gitea":  "gitea.com",
+	"gogs":   "gogs.com",
+	"gitee":  "gitee.com",
+}
+
+var Providers = map[string]Provider{
+	"github": Github{},
+	"gitlab": Gitlab{},
+	"gitea":  Gitea{},
+	"gogs":   Gogs{},
+	"gitee":  Gitee{},
+}
+
+// 所有代码托管平台的 Web Hook 都通过同样的流程进行部署, 每个请求都会保存下来
+func Router(p Provider) irisContext.Handler {
+	return func(ctx irisContext.Context) {
+		var (
+			err    error
+			record *model.Log
+		)
+
+		defer func() {
+			response(ctx, record, err)
+		}()
+
+		header := ctx.Request().Header
+
+		body, err := ioutil.ReadAll(ctx.Request().Body)
+
+		if err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+
+		delivery := model.Delivery{
+			Provider:   providerName(p),
+			DeliveryId: p.Delivery(header, body),
+			Event:      p.Event(header),
+			Header:     header,
+			Query:      ctx.Request().URL.RawQuery,
+			Payload:    string(body),
+			Ip:         ctx.RemoteAddr(),
+		}
+
+		// 路径中指定了仓库的路由, 例如 /v1/hook/gitlab.com/{owner}/{repo}
+		if owner, repo := ctx.Params().Get("owner"), ctx.Params().Get("repo"); owner != "" && repo != "" {
+			delivery.Route = fmt.Sprintf("%s/%s/%s", routeHosts[delivery.Provider], owner, repo)
+		}
+
+		record, err = handle(p, &delivery)
+	}
+}
+
+// 处理 Web Hook 请求, 并且保存请求和处理的结果
+func handle(p Provider, delivery *model.Delivery) (record *model.Log, err error) {
+	defer func() {
+		// 重复的请求保存为新的请求, 请求 ID 已经被原来的请求占用
+		if errors.Cause(err) == ErrDuplicateDelivery {
+			delivery.Id = ""
+			delivery.Status = model.DeliveryDuplicate
+
+			if e := saveDelivery(delivery); e != nil {
+				log.Printf("%+v\n", e)
+			}
+
+			return
+		}
+
+		delivery.Status = model.DeliveryProcessed
+
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+
+		if record != nil {
+			delivery.ProjectId = record.ProjectId
+			delivery.LogId = record.Id
+		}
+
+		if e := saveDelivery(delivery); e != nil {
+			log.Printf("%+v\n", e)
+		}
+	}()
+
+	body := []byte(delivery.Payload)
+
+	// 保存之前隐藏请求中的密钥, 处理时使用原来的值
+	header := http.Header(delivery.Header)
+	query := delivery.Query
+
+	*delivery = MaskDelivery(*delivery)
+
+	payload, err := p.Parse(delivery.Event, body)
+
+	if err != nil {
+		return
+	}
+
+	if err = checkRoute(p, delivery.Route, payload); err != nil {
+		return
+	}
+
+	delivery.Repo = payload.Repo
+
+	// 已注册的项目使用项目的配置, 否则使用 URL 参数
+	project, err := findProjectByRepo(payload.Repo)
+
+	if err != nil {
+		return
+	}
+
+	secret := Secrets.Lookup(payload.Repo)
+
+	if project != nil && project.Secret != "" {
+		secret = project.Secret
+	}
+
+	// 校验签名, 未通过校验的请求不允许部署. 重新执行的请求中的密钥已经被隐藏, 使用原来的请求的校验结果
+	if delivery.ReplayOf == "" || !delivery.Verified {
+		if err = p.Verify(secret, header, body); err != nil {
+			return
+		}
+	}
+
+	delivery.Verified = true
+
+	// 代码托管平台重试的请求不再重复部署
+	if err = checkDuplicate(delivery); err != nil {
+		return
+	}
+
+	// 评论中的命令只对已注册并且开启了评论命令的项目生效
+	if payload.Comment != nil {
+		if project != nil && project.Commands.Enabled {
+			record, err = runCommand(*project, providerName(p), payload.Comment)
+		}
+
+		return
+	}
+
+	// 合并请求只会部署已注册并且开启了预览环境的项目
+	if payload.PullRequest > 0 {
+		if project != nil && project.Preview.Enabled {
+			record, err = deployPreview(*project, providerName(p), payload)
+		}
+
+		return
+	}
+
+	// 删除分支或者标签时不需要部署
+	if payload.Commit == "" || isNullCommit(payload.Commit) {
+		return
+	}
+
+	if project != nil {
+		var task *deploy.Task
+
+		if task, err = projectTask(*project, providerName(p), payload.Ref, payload.Commit); err != nil {
+			return
+		}
+
+		if ok, reason := matchRef(*project, payload.Ref); !ok {
+			record, err = deploy.Skip(*task, reason)
+			return
+		}
+
+		if ok, reason := matchPaths(*project, payload, task); !ok {
+			record, err = deploy.Skip(*task, reason)
+			return
+		}
+
+		task.Before = payload.Before
+
+		record, err = deploy.Enqueue(*task)
+		return
+	}
+
+	values, err := url.ParseQuery(query)
+
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+
+	auth := values.Get("auth")
+
+	// 重新执行的请求中的认证信息已经被隐藏
+	if auth == deliveryMask {
+		auth = ""
+	}
+
+	record, err = deployWithQuery(p, payload, RouterQuery{
+		Port:  values["port"],
+		Auth:  auth,
+		Proxy: values.Get("proxy") == "true",
+	})
+
+	return
+}
+
+// 去掉仓库名称中的域名, 例如 gitlab.com/owner/repo -> owner/repo
+func repoPath(repo string) string {
+	if i := strings.Index(repo, "/"); i >= 0 {
+		return repo[i+1:]
+	}
+
+	return repo
+}
+
+// 路径中指定了仓库时, payload 中的仓库必须与之相同, 避免通过其他仓库的 payload 部署.
+// Gitlab 的域名不使用 payload 中的 web_url, 由路由或者 --gitlab-host 决定
+func checkRoute(p Provider, route string, payload *Payload) error {
+	if route != "" && !strings.EqualFold(repoPath(route), repoPath(payload.Repo)) {
+		return errors.Errorf("repository '%s' of payload does not match '%s'", payload.Repo, route)
+	}
+
+	if _, ok := p.(Gitlab); ok {
+		host := GitlabHost
+
+		if route != "" {
+			host = strings.SplitN(route, "/", 2)[0]
+		}
+
+		if host == "" {
+			return ErrNoGitlabHost
+		}
+
+		payload.Repo = host + "/" + repoPath(payload.Repo)
+	}
+
+	return nil
+}
+
+// 使用用户名密码或者 access token 作为 basic auth
+func basicAuth(username string, password string, tokenUsername string, accessToken string) *container.Auth {
+	if password != "" {
+		return &container.Auth{Username: username, Password: password}
+	} else if accessToken != "" {
+		return &container.Auth{Username: tokenUsername, Password: accessToken}
+	}
+
+	return nil
+}
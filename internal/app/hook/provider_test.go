@@ -0,0 +1,186 @@
+package hook
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mock 目录中的请求使用的密钥
+const mockSecret = "your_secret"
+
+// 读取 mock 目录中的 .http 请求, 返回请求头和请求体.
+// 请求体与 HTTP 客户端发送的相同, 到响应记录 (<>) 或者分隔符 (###) 为止, 并且去掉末尾的空白
+func readRequest(t *testing.T, file string) (http.Header, []byte) {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		header  = http.Header{}
+		body    []string
+		inBody  bool
+		scanner = bufio.NewScanner(bytes.NewReader(b))
+	)
+
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	// 第一行为请求行
+	scanner.Scan()
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if !inBody {
+			if line == "" {
+				inBody = true
+				continue
+			}
+
+			kv := strings.SplitN(line, ":", 2)
+			header.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+
+			continue
+		}
+
+		if strings.HasPrefix(line, "<>") || strings.HasPrefix(line, "###") {
+			break
+		}
+
+		body = append(body, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return header, []byte(strings.TrimRight(strings.Join(body, "\n"), " \t\n"))
+}
+
+// 读取 testdata 中的 payload
+func readPayload(t *testing.T, file string) []byte {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(filepath.Join("testdata", file))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// 生成十六进制的 HMAC-SHA256 签名
+func signHex(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestMockRequests(t *testing.T) {
+	tests := []struct {
+		file     string
+		provider Provider
+		repo     string
+		ref      string
+		commit   string
+	}{
+		{"github/axetroy/blog/hook.http", Github{}, "github.com/axetroy/blog", "refs/heads/master", "1051f690cf55b0fb71bc23fb913ca0c324f98e14"},
+		{"github/axetroy/hook-example/hook.http", Github{}, "github.com/axetroy/hooker-example", "refs/heads/master", "01fa2a3efeeeaddf8c9f0fafe94944ba0415d48e"},
+		{"gitlab/axetroy/hook-example/hook.http", Gitlab{}, "gitlab.com/axetroy/hook-example", "refs/heads/master", "01fa2a3efeeeaddf8c9f0fafe94944ba0415d48e"},
+		{"gitea/axetroy/hook-example/hook.http", Gitea{}, "gitea.com/axetroy/hook-example", "refs/heads/master", "01fa2a3efeeeaddf8c9f0fafe94944ba0415d48e"},
+		{"gogs/axetroy/hook-example/hook.http", Gogs{}, "try.gogs.io/axetroy/hook-example", "refs/heads/master", "01fa2a3efeeeaddf8c9f0fafe94944ba0415d48e"},
+		{"gitee/axetroy/hook-example/hook.http", Gitee{}, "gitee.com/axetroy/hook-example", "refs/heads/master", "01fa2a3efeeeaddf8c9f0fafe94944ba0415d48e"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			header, body := readRequest(t, filepath.Join("..", "..", "..", "mock", "hook", tt.file))
+
+			if err := tt.provider.Verify(mockSecret, header, body); err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+
+			if err := tt.provider.Verify("other_secret", header, body); err != ErrInvalidSignature {
+				t.Errorf("Verify() with wrong secret error = %v, want %v", err, ErrInvalidSignature)
+			}
+
+			payload, err := tt.provider.Parse(tt.provider.Event(header), body)
+
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			if payload.Repo != tt.repo || payload.Ref != tt.ref || payload.Commit != tt.commit {
+				t.Errorf("Parse() = %s %s %s, want %s %s %s", payload.Repo, payload.Ref, payload.Commit, tt.repo, tt.ref, tt.commit)
+			}
+
+			if len(payload.Changes) == 0 || payload.Truncated {
+				t.Errorf("Parse() changes = %v, truncated = %v", payload.Changes, payload.Truncated)
+			}
+		})
+	}
+}
+
+func TestCloneURL(t *testing.T) {
+	tests := []struct {
+		provider Provider
+		repo     string
+		want     string
+	}{
+		{Github{}, "github.com/axetroy/hooker-example", "https://github.com/axetroy/hooker-example.git"},
+		{Gitlab{}, "gitlab.example.com/group/sub/repo", "https://gitlab.example.com/group/sub/repo.git"},
+		{Gitea{}, "gitea.com/axetroy/hook-example", "https://gitea.com/axetroy/hook-example.git"},
+		{Gogs{}, "try.gogs.io/axetroy/hook-example", "https://try.gogs.io/axetroy/hook-example.git"},
+		{Gitee{}, "gitee.com/axetroy/hook-example", "https://gitee.com/axetroy/hook-example.git"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.provider.CloneURL(tt.repo); got != tt.want {
+			t.Errorf("%s.CloneURL(%s) = %s, want %s", providerName(tt.provider), tt.repo, got, tt.want)
+		}
+	}
+}
+
+func TestCloneAuth(t *testing.T) {
+	tests := []struct {
+		provider    Provider
+		username    string
+		password    string
+		accessToken string
+		want        []string
+	}{
+		{Github{}, "", "", "", nil},
+		{Github{}, "axetroy", "password", "token", []string{"axetroy", "password"}},
+		{Github{}, "", "", "token", []string{"access", "token"}},
+		{Gitlab{}, "", "", "token", []string{"oauth2", "token"}},
+		{Gitea{}, "", "", "token", []string{"oauth2", "token"}},
+	}
+
+	for _, tt := range tests {
+		auth := tt.provider.CloneAuth(tt.username, tt.password, tt.accessToken)
+
+		var got []string
+
+		if auth != nil {
+			got = []string{auth.Username, auth.Password}
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s.CloneAuth() = %v, want %v", providerName(tt.provider), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package hook
+
+import (
+	"encoding/base64"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/pkg/errors"
+)
+
+type RouterQuery struct {
+	Port  []string `url:"port"`  // 端口映射, 格式为 8080:80, 本机端口:容器端口
+	Auth  string   `url:"auth"`  // 认证方式, basic://username:password 或者 token://xxxxxx
+	Proxy bool     `url:"proxy"` // 本机端口由 hooker 转发, 切换容器时不中断服务
+}
+
+// 解析端口
+func (q RouterQuery) ParsePort() ([]container.ExposePort, error) {
+	return ParsePort(q.Port)
+}
+
+// 解析端口映射, 格式为 8080:80, 本机端口:容器端口
+func ParsePort(list []string) (ports []container.ExposePort, err error) {
+	var (
+		machinePort   uint64
+		containerPort uint64
+	)
+
+	for _, p := range list {
+		arr := strings.Split(p, ":")
+
+		if len(arr) != 2 {
+			err = errors.Errorf("invalid format of port '%s'", p)
+			return
+		}
+
+		machinePort, err = strconv.ParseUint(arr[0], 0, 0)
+
+		if err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+
+		containerPort, err = strconv.ParseUint(arr[1], 0, 0)
+
+		if err != nil {
+			err = errors.WithStack(err)
+			return
+		}
+
+		ports = append(ports, container.ExposePort{
+			MachinePort:   machinePort,
+			ContainerPort: containerPort,
+		})
+	}
+
+	return
+}
+
+// 解析认证方式，用于克隆项目，公开项目不需要设置，私有项目需要设置
+func (q RouterQuery) ParseAuth() (username string, password string, secretKey string, err error) {
+	if q.Auth == "" {
+		return
+	}
+
+	b, err := base64.URLEncoding.DecodeString(q.Auth)
+
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+
+	reg, err := regexp.CompilePOSIX(`^(basic|token)://(.{3,})$`)
+
+	if err != nil {
+		err = errors.WithStack(err)
+		return
+	}
+
+	matchers := reg.FindAllStringSubmatch(string(b), 1)
+
+	if len(matchers) == 0 {
+		err = errors.New("invalid format of auth")
+		return
+	}
+
+	matcher := matchers[0]
+
+	if len(matcher) != 3 {
+		err = errors.New("invalid format of auth")
+		return
+	}
+
+	schema := matcher[1]
+	value := matcher[2]
+
+	switch schema {
+	// basic://username:password
+	case "basic":
+		arr := strings.Split(value, ":")
+		username = arr[0]
+		password = strings.Join(arr[1:], ":")
+	// token://the_token_str
+	case "token":
+		secretKey = value
+	}
+
+	return
+}
@@ -0,0 +1,467 @@
+package hook
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/deploy"
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/store"
+	"github.com/pkg/errors"
+)
+
+// 期望的状态与实际的容器之间的差异
+const (
+	DriftMissing   = "missing"   // 期望运行的容器不存在, 例如主机重启之后容器被删除
+	DriftStopped   = "stopped"   // 容器没有在运行并且没有在等待重启, 例如被手动停止
+	DriftUnhealthy = "unhealthy" // 容器多次崩溃之后不再重启, 需要新的部署
+	DriftOutdated  = "outdated"  // 运行的版本与期望的版本不同
+	DriftOrphan    = "orphan"    // 不属于任何期望运行的环境的容器, 例如项目已经被删除
+)
+
+// 对差异的处理
+const (
+	ActionRedeploy = "redeploy" // 重新部署期望的版本
+	ActionRemove   = "remove"   // 删除容器
+)
+
+var (
+	// 对比的间隔, 为 0 则不自动对比
+	ReconcileInterval = time.Minute
+
+	// 重新部署之后再次重新部署的最短间隔, 避免部署一直失败时不停地重试
+	reconcileRetry = 10 * time.Minute
+)
+
+// 期望的状态与实际的容器之间的差异
+type Drift struct {
+	Project     string    `json:"project"`     // 项目 ID
+	Environment string    `json:"environment"` // 环境名称, 为空则为默认环境
+	Kind        string    `json:"kind"`        // 差异的类型, missing/stopped/unhealthy/outdated/orphan
+	Container   string    `json:"container"`   // 容器 ID, 容器不存在时为空
+	Name        string    `json:"name"`        // 容器名称
+	State       string    `json:"state"`       // 容器状态, 例如 exited
+	Commit      string    `json:"commit"`      // 期望运行的 commit hash
+	Actual      string    `json:"actual"`      // 容器运行的 commit hash
+	Action      string    `json:"action"`      // 处理方式, 为空则只报告不处理
+	Deployment  string    `json:"deployment"`  // 重新部署的部署 ID
+	Error       string    `json:"error"`       // 处理失败的原因
+	DetectedAt  time.Time `json:"detected_at"` // 发现的时间
+}
+
+// 最近一次对比的结果
+type Reconciliation struct {
+	Drifts    []Drift   `json:"drifts"`     // 发现的差异
+	CheckedAt time.Time `json:"checked_at"` // 对比的时间, 为空则还没有对比过
+}
+
+type reconciler struct {
+	sync.Mutex
+	running  sync.Mutex           // 同时只执行一次对比
+	last     Reconciliation       // 最近一次对比的结果
+	attempts map[string]time.Time // 项目 ID 和环境 -> 上一次重新部署的时间
+}
+
+var reconciliation = &reconciler{
+	last:     Reconciliation{Drifts: []Drift{}},
+	attempts: map[string]time.Time{},
+}
+
+// 最近一次对比的结果
+func LastReconciliation() Reconciliation {
+	reconciliation.Lock()
+	defer reconciliation.Unlock()
+
+	return reconciliation.last
+}
+
+// 对比每个环境期望运行的版本和实际的容器, 重新部署丢失或者停止的容器, 删除孤立的容器
+func Reconcile(ctx context.Context) (*Reconciliation, error) {
+	r := reconciliation
+
+	r.running.Lock()
+	defer r.running.Unlock()
+
+	workloads, err := container.ListWorkloads(ctx, "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	desired, err := store.Default.ListDesired()
+
+	if err != nil {
+		return nil, err
+	}
+
+	projects := map[string]*model.Project{}
+
+	// 获取项目, 项目已经被删除时为 nil
+	getProject := func(id string) (*model.Project, error) {
+		if p, ok := projects[id]; ok {
+			return p, nil
+		}
+
+		p, err := store.Default.GetProject(id)
+
+		if err != nil && errors.Cause(err) != store.ErrNotFound {
+			return nil, err
+		}
+
+		projects[id] = p
+
+		return p, nil
+	}
+
+	// 按照项目和环境分组
+	groups := map[string][]container.Workload{}
+
+	for _, w := range workloads {
+		key := w.Project + "/" + w.Environment
+		groups[key] = append(groups[key], w)
+	}
+
+	result := Reconciliation{Drifts: []Drift{}, CheckedAt: time.Now()}
+	handled := map[string]bool{}
+	deleted := map[string]bool{} // 项目已经通过接口删除的环境
+
+	for _, d := range desired {
+		key := d.ProjectId + "/" + d.Environment
+
+		project, err := getProject(d.ProjectId)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// 项目已经通过接口删除, 容器作为孤立的容器删除, 全部删除之后不再记录.
+		// 没有标记删除的记录, 例如旧版本记录的通过 URL 参数部署的仓库, 不处理容器
+		if project == nil || d.Deleted {
+			deleted[key] = project == nil && d.Deleted
+
+			if !deleted[key] || len(groups[key]) == 0 {
+				if err := store.Default.DeleteDesired(d.ProjectId, d.Environment); err != nil {
+					log.Printf("%+v\n", err)
+				}
+			}
+
+			continue
+		}
+
+		handled[key] = true
+
+		// 正在部署的环境由部署处理
+		if deploy.Busy(d.ProjectId, d.Environment) {
+			continue
+		}
+
+		result.Drifts = append(result.Drifts, r.check(ctx, *project, d, groups[key])...)
+	}
+
+	for key, list := range groups {
+		if handled[key] {
+			continue
+		}
+
+		project, err := getProject(list[0].Project)
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case project != nil:
+			if deploy.Busy(project.Id, list[0].Environment) {
+				continue
+			}
+
+			for _, w := range adopt(list) {
+				result.Drifts = append(result.Drifts, remove(ctx, w))
+			}
+		case deleted[key]:
+			removed := true
+
+			for _, w := range list {
+				drift := remove(ctx, w)
+				removed = removed && drift.Error == ""
+				result.Drifts = append(result.Drifts, drift)
+			}
+
+			if removed {
+				if err := store.Default.DeleteDesired(list[0].Project, list[0].Environment); err != nil {
+					log.Printf("%+v\n", err)
+				}
+			}
+		default:
+			// 没有注册的项目, 例如通过 URL 参数部署的仓库, 只报告
+			for _, w := range list {
+				result.Drifts = append(result.Drifts, orphan(w))
+			}
+		}
+	}
+
+	r.Lock()
+	r.last = result
+
+	// 没有差异的环境不再需要等待重试
+	for key := range r.attempts {
+		drifted := false
+
+		for _, d := range result.Drifts {
+			if d.Project+"/"+d.Environment == key {
+				drifted = true
+			}
+		}
+
+		if !drifted {
+			delete(r.attempts, key)
+		}
+	}
+
+	r.Unlock()
+
+	return &result, nil
+}
+
+// 对比环境期望运行的版本和环境中的容器
+func (r *reconciler) check(ctx context.Context, project model.Project, d model.Desired, list []container.Workload) []Drift {
+	var (
+		drifts   []Drift
+		current  *container.Workload
+		services []container.Workload
+	)
+
+	name := container.ContainerName(d.ProjectId, d.Environment)
+
+	for i, w := range list {
+		if w.Name == name {
+			current = &list[i]
+			continue
+		}
+
+		// compose 部署的服务
+		if w.Service != "" {
+			services = append(services, w)
+			continue
+		}
+
+		// 例如部署中断之后留下的新容器
+		drifts = append(drifts, remove(ctx, w))
+	}
+
+	drift := Drift{
+		Project:     d.ProjectId,
+		Environment: d.Environment,
+		Name:        name,
+		Commit:      d.Commit,
+		DetectedAt:  time.Now(),
+	}
+
+	// compose 部署的环境, 有服务停止时重新部署, 配置没有变化的服务保持运行
+	if current == nil && len(services) > 0 {
+		for _, s := range services {
+			if s.State == "running" || s.State == "restarting" || container.Completed(ctx, s.Id) {
+				continue
+			}
+
+			drift.Kind = DriftStopped
+			drift.Container = s.Id
+			drift.Name = s.Name
+			drift.State = s.State
+			drift.Actual = s.Commit
+
+			return append(drifts, r.redeploy(ctx, project, d, drift))
+		}
+
+		return drifts
+	}
+
+	if current != nil {
+		drift.Container = current.Id
+		drift.State = current.State
+		drift.Actual = current.Commit
+	}
+
+	switch {
+	case current == nil:
+		drift.Kind = DriftMissing
+	case current.State == "running":
+		if current.Commit == d.Commit {
+			return drifts
+		}
+
+		drift.Kind = DriftOutdated
+
+		return append(drifts, drift)
+	case container.Supervised(current.Id):
+		// 正在等待重启
+		return drifts
+	case unhealthy(d):
+		drift.Kind = DriftUnhealthy
+
+		return append(drifts, drift)
+	default:
+		drift.Kind = DriftStopped
+	}
+
+	return append(drifts, r.redeploy(ctx, project, d, drift))
+}
+
+// 重新部署环境期望运行的版本, 保留了镜像时不需要重新构建
+func (r *reconciler) redeploy(ctx context.Context, project model.Project, d model.Desired, drift Drift) Drift {
+	key := d.ProjectId + "/" + d.Environment
+
+	r.Lock()
+	last, retrying := r.attempts[key]
+	r.Unlock()
+
+	if retrying && time.Since(last) < reconcileRetry {
+		drift.Error = "redeployed at " + last.Format(time.RFC3339) + ", waiting to retry"
+		return drift
+	}
+
+	drift.Action = ActionRedeploy
+
+	task, err := environmentTask(project, "reconcile", d.Environment, d.Ref, d.Commit)
+
+	if err != nil {
+		drift.Error = err.Error()
+		return drift
+	}
+
+	if release, err := container.FindRelease(ctx, d.ProjectId, d.Environment, d.Commit); err == nil {
+		task.Options.Image = release.Image
+	}
+
+	record, err := deploy.Enqueue(*task)
+
+	if err != nil {
+		drift.Error = err.Error()
+		return drift
+	}
+
+	log.Printf("Redeploy '%s' of project '%s' by deployment '%s', %s\n", d.Commit, d.ProjectId, record.Id, drift.Kind)
+
+	drift.Deployment = record.Id
+
+	r.Lock()
+	r.attempts[key] = time.Now()
+	r.Unlock()
+
+	return drift
+}
+
+// 期望运行的部署是否已经被标记为不健康
+func unhealthy(d model.Desired) bool {
+	record, err := store.Default.GetLog(d.ProjectId, d.Deployment)
+
+	return err == nil && record.Unhealthy
+}
+
+// 没有记录期望版本的环境, 例如升级之前部署的容器, 把正在运行的容器作为期望的版本, 返回其他的容器.
+// compose 部署的服务有一个在运行时接管所有服务
+func adopt(list []container.Workload) []container.Workload {
+	for i, w := range list {
+		if w.State != "running" || (w.Service == "" && w.Name != container.ContainerName(w.Project, w.Environment)) {
+			continue
+		}
+
+		desired := model.Desired{
+			ProjectId:   w.Project,
+			Environment: w.Environment,
+			Deployment:  w.Deployment,
+			Commit:      w.Commit,
+		}
+
+		if record, err := store.Default.GetLog(w.Project, w.Deployment); err == nil {
+			desired.Ref = record.Ref
+		}
+
+		if err := store.Default.PutDesired(&desired); err != nil {
+			log.Printf("%+v\n", err)
+			return nil
+		}
+
+		log.Printf("Adopt container '%s' of project '%s'\n", w.Name, w.Project)
+
+		if w.Service != "" {
+			return nil
+		}
+
+		rest := make([]container.Workload, 0, len(list)-1)
+		rest = append(rest, list[:i]...)
+
+		return append(rest, list[i+1:]...)
+	}
+
+	return list
+}
+
+// 不属于任何期望运行的环境的容器
+func orphan(w container.Workload) Drift {
+	return Drift{
+		Project:     w.Project,
+		Environment: w.Environment,
+		Kind:        DriftOrphan,
+		Container:   w.Id,
+		Name:        w.Name,
+		State:       w.State,
+		Actual:      w.Commit,
+		DetectedAt:  time.Now(),
+	}
+}
+
+// 删除孤立的容器
+func remove(ctx context.Context, w container.Workload) Drift {
+	drift := orphan(w)
+	drift.Action = ActionRemove
+
+	log.Printf("Removing orphan container '%s' of project '%s'\n", w.Name, w.Project)
+
+	if err := container.RemoveWorkload(ctx, w); err != nil {
+		drift.Error = err.Error()
+	}
+
+	return drift
+}
+
+// 项目被删除之后标记它的环境, 下一次对比时删除环境中的容器
+func ForgetProject(projectId string) error {
+	list, err := store.Default.ListDesired()
+
+	if err != nil {
+		return err
+	}
+
+	for _, d := range list {
+		if d.ProjectId != projectId {
+			continue
+		}
+
+		d.Deleted = true
+
+		if err := store.Default.PutDesired(&d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 定时对比期望的状态和实际的容器
+func StartReconcile() {
+	if ReconcileInterval <= 0 {
+		return
+	}
+
+	go func() {
+		for range time.Tick(ReconcileInterval) {
+			if _, err := Reconcile(context.Background()); err != nil {
+				log.Printf("%+v\n", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,54 @@
+package hook
+
+import (
+	"testing"
+
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/store"
+)
+
+func TestForgetProject(t *testing.T) {
+	db, err := store.NewFileStore(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(s store.Store) {
+		store.Default = s
+	}(store.Default)
+
+	store.Default = db
+
+	for _, d := range []model.Desired{
+		{ProjectId: "blog", Commit: "abc"},
+		{ProjectId: "blog", Environment: "pr-1", Commit: "def"},
+		{ProjectId: "docs", Commit: "123"},
+	} {
+		d := d
+
+		if err := db.PutDesired(&d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ForgetProject("blog"); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := db.ListDesired()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 3 {
+		t.Fatalf("ListDesired() = %d, want 3", len(list))
+	}
+
+	for _, d := range list {
+		if want := d.ProjectId == "blog"; d.Deleted != want {
+			t.Errorf("desired %s/%s deleted = %v, want %v", d.ProjectId, d.Environment, d.Deleted, want)
+		}
+	}
+}
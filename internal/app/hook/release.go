@@ -0,0 +1,49 @@
+package hook
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/deploy"
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidEnvironment = errors.New("invalid environment")
+
+// 生成部署到指定环境的任务, 环境为空则为默认环境, 合并请求的预览环境为 pr-1
+func environmentTask(project model.Project, trigger string, environment string, ref string, commit string) (*deploy.Task, error) {
+	if environment == "" {
+		return projectTask(project, trigger, ref, commit)
+	}
+
+	number, err := strconv.ParseInt(strings.TrimPrefix(environment, "pr-"), 10, 64)
+
+	if !strings.HasPrefix(environment, "pr-") || err != nil || number <= 0 {
+		return nil, errors.Wrapf(ErrInvalidEnvironment, "environment '%s'", environment)
+	}
+
+	return previewTask(project, trigger, number, ref, commit)
+}
+
+// 部署保留的版本, 使用已经构建的镜像, 不需要克隆和构建
+func DeployRelease(project model.Project, environment string, commit string) (*model.Log, error) {
+	task, err := environmentTask(project, "release", environment, "", "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := container.FindRelease(context.Background(), project.Id, environment, commit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	task.Options.Image = release.Image
+	task.Options.Hash = release.Commit
+
+	return deploy.Enqueue(*task)
+}
@@ -0,0 +1,173 @@
+package hook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrMissingSignature = errors.New("missing signature")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrNoSecret         = errors.New("no secret configured for the repository")
+)
+
+// 钩子的密钥配置, 每个仓库可以单独配置密钥, 没有单独配置的仓库使用全局密钥
+type SecretStore struct {
+	sync.RWMutex
+	global string
+	repos  map[string]string // key 为仓库名, 例如 github.com/axetroy/blog
+}
+
+var Secrets = &SecretStore{repos: map[string]string{}}
+
+// 设置全局密钥
+func (s *SecretStore) SetGlobal(secret string) {
+	s.Lock()
+	defer s.Unlock()
+	s.global = secret
+}
+
+// 设置某个仓库的密钥
+func (s *SecretStore) Set(repo string, secret string) {
+	s.Lock()
+	defer s.Unlock()
+	s.repos[strings.ToLower(repo)] = secret
+}
+
+// 获取仓库对应的密钥, 优先使用仓库的密钥
+func (s *SecretStore) Lookup(repo string) string {
+	s.RLock()
+	defer s.RUnlock()
+
+	if secret, ok := s.repos[strings.ToLower(repo)]; ok {
+		return secret
+	}
+
+	return s.global
+}
+
+// 从 JSON 文件中加载仓库的密钥, 格式为 {"github.com/owner/repo": "secret"}
+func (s *SecretStore) LoadFile(filepath string) error {
+	b, err := ioutil.ReadFile(filepath)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var repos map[string]string
+
+	if err := json.Unmarshal(b, &repos); err != nil {
+		return errors.WithStack(err)
+	}
+
+	for repo, secret := range repos {
+		s.Set(repo, secret)
+	}
+
+	return nil
+}
+
+// 校验 Github 的签名, 优先使用 X-Hub-Signature-256, 兼容旧的 X-Hub-Signature
+func VerifyGithubSignature(secret string, body []byte, signature256 string, signature1 string) error {
+	if secret == "" {
+		return ErrNoSecret
+	}
+
+	if signature256 != "" {
+		return verifyHMAC(sha256.New, "sha256=", secret, body, signature256)
+	}
+
+	if signature1 != "" {
+		return verifyHMAC(sha1.New, "sha1=", secret, body, signature1)
+	}
+
+	return ErrMissingSignature
+}
+
+// 校验十六进制的 HMAC-SHA256 签名, 例如 Gitea 的 X-Gitea-Signature 和 Gogs 的 X-Gogs-Signature
+func VerifyHexSignature(secret string, body []byte, signature string) error {
+	if secret == "" {
+		return ErrNoSecret
+	}
+
+	if signature == "" {
+		return ErrMissingSignature
+	}
+
+	return verifyHMAC(sha256.New, "", secret, body, signature)
+}
+
+// 校验 Gitee 的签名, 签名为 base64(hmac_sha256(timestamp + "\n" + secret))
+func VerifyGiteeSignature(secret string, timestamp string, signature string) error {
+	if secret == "" {
+		return ErrNoSecret
+	}
+
+	if signature == "" {
+		return ErrMissingSignature
+	}
+
+	expect, err := base64.StdEncoding.DecodeString(signature)
+
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(timestamp + "\n" + secret))
+
+	if !hmac.Equal(mac.Sum(nil), expect) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// 校验请求头中携带的明文 token, 例如 Gitlab 的 X-Gitlab-Token
+func VerifyToken(secret string, token string) error {
+	if secret == "" {
+		return ErrNoSecret
+	}
+
+	if token == "" {
+		return ErrMissingSignature
+	}
+
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(token)) != 1 {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+func verifyHMAC(h func() hash.Hash, prefix string, secret string, body []byte, signature string) error {
+	if !strings.HasPrefix(signature, prefix) {
+		return ErrInvalidSignature
+	}
+
+	expect, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(h, []byte(secret))
+	_, _ = mac.Write(body)
+
+	if !hmac.Equal(mac.Sum(nil), expect) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package hook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"testing"
+)
+
+func TestVerifyGithubSignature(t *testing.T) {
+	body := []byte(`{"zen":"Keep it logically awesome."}`)
+
+	mac := hmac.New(sha1.New, []byte(mockSecret))
+	_, _ = mac.Write(body)
+	sha1Signature := "sha1=" + hex.EncodeToString(mac.Sum(nil))
+	sha256Signature := "sha256=" + signHex(mockSecret, body)
+
+	tests := []struct {
+		name         string
+		secret       string
+		signature256 string
+		signature1   string
+		want         error
+	}{
+		{name: "valid sha256 signature", secret: mockSecret, signature256: sha256Signature},
+		{name: "valid sha1 signature", secret: mockSecret, signature1: sha1Signature},
+		{name: "bad signature", secret: mockSecret, signature256: "sha256=" + signHex("other_secret", body), want: ErrInvalidSignature},
+		{name: "bad sha1 signature", secret: mockSecret, signature1: "sha1=" + signHex(mockSecret, body), want: ErrInvalidSignature},
+		{name: "sha256 signature is preferred", secret: mockSecret, signature256: "sha256=00", signature1: sha1Signature, want: ErrInvalidSignature},
+		{name: "missing header", secret: mockSecret, want: ErrMissingSignature},
+		{name: "wrong prefix", secret: mockSecret, signature256: "sha1=" + signHex(mockSecret, body), want: ErrInvalidSignature},
+		{name: "without prefix", secret: mockSecret, signature256: signHex(mockSecret, body), want: ErrInvalidSignature},
+		{name: "not hex", secret: mockSecret, signature256: "sha256=not-hex", want: ErrInvalidSignature},
+		{name: "no secret", signature256: sha256Signature, want: ErrNoSecret},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifyGithubSignature(tt.secret, body, tt.signature256, tt.signature1); err != tt.want {
+				t.Errorf("VerifyGithubSignature() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSecretStoreLookup(t *testing.T) {
+	s := &SecretStore{repos: map[string]string{}}
+
+	s.SetGlobal("global")
+	s.Set("github.com/axetroy/Blog", "blog")
+
+	if got := s.Lookup("github.com/axetroy/blog"); got != "blog" {
+		t.Errorf("Lookup() = %s, want the secret of the repository", got)
+	}
+
+	if got := s.Lookup("github.com/axetroy/other"); got != "global" {
+		t.Errorf("Lookup() = %s, want the global secret", got)
+	}
+}
@@ -0,0 +1,60 @@
+package hook
+
+import (
+	"context"
+	"log"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/deploy"
+	"github.com/axetroy/hooker/internal/app/store"
+	"github.com/pkg/errors"
+)
+
+// 程序重启之后重新监控正在运行的容器, 按照项目当前的重启策略处理之后的崩溃.
+// compose 部署的服务使用 Docker 的重启策略, 不需要监控
+func Resupervise(ctx context.Context) error {
+	workloads, err := container.ListWorkloads(ctx, "")
+
+	if err != nil {
+		return err
+	}
+
+	for _, w := range workloads {
+		// 正在进行健康检查的容器由部署处理
+		if w.Service != "" || w.State != "running" || w.Name != container.ContainerName(w.Project, w.Environment) {
+			continue
+		}
+
+		project, err := store.Default.GetProject(w.Project)
+
+		if errors.Cause(err) == store.ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		record, err := store.Default.GetLog(w.Project, w.Deployment)
+
+		if errors.Cause(err) == store.ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		task, err := environmentTask(*project, "reconcile", w.Environment, record.Ref, w.Commit)
+
+		if err != nil {
+			log.Printf("%+v\n", err)
+			continue
+		}
+
+		if err := deploy.Supervise(*task, *record, w.Id); err != nil {
+			log.Printf("%+v\n", err)
+			continue
+		}
+
+		log.Printf("Supervise container '%s' of project '%s'\n", w.Name, w.Project)
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package model
+
+import "time"
+
+// Web Hook 请求的处理状态
+type DeliveryStatus string
+
+const (
+	DeliveryProcessed DeliveryStatus = "processed" // 已经处理, 处理失败的原因保存在 error 中
+	DeliveryDuplicate DeliveryStatus = "duplicate" // 代码托管平台重试的请求, 已经处理过, 不再处理
+)
+
+// 收到的 Web Hook 请求, 用于排查问题和重新执行
+type Delivery struct {
+	Id         string              `json:"id"`          // ID
+	Provider   string              `json:"provider"`    // 代码托管平台, 例如 github
+	DeliveryId string              `json:"delivery_id"` // 代码托管平台的请求 ID, 例如 X-GitHub-Delivery, 用于识别重复的请求
+	Event      string              `json:"event"`       // 事件类型, 例如 push
+	Repo       string              `json:"repo"`        // 仓库名称, 例如 github.com/owner/repo
+	Route      string              `json:"route"`       // 请求路径中指定的仓库, 例如 gitlab.com/owner/repo, 自建的代码托管平台的路由为空
+	Header     map[string][]string `json:"header"`      // 请求头
+	Query      string              `json:"query"`       // URL 参数
+	Payload    string              `json:"payload"`     // 请求体
+	Ip         string              `json:"ip"`          // 来源 IP
+	Verified   bool                `json:"verified"`    // 是否通过签名校验
+	Status     DeliveryStatus      `json:"status"`      // 处理状态
+	Error      string              `json:"error"`       // 处理失败的原因
+	ProjectId  string              `json:"project_id"`  // 部署记录所属的项目 ID
+	LogId      string              `json:"log_id"`      // 产生的部署记录 ID, 没有部署时为空
+	ReplayOf   string              `json:"replay_of"`   // 重新执行的请求 ID
+	CreatedAt  time.Time           `json:"created_at"`  // 创建时间
+}
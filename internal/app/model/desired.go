@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// 环境期望运行的版本, 部署成功之后更新, 清理环境之后删除. 容器丢失之后根据它重新部署
+type Desired struct {
+	ProjectId   string    `json:"project_id"`  // 项目 ID
+	Environment string    `json:"environment"` // 环境名称, 为空则为默认环境
+	Deployment  string    `json:"deployment"`  // 部署 ID
+	Ref         string    `json:"ref"`         // 分支或者标签
+	Commit      string    `json:"commit"`      // 部署的 commit hash
+	Deleted     bool      `json:"deleted"`     // 项目已经通过接口删除, 对比时删除环境中的容器
+	UpdatedAt   time.Time `json:"updated_at"`  // 更新时间
+}
@@ -0,0 +1,15 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 等待执行的部署任务, 执行结束后删除
+type Job struct {
+	Id          string          `json:"id"`          // 与部署记录的 ID 相同
+	ProjectId   string          `json:"project_id"`  // 项目 ID
+	Environment string          `json:"environment"` // 部署的环境, 同一个项目的同一个环境同时只会执行一个任务
+	Task        json.RawMessage `json:"task"`        // 部署任务的配置
+	CreatedAt   time.Time       `json:"created_at"`  // 创建时间
+}
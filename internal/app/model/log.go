@@ -1 +1,43 @@
 package model
+
+import "time"
+
+// 部署状态
+type Status string
+
+const (
+	StatusPending  Status = "pending"  // 等待部署
+	StatusRunning  Status = "running"  // 部署中
+	StatusSuccess  Status = "success"  // 部署成功
+	StatusFailure  Status = "failure"  // 部署失败
+	StatusCanceled Status = "canceled" // 被新的部署取代
+	StatusSkipped  Status = "skipped"  // 不符合项目的规则, 没有部署
+)
+
+// 项目的部署记录
+type Log struct {
+	Id          string    `json:"id"`           // 部署 ID
+	ProjectId   string    `json:"project_id"`   // 项目 ID
+	Trigger     string    `json:"trigger"`      // 触发方式, 例如 github/gitlab/project
+	Environment string    `json:"environment"`  // 部署的环境, 为空则为默认环境, 合并请求的预览环境为 pr-1
+	Ref         string    `json:"ref"`          // 分支或者标签
+	Commit      string    `json:"commit"`       // 部署的 commit hash
+	Status      Status    `json:"status"`       // 部署状态
+	Output      string    `json:"output"`       // 构建的输出
+	ContainerId string    `json:"container_id"` // 运行的容器 ID
+	Error       string    `json:"error"`        // 部署失败或者没有部署的原因
+	Unhealthy   bool      `json:"unhealthy"`    // 容器崩溃之后不再重启, 例如超过了最大重启次数
+	Restarts    int       `json:"restarts"`     // 容器崩溃之后重启的总次数
+	Exits       []Exit    `json:"exits"`        // 容器最近的崩溃记录
+	StartedAt   time.Time `json:"started_at"`   // 开始时间
+	FinishedAt  time.Time `json:"finished_at"`  // 结束时间
+	CreatedAt   time.Time `json:"created_at"`   // 创建时间
+}
+
+// 容器的崩溃记录
+type Exit struct {
+	Code      int64     `json:"code"`      // 退出码
+	Logs      []string  `json:"logs"`      // 退出前最后的日志
+	Restarted bool      `json:"restarted"` // 是否被重启
+	ExitedAt  time.Time `json:"exited_at"` // 退出时间
+}
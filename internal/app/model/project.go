@@ -1,18 +1,122 @@
 package model
 
+import "time"
+
 type Project struct {
-	Id         string `json:"id"`         // 项目 ID
-	Name       string `json:"name"`       // 项目名称
-	Desc       string `json:"desc"`       // 项目描述
-	Dockerfile string `json:"dockerfile"` // 指定的 Dockerfile 文件内容
-	Hosts      []Host `json:"hosts"`      // 部署到对应的服务器
+	Id          string     `json:"id"`           // 项目 ID
+	Name        string     `json:"name"`         // 项目名称
+	Desc        string     `json:"desc"`         // 项目描述
+	Provider    string     `json:"provider"`     // 代码托管平台, github/gitlab/gitea/gogs/gitee
+	Repo        string     `json:"repo"`         // 仓库, 例如 github.com/owner/repo
+	Token       string     `json:"token"`        // 触发部署的 token
+	Secret      string     `json:"secret"`       // Web Hook 的密钥
+	Username    string     `json:"username"`     // 克隆仓库的用户名
+	Password    string     `json:"password"`     // 克隆仓库的密码
+	AccessToken string     `json:"access_token"` // 克隆仓库的 access token
+	Ports       []string   `json:"ports"`        // 端口映射, 格式为 8080:80, 本机端口:容器端口
+	Dockerfile  string     `json:"dockerfile"`   // 指定的 Dockerfile 文件内容
+	Branches    []string   `json:"branches"`     // 触发部署的分支, 支持 glob, 以 ! 开头表示排除, 为空则不限制
+	Tags        []string   `json:"tags"`         // 触发部署的标签, 规则同 branches
+	Paths       []string   `json:"paths"`        // 改动的文件符合规则时才部署, 规则同 branches, 例如 ["src/**", "!**.md"]
+	Preview     Preview    `json:"preview"`      // 合并请求的预览环境
+	Report      bool       `json:"report"`       // 是否把部署状态回报给代码托管平台, 使用 access_token 调用 API
+	ApiURL      string     `json:"api_url"`      // 代码托管平台的 API 地址, 为空则根据仓库推导, 例如 https://api.github.com
+	URL         string     `json:"url"`          // 部署之后的访问地址, {environment} 会被替换为环境名称
+	Commands    Commands   `json:"commands"`     // 在 issue 或者合并请求中通过评论触发部署
+	Health      Health     `json:"health"`       // 新容器的健康检查, 通过之后才会替换旧的容器
+	Releases    Releases   `json:"releases"`     // 保留的版本和自动回滚
+	Variables   []Variable `json:"variables"`    // 注入容器的环境变量和密钥
+	Volumes     []Volume   `json:"volumes"`      // 挂载的命名卷和本机目录
+	Networks    []Network  `json:"networks"`     // 容器加入的网络
+	Resources   Resources  `json:"resources"`    // 容器的资源限制
+	Restart     Restart    `json:"restart"`      // 容器崩溃之后的重启策略
+	Compose     Compose    `json:"compose"`      // 使用仓库中的 docker-compose.yml 部署多个服务
+	Hosts       []Host     `json:"hosts"`        // 部署到对应的服务器
+	CreatedAt   time.Time  `json:"created_at"`   // 创建时间
+	UpdatedAt   time.Time  `json:"updated_at"`   // 更新时间
+}
+
+// 合并请求的预览环境, 每个合并请求部署为单独的容器, 合并请求关闭或者合并之后清理
+type Preview struct {
+	Enabled  bool   `json:"enabled"`   // 是否部署预览环境
+	PortBase uint64 `json:"port_base"` // 预览环境的本机端口为 port_base + 合并请求的编号, 映射到第一个端口映射的容器端口, 项目有端口映射时必填
+	TTL      string `json:"ttl"`       // 有效期, 例如 72h, 超过有效期没有更新的预览环境会被清理, 为空则不清理
+}
+
+// 评论中的部署命令, 支持 /deploy /redeploy /rollback, 执行结果会回复到评论中
+type Commands struct {
+	Enabled bool     `json:"enabled"` // 是否开启评论命令
+	Users   []string `json:"users"`   // 允许执行命令的用户
+	Roles   []string `json:"roles"`   // 允许执行命令的仓库角色, 例如 OWNER/MEMBER/COLLABORATOR, 为空则为这三个角色
+}
+
+// 健康检查, 新容器健康之后才会切换流量并且停止旧的容器, 否则旧的容器继续运行
+type Health struct {
+	Type     string `json:"type"`     // docker/http/tcp, 为空时镜像有 HEALTHCHECK 则使用 docker, 否则只检查容器是否在运行
+	Path     string `json:"path"`     // http 检查的路径, 默认为 /
+	Port     uint64 `json:"port"`     // http/tcp 检查的容器端口, 为 0 则使用第一个端口映射的容器端口
+	Timeout  string `json:"timeout"`  // 等待健康的超时时间, 默认为 60s
+	Interval string `json:"interval"` // 检查的间隔, 默认为 2s
+	Proxy    bool   `json:"proxy"`    // 本机端口由 hooker 监听并且转发到容器, 切换容器时不中断服务. 默认由 docker 发布端口, 需要先停止旧的容器
+}
+
+// 每个环境保留最近构建的镜像, 可以不需要构建直接部署
+type Releases struct {
+	Keep         int    `json:"keep"`          // 保留的镜像数量, 为 0 则为 3
+	AutoRollback bool   `json:"auto_rollback"` // 部署失败并且没有容器在运行, 或者容器在宽限期内崩溃时, 自动部署上一个版本
+	GracePeriod  string `json:"grace_period"`  // 容器启动之后的宽限期, 默认为 5m
+}
+
+// 注入容器的环境变量, 同名的变量中指定了环境的优先
+type Variable struct {
+	Name        string `json:"name"`        // 环境变量名
+	Value       string `json:"value"`       // 值, 密钥加密保存, 接口中不返回, 更新时为空则保持不变
+	Secret      bool   `json:"secret"`      // 是否为密钥, 部署日志中的密钥会被替换为 ******
+	Environment string `json:"environment"` // 生效的环境, 支持 glob, 默认环境为 default, 例如 pr-*, 为空则所有环境生效
+	File        string `json:"file"`        // 同时挂载为容器中的只读文件, 例如 /run/secrets/db_password, 为空则不挂载
+}
+
+// 挂载到容器中的卷, name 和 source 二选一
+type Volume struct {
+	Name     string `json:"name"`      // 命名卷, 不存在时自动创建, 重新部署和清理环境时保留, {environment} 会被替换为环境名称
+	Source   string `json:"source"`    // 本机目录, 必须在启动参数 --mount-root 指定的目录中
+	Target   string `json:"target"`    // 容器中的路径, 例如 /data
+	ReadOnly bool   `json:"read_only"` // 是否只读
+}
+
+// 容器加入的网络, 同一个网络中的容器可以通过别名互相访问
+type Network struct {
+	Name    string   `json:"name"`    // 网络名称, 不存在时自动创建, {environment} 会被替换为环境名称
+	Aliases []string `json:"aliases"` // 容器在网络中的别名, 例如 api
+}
+
+// 容器的资源限制, 为空则不限制
+type Resources struct {
+	CPUs   float64 `json:"cpus"`   // CPU 核数, 例如 0.5
+	Memory string  `json:"memory"` // 内存, 例如 512m, 1g
+	Pids   int64   `json:"pids"`   // 最大进程数
+}
+
+// 容器崩溃之后的重启策略, 超过最大重启次数之后部署记录被标记为不健康
+type Restart struct {
+	Policy     string `json:"policy"`      // no/on-failure/always, 默认为 on-failure
+	MaxRetries int    `json:"max_retries"` // 最大连续重启次数, 默认为 5, 容器稳定运行 5 分钟之后重新计算
+	Backoff    string `json:"backoff"`     // 第一次重启的等待时间, 之后每次翻倍, 最长 5m, 默认为 1s
+}
+
+// 使用 docker-compose.yml 部署, 开启之后 dockerfile, ports, health, volumes, networks, resources 和 restart 不再生效
+type Compose struct {
+	Enabled bool   `json:"enabled"` // 是否使用 compose 部署
+	File    string `json:"file"`    // compose 文件在仓库中的路径, 默认为 docker-compose.yml
 }
 
 type Host struct {
-	Id         string `json:"id"`          // 服务器 ID
-	Host       string `json:"host"`        // 服务器地址
-	Port       uint32 `json:"port"`        // 端口
-	Username   string `json:"username"`    // 用户名
-	Password   string `json:"password"`    // 密码
-	PrivateKey string `json:"private_key"` // 私钥
+	Id         string    `json:"id"`          // 服务器 ID
+	Host       string    `json:"host"`        // 服务器地址
+	Port       uint32    `json:"port"`        // 端口
+	Username   string    `json:"username"`    // 用户名
+	Password   string    `json:"password"`    // 密码
+	PrivateKey string    `json:"private_key"` // 私钥
+	CreatedAt  time.Time `json:"created_at"`  // 创建时间
+	UpdatedAt  time.Time `json:"updated_at"`  // 更新时间
 }
@@ -1 +1,20 @@
 package model
+
+import "time"
+
+type User struct {
+	Id        string    `json:"id"`         // 用户 ID
+	Username  string    `json:"username"`   // 用户名
+	Password  string    `json:"password"`   // 密码的哈希值
+	Admin     bool      `json:"admin"`      // 是否为管理员, 只有管理员可以注册新用户
+	CreatedAt time.Time `json:"created_at"` // 创建时间
+	UpdatedAt time.Time `json:"updated_at"` // 更新时间
+}
+
+// 登录会话
+type Session struct {
+	Id        string    `json:"id"`         // token 的哈希值, 不保存 token 本身
+	UserId    string    `json:"user_id"`    // 用户 ID
+	ExpiredAt time.Time `json:"expired_at"` // 过期时间
+	CreatedAt time.Time `json:"created_at"` // 创建时间
+}
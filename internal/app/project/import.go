@@ -0,0 +1,98 @@
+package project
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/store"
+	"github.com/axetroy/hooker/internal/app/vault"
+	"github.com/pkg/errors"
+)
+
+// 从 JSON 文件中导入项目, 格式为 [{"id": "blog", "repo": "github.com/owner/repo", ...}]
+// 项目通过 id 对应, 已存在的项目默认保持不变, 避免每次启动都覆盖通过接口修改的配置. overwrite 为 true 时使用文件中的配置覆盖
+func ImportProjects(filepath string, overwrite bool) error {
+	b, err := ioutil.ReadFile(filepath)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var projects []model.Project
+
+	if err := json.Unmarshal(b, &projects); err != nil {
+		return errors.WithStack(err)
+	}
+
+	ids := map[string]bool{}
+
+	for i := range projects {
+		project := projects[i]
+
+		// 没有 id 的项目每次启动都会被重复创建
+		if !nameReg.MatchString(project.Id) {
+			return errors.Errorf("invalid id '%s' of project %d in '%s', only letters, numbers, '_', '-' and '.' are allowed", project.Id, i, filepath)
+		}
+
+		if ids[project.Id] {
+			return errors.Errorf("duplicate project '%s' in '%s'", project.Id, filepath)
+		}
+
+		ids[project.Id] = true
+
+		if project.Name == "" {
+			project.Name = project.Id
+		}
+
+		if err := validateInput(project.Variables); err != nil {
+			return errors.Wrapf(err, "project '%s'", project.Id)
+		}
+
+		if err := validate(project); err != nil {
+			return errors.Wrapf(err, "project '%s'", project.Id)
+		}
+
+		projects[i] = project
+	}
+
+	// 全部校验通过之后再写入, 避免只导入了一部分项目
+	for i := range projects {
+		project := projects[i]
+
+		origin, err := store.Default.GetProject(project.Id)
+
+		if err != nil && errors.Cause(err) != store.ErrNotFound {
+			return err
+		}
+
+		if origin != nil && !overwrite {
+			log.Printf("Project '%s' already exists, skip importing\n", project.Id)
+			continue
+		}
+
+		for i, h := range project.Hosts {
+			if h.Id == "" {
+				project.Hosts[i].Id = store.NewId()
+			}
+		}
+
+		if err := vault.SealVariables(project.Variables); err != nil {
+			return err
+		}
+
+		if origin == nil {
+			err = store.Default.CreateProject(&project)
+		} else {
+			project.CreatedAt = origin.CreatedAt
+			err = store.Default.UpdateProject(&project)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
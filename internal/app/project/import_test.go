@@ -0,0 +1,114 @@
+package project
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/axetroy/hooker/internal/app/store"
+)
+
+func importFile(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "projects.json")
+
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestImportProjects(t *testing.T) {
+	db, err := store.NewFileStore(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Default = db
+
+	defer func() {
+		store.Default = nil
+	}()
+
+	file := importFile(t, `[{"id": "blog", "repo": "github.com/axetroy/blog", "ports": ["8080:80"]}]`)
+
+	for i := 0; i < 2; i++ {
+		if err := ImportProjects(file, false); err != nil {
+			t.Fatalf("ImportProjects() error = %v", err)
+		}
+	}
+
+	projects, err := db.ListProjects()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(projects) != 1 || projects[0].Id != "blog" || projects[0].Name != "blog" {
+		t.Fatalf("projects = %+v, want only blog", projects)
+	}
+
+	// 通过接口修改的配置在重启之后保持不变
+	project := projects[0]
+	project.Ports = []string{"9090:80"}
+
+	if err := db.UpdateProject(&project); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ImportProjects(file, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if p, _ := db.GetProject("blog"); len(p.Ports) != 1 || p.Ports[0] != "9090:80" {
+		t.Errorf("ports = %v, want changes made through the API", p.Ports)
+	}
+
+	if err := ImportProjects(file, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if p, _ := db.GetProject("blog"); len(p.Ports) != 1 || p.Ports[0] != "8080:80" || !p.CreatedAt.Equal(project.CreatedAt) {
+		t.Errorf("project = %+v, want overwritten by the file", p)
+	}
+}
+
+func TestImportProjectsInvalid(t *testing.T) {
+	db, err := store.NewFileStore(t.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Default = db
+
+	defer func() {
+		store.Default = nil
+	}()
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "without id", content: `[{"repo": "github.com/axetroy/blog"}]`},
+		{name: "invalid id", content: `[{"id": "../blog", "repo": "github.com/axetroy/blog"}]`},
+		{name: "duplicate id", content: `[{"id": "blog", "repo": "github.com/axetroy/blog"}, {"id": "blog", "repo": "github.com/axetroy/blog"}]`},
+		{name: "without repo", content: `[{"id": "blog"}]`},
+		{name: "sealed value", content: `[{"id": "blog", "repo": "github.com/axetroy/blog", "variables": [{"name": "TOKEN", "value": "enc:abc", "secret": true}]}]`},
+		{name: "invalid port", content: `[{"id": "blog", "repo": "github.com/axetroy/blog", "ports": ["80:70000"]}]`},
+		{name: "invalid project after valid one", content: `[{"id": "web", "repo": "github.com/axetroy/web"}, {"id": "blog", "repo": "github.com/axetroy/blog", "restart": {"policy": "sometimes"}}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ImportProjects(importFile(t, tt.content), false); err == nil {
+				t.Error("ImportProjects() error = nil")
+			}
+		})
+	}
+
+	if projects, err := db.ListProjects(); err != nil || len(projects) != 0 {
+		t.Errorf("projects = %+v, want nothing imported", projects)
+	}
+}
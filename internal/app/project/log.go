@@ -0,0 +1,301 @@
+package project
+
+import (
+	stdContext "context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/axetroy/hooker/internal/app/deploy"
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/schema"
+	"github.com/axetroy/hooker/internal/app/store"
+	"github.com/kataras/iris/v12/context"
+	"github.com/pkg/errors"
+)
+
+// 部署记录列表中的数据, 不包含构建输出
+type LogItem struct {
+	Id          string       `json:"id"`
+	ProjectId   string       `json:"project_id"`
+	Trigger     string       `json:"trigger"`
+	Environment string       `json:"environment"`
+	Ref         string       `json:"ref"`
+	Commit      string       `json:"commit"`
+	Status      model.Status `json:"status"`
+	ContainerId string       `json:"container_id"`
+	Error       string       `json:"error"`
+	StartedAt   time.Time    `json:"started_at"`
+	FinishedAt  time.Time    `json:"finished_at"`
+	CreatedAt   time.Time    `json:"created_at"`
+}
+
+// 根据项目 ID 或者项目名称获取部署记录的项目 ID, 没有注册的仓库直接使用参数作为 ID
+func logProjectId(id string) (string, error) {
+	if project, err := store.Default.GetProject(id); err == nil {
+		return project.Id, nil
+	} else if errors.Cause(err) != store.ErrNotFound {
+		return "", err
+	}
+
+	projects, err := store.Default.ListProjects()
+
+	if err != nil {
+		return "", err
+	}
+
+	for _, p := range projects {
+		if p.Name == id {
+			return p.Id, nil
+		}
+	}
+
+	return id, nil
+}
+
+// 项目部署日志列表
+//
+// ?page=0&limit=10&status=failure&environment=pr-1
+func LogListRouter(ctx context.Context) {
+	var (
+		err  error
+		data interface{}
+		meta *schema.Meta
+	)
+
+	defer func() {
+		schema.JSON(ctx, data, meta, err)
+	}()
+
+	projectId, err := logProjectId(ctx.Params().Get("project"))
+
+	if err != nil {
+		return
+	}
+
+	page, limit := schema.Pagination(ctx)
+
+	status := model.Status(ctx.URLParam("status"))
+	environment, filterEnvironment := ctx.URLParams()["environment"]
+
+	logs, err := store.Default.ListLogs(projectId)
+
+	if err != nil {
+		return
+	}
+
+	filtered := make([]model.Log, 0)
+
+	for _, l := range logs {
+		if status != "" && l.Status != status {
+			continue
+		}
+
+		if filterEnvironment && l.Environment != environment {
+			continue
+		}
+
+		filtered = append(filtered, l)
+	}
+
+	list := make([]LogItem, 0)
+
+	start, end := schema.PageRange(page, limit, len(filtered))
+
+	for i := start; i < end; i++ {
+		l := filtered[i]
+
+		list = append(list, LogItem{
+			Id:          l.Id,
+			ProjectId:   l.ProjectId,
+			Trigger:     l.Trigger,
+			Environment: l.Environment,
+			Ref:         l.Ref,
+			Commit:      l.Commit,
+			Status:      l.Status,
+			ContainerId: l.ContainerId,
+			Error:       l.Error,
+			StartedAt:   l.StartedAt,
+			FinishedAt:  l.FinishedAt,
+			CreatedAt:   l.CreatedAt,
+		})
+	}
+
+	data = list
+	meta = &schema.Meta{
+		Page:  page,
+		Limit: limit,
+		Num:   len(list),
+		Total: len(filtered),
+	}
+}
+
+// 项目部署日志详情, 包含构建输出
+func LogDetailRouter(ctx context.Context) {
+	var (
+		err  error
+		data interface{}
+	)
+
+	defer func() {
+		schema.JSON(ctx, data, nil, err)
+	}()
+
+	projectId, err := logProjectId(ctx.Params().Get("project"))
+
+	if err != nil {
+		return
+	}
+
+	record, err := store.Default.GetLog(projectId, ctx.Params().Get("id"))
+
+	if err != nil {
+		if errors.Cause(err) == store.ErrNotFound {
+			err = schema.NewError(http.StatusNotFound, "log not found")
+		}
+
+		return
+	}
+
+	data = record
+}
+
+// 推送部署的事件, 格式为 Server-Sent Events
+func writeEvent(ctx context.Context, id int, e deploy.Event) error {
+	b, err := json.Marshal(e)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if _, err := fmt.Fprintf(ctx.ResponseWriter(), "id: %d\nevent: %s\ndata: %s\n\n", id, e.Type, b); err != nil {
+		return errors.WithStack(err)
+	}
+
+	ctx.ResponseWriter().Flush()
+
+	return nil
+}
+
+type connKey struct{}
+
+// 作为 http.Server 的 ConnContext, 保存请求的连接, 实时日志需要取消连接写入的超时时间
+func ConnContext(ctx stdContext.Context, conn net.Conn) stdContext.Context {
+	return stdContext.WithValue(ctx, connKey{}, conn)
+}
+
+// 实时获取部署的构建输出和状态, 格式为 Server-Sent Events
+// 新的订阅者会先收到之前的事件, 输出过多时只有最近的输出, 断线重连时通过 Last-Event-ID 跳过已经收到的事件
+func LogStreamRouter(ctx context.Context) {
+	projectId, err := logProjectId(ctx.Params().Get("project"))
+
+	if err != nil {
+		schema.JSON(ctx, nil, nil, err)
+		return
+	}
+
+	id := ctx.Params().Get("id")
+
+	record, err := store.Default.GetLog(projectId, id)
+
+	if err != nil {
+		if errors.Cause(err) == store.ErrNotFound {
+			err = schema.NewError(http.StatusNotFound, "log not found")
+		}
+
+		schema.JSON(ctx, nil, nil, err)
+		return
+	}
+
+	lastEventId := -1
+
+	if v := ctx.GetHeader("Last-Event-ID"); v != "" {
+		if i, err := strconv.Atoi(v); err == nil {
+			lastEventId = i
+		}
+	}
+
+	// 构建的时间可能超过服务器的 WriteTimeout, 实时日志不设置写入的超时时间
+	if conn, ok := ctx.Request().Context().Value(connKey{}).(net.Conn); ok {
+		if err := conn.SetWriteDeadline(time.Time{}); err != nil {
+			log.Printf("%+v\n", errors.WithStack(err))
+		}
+	}
+
+	ctx.ContentType("text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Connection", "keep-alive")
+	ctx.StatusCode(http.StatusOK)
+
+	stream := deploy.GetStream(record.Id)
+
+	// 部署已经结束, 从部署记录中回放
+	if stream == nil {
+		events := []deploy.Event{
+			{Type: deploy.EventOutput, Output: record.Output, Time: record.StartedAt},
+			{Type: deploy.EventStatus, Status: record.Status, Time: record.FinishedAt},
+			{Type: deploy.EventResult, Status: record.Status, Error: record.Error, Time: record.FinishedAt},
+		}
+
+		// 正在部署中但是不在当前进程中, 例如程序重启之前的部署
+		if record.FinishedAt.IsZero() {
+			events = events[:2]
+		}
+
+		for i, e := range events {
+			if lastEventId >= 0 && e.Type != deploy.EventResult {
+				continue
+			}
+
+			if err := writeEvent(ctx, i, e); err != nil {
+				return
+			}
+		}
+
+		return
+	}
+
+	history, index, ch, cancel := stream.Subscribe()
+
+	defer cancel()
+
+	// 最早的输出已经被丢弃, 用一个截断的事件代替
+	if index > 0 && lastEventId < index-1 {
+		if err := writeEvent(ctx, index-1, deploy.TruncatedEvent()); err != nil {
+			return
+		}
+	}
+
+	for _, e := range history {
+		if index > lastEventId {
+			if err := writeEvent(ctx, index, e); err != nil {
+				return
+			}
+		}
+
+		index++
+	}
+
+	for {
+		select {
+		case <-ctx.Request().Context().Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			if index > lastEventId {
+				if err := writeEvent(ctx, index, e); err != nil {
+					return
+				}
+			}
+
+			index++
+		}
+	}
+}
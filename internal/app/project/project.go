@@ -0,0 +1,652 @@
+package project
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/hook"
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/axetroy/hooker/internal/app/schema"
+	"github.com/axetroy/hooker/internal/app/store"
+	"github.com/axetroy/hooker/internal/app/vault"
+	"github.com/kataras/iris/v12/context"
+	"github.com/pkg/errors"
+)
+
+var nameReg = regexp.MustCompile(`^[\w\-.]{1,64}$`)
+
+func invalid(format string, args ...interface{}) error {
+	return schema.NewError(http.StatusBadRequest, errors.Errorf(format, args...).Error())
+}
+
+// 隐藏项目中的密钥, 密钥不会在接口中返回
+func public(project model.Project) model.Project {
+	project.Token = ""
+	project.Secret = ""
+	project.Password = ""
+	project.AccessToken = ""
+
+	hosts := make([]model.Host, 0, len(project.Hosts))
+
+	for _, h := range project.Hosts {
+		h.Password = ""
+		h.PrivateKey = ""
+		hosts = append(hosts, h)
+	}
+
+	project.Hosts = hosts
+
+	variables := make([]model.Variable, 0, len(project.Variables))
+
+	for _, v := range project.Variables {
+		if v.Secret {
+			v.Value = ""
+		}
+
+		variables = append(variables, v)
+	}
+
+	project.Variables = variables
+
+	return project
+}
+
+// 环境变量名只能包含字母, 数字和下划线, 并且不能以数字开头
+var variableReg = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// 校验环境变量, HOOKER_ 开头的变量为内置变量
+func validateVariables(variables []model.Variable) error {
+	files := map[string]bool{}
+
+	for _, v := range variables {
+		if !variableReg.MatchString(v.Name) {
+			return invalid("invalid variable name '%s'", v.Name)
+		}
+
+		if strings.HasPrefix(strings.ToUpper(v.Name), "HOOKER_") {
+			return invalid("variable '%s' is reserved", v.Name)
+		}
+
+		if v.File == "" {
+			continue
+		}
+
+		if !path.IsAbs(v.File) || path.Clean(v.File) == "/" {
+			return invalid("file of variable '%s' must be an absolute path", v.Name)
+		}
+
+		key := v.Environment + ":" + path.Clean(v.File)
+
+		if files[key] {
+			return invalid("file '%s' is mounted more than once", v.File)
+		}
+
+		files[key] = true
+	}
+
+	return nil
+}
+
+// 校验用户输入的变量值, 以加密前缀开头的值会被当作已经加密的值, 不会被加密而是以明文保存
+func validateInput(variables []model.Variable) error {
+	for _, v := range variables {
+		if vault.Encrypted(v.Value) {
+			return invalid("value of variable '%s' must not start with 'enc:'", v.Name)
+		}
+	}
+
+	return nil
+}
+
+// 卷和网络的名称, 与 docker 的规则相同
+var volumeReg = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// 校验挂载的卷, 本机目录必须在允许挂载的目录中
+func validateVolumes(volumes []model.Volume) error {
+	targets := map[string]bool{}
+
+	for _, v := range volumes {
+		if (v.Name == "") == (v.Source == "") {
+			return invalid("volume of '%s' must specify one of name or source", v.Target)
+		}
+
+		if v.Name != "" && !volumeReg.MatchString(container.ExpandName(v.Name, "")) {
+			return invalid("invalid volume name '%s'", v.Name)
+		}
+
+		if v.Source != "" {
+			if err := container.CheckMountSource(v.Source); err != nil {
+				return invalid("%s", err.Error())
+			}
+		}
+
+		if !path.IsAbs(v.Target) || path.Clean(v.Target) == "/" {
+			return invalid("target of volume '%s%s' must be an absolute path", v.Name, v.Source)
+		}
+
+		if targets[path.Clean(v.Target)] {
+			return invalid("target '%s' is mounted more than once", v.Target)
+		}
+
+		targets[path.Clean(v.Target)] = true
+	}
+
+	return nil
+}
+
+// 校验容器加入的网络
+func validateNetworks(networks []model.Network) error {
+	names := map[string]bool{}
+
+	for _, n := range networks {
+		name := container.ExpandName(n.Name, "")
+
+		if !volumeReg.MatchString(name) {
+			return invalid("invalid network name '%s'", n.Name)
+		}
+
+		switch name {
+		case "host", "none", "bridge", "default":
+			return invalid("network '%s' is reserved", n.Name)
+		}
+
+		if names[n.Name] {
+			return invalid("network '%s' is joined more than once", n.Name)
+		}
+
+		names[n.Name] = true
+
+		for _, alias := range n.Aliases {
+			if !volumeReg.MatchString(alias) {
+				return invalid("invalid alias '%s' of network '%s'", alias, n.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// 校验 Dockerfile 的内容, 第一条指令必须是 FROM (ARG 除外)
+func validateDockerfile(content string) error {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		instruction := strings.ToUpper(strings.Fields(line)[0])
+
+		switch instruction {
+		case "ARG":
+			continue
+		case "FROM":
+			return nil
+		default:
+			return invalid("the first instruction of dockerfile must be FROM, but got '%s'", instruction)
+		}
+	}
+
+	return invalid("dockerfile must contain a FROM instruction")
+}
+
+// 校验项目的配置
+func validate(project model.Project) error {
+	if !nameReg.MatchString(project.Name) {
+		return invalid("invalid name '%s', only letters, numbers, '_', '-' and '.' are allowed", project.Name)
+	}
+
+	if project.Repo == "" {
+		return invalid("repo is required")
+	}
+
+	if project.Provider != "" {
+		if _, ok := hook.Providers[project.Provider]; !ok {
+			return invalid("invalid provider '%s'", project.Provider)
+		}
+	}
+
+	ports, err := hook.ParsePort(project.Ports)
+
+	if err != nil {
+		return invalid("invalid ports: %s", errors.Cause(err).Error())
+	}
+
+	for _, p := range ports {
+		if p.MachinePort == 0 || p.MachinePort > 65535 || p.ContainerPort == 0 || p.ContainerPort > 65535 {
+			return invalid("port must be between 1 and 65535")
+		}
+	}
+
+	rules := append(append(append([]string{}, project.Branches...), project.Tags...), project.Paths...)
+
+	for _, rule := range rules {
+		if strings.TrimPrefix(rule, "!") == "" {
+			return invalid("branch, tag and path rules must not be empty")
+		}
+	}
+
+	if project.ApiURL != "" {
+		if u, err := url.Parse(project.ApiURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return invalid("invalid api_url '%s'", project.ApiURL)
+		}
+	}
+
+	if project.Preview.PortBase > 65535 {
+		return invalid("port_base of preview must be between 0 and 65535")
+	}
+
+	// 预览环境的端口为 port_base + 合并请求的编号, 没有 port_base 时预览环境无法访问
+	if project.Preview.Enabled && !project.Compose.Enabled && len(project.Ports) > 0 && project.Preview.PortBase == 0 {
+		return invalid("port_base of preview is required when preview is enabled and ports are set")
+	}
+
+	if project.Preview.TTL != "" {
+		if ttl, err := time.ParseDuration(project.Preview.TTL); err != nil || ttl <= 0 {
+			return invalid("invalid ttl '%s' of preview, use duration such as '72h'", project.Preview.TTL)
+		}
+	}
+
+	// 评论命令需要 access token 回复评论
+	if project.Commands.Enabled && project.AccessToken == "" {
+		return invalid("access_token is required to enable commands")
+	}
+
+	for _, name := range append(append([]string{}, project.Commands.Users...), project.Commands.Roles...) {
+		if strings.TrimSpace(name) == "" {
+			return invalid("users and roles of commands must not be empty")
+		}
+	}
+
+	if project.Releases.Keep < 0 {
+		return invalid("keep of releases must not be negative")
+	}
+
+	if project.Releases.GracePeriod != "" {
+		if d, err := time.ParseDuration(project.Releases.GracePeriod); err != nil || d <= 0 {
+			return invalid("invalid grace_period '%s' of releases, use duration such as '5m'", project.Releases.GracePeriod)
+		}
+	}
+
+	if err := validateVariables(project.Variables); err != nil {
+		return err
+	}
+
+	if err := validateVolumes(project.Volumes); err != nil {
+		return err
+	}
+
+	if err := validateNetworks(project.Networks); err != nil {
+		return err
+	}
+
+	if project.Resources.CPUs < 0 || project.Resources.Pids < 0 {
+		return invalid("cpus and pids of resources can not be negative")
+	}
+
+	if memory, err := container.ParseMemory(project.Resources.Memory); err != nil || memory < 0 {
+		return invalid("invalid memory '%s' of resources, use size such as '512m'", project.Resources.Memory)
+	} else if memory > 0 && memory < 6*1024*1024 {
+		return invalid("memory of resources must be at least 6m")
+	}
+
+	if f := project.Compose.File; f != "" {
+		if path.IsAbs(f) || path.Clean(f) != f || strings.HasPrefix(f, "../") || f == ".." {
+			return invalid("file of compose must be a relative path in the repository, such as 'deploy/docker-compose.yml'")
+		}
+	}
+
+	switch project.Restart.Policy {
+	case "", container.RestartNo, container.RestartOnFailure, container.RestartAlways:
+	default:
+		return invalid("invalid restart policy '%s', use no, on-failure or always", project.Restart.Policy)
+	}
+
+	if project.Restart.MaxRetries < 0 {
+		return invalid("max_retries of restart can not be negative")
+	}
+
+	if project.Restart.Backoff != "" {
+		if d, err := time.ParseDuration(project.Restart.Backoff); err != nil || d <= 0 {
+			return invalid("invalid backoff '%s' of restart, use duration such as '1s'", project.Restart.Backoff)
+		}
+	}
+
+	switch project.Health.Type {
+	case "", container.HealthDocker, container.HealthHTTP, container.HealthTCP:
+	default:
+		return invalid("invalid health type '%s', use docker, http or tcp", project.Health.Type)
+	}
+
+	if project.Health.Port > 65535 {
+		return invalid("port of health must be between 0 and 65535")
+	}
+
+	for _, d := range []string{project.Health.Timeout, project.Health.Interval} {
+		if d == "" {
+			continue
+		}
+
+		if duration, err := time.ParseDuration(d); err != nil || duration <= 0 {
+			return invalid("invalid duration '%s' of health, use duration such as '30s'", d)
+		}
+	}
+
+	if project.Dockerfile != "" {
+		if err := validateDockerfile(project.Dockerfile); err != nil {
+			return err
+		}
+	}
+
+	// 名称不能重复, 写入时存储会再次检查
+	projects, err := store.Default.ListProjects()
+
+	if err != nil {
+		return err
+	}
+
+	for _, p := range projects {
+		if p.Id != project.Id && strings.EqualFold(p.Name, project.Name) {
+			return schema.NewError(http.StatusConflict, errors.Errorf("project '%s' already exists", project.Name).Error())
+		}
+	}
+
+	return nil
+}
+
+// 同时创建或者修改为同名的项目时, 由存储检查出重复的名称
+func duplicateName(err error, name string) error {
+	if errors.Cause(err) == store.ErrDuplicateName {
+		return schema.NewError(http.StatusConflict, errors.Errorf("project '%s' already exists", name).Error())
+	}
+
+	return err
+}
+
+func getProject(id string) (*model.Project, error) {
+	project, err := store.Default.GetProject(id)
+
+	if errors.Cause(err) == store.ErrNotFound {
+		return nil, schema.NewError(http.StatusNotFound, "project not found")
+	}
+
+	return project, err
+}
+
+// 创建项目
+func CreateRouter(ctx context.Context) {
+	var (
+		err     error
+		data    interface{}
+		project model.Project
+	)
+
+	defer func() {
+		schema.JSON(ctx, data, nil, err)
+	}()
+
+	if err = ctx.ReadJSON(&project); err != nil {
+		err = schema.NewError(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	project.Id = ""
+
+	if err = validateInput(project.Variables); err != nil {
+		return
+	}
+
+	if err = validate(project); err != nil {
+		return
+	}
+
+	// 没有指定触发部署的 token 则自动生成, 仅在创建时返回一次
+	generated := project.Token == ""
+
+	if generated {
+		project.Token = store.NewId()
+	}
+
+	for i := range project.Hosts {
+		project.Hosts[i].Id = store.NewId()
+	}
+
+	if err = vault.SealVariables(project.Variables); err != nil {
+		return
+	}
+
+	if err = store.Default.CreateProject(&project); err != nil {
+		err = duplicateName(err, project.Name)
+		return
+	}
+
+	result := public(project)
+
+	if generated {
+		result.Token = project.Token
+	}
+
+	data = result
+}
+
+// 更新项目, 没有传的字段保持不变, 密钥为空时保持不变
+func UpdateRouter(ctx context.Context) {
+	var (
+		err  error
+		data interface{}
+	)
+
+	defer func() {
+		schema.JSON(ctx, data, nil, err)
+	}()
+
+	origin, err := getProject(ctx.Params().Get("id"))
+
+	if err != nil {
+		return
+	}
+
+	project := *origin
+	project.Hosts = nil
+	project.Variables = nil
+	project.Volumes = nil
+	project.Networks = nil
+
+	if err = ctx.ReadJSON(&project); err != nil {
+		err = schema.NewError(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 还没有合并原来的加密值, 只校验输入的值
+	if err = validateInput(project.Variables); err != nil {
+		return
+	}
+
+	if project.Hosts == nil {
+		project.Hosts = origin.Hosts
+	}
+
+	if project.Variables == nil {
+		project.Variables = origin.Variables
+	}
+
+	if project.Volumes == nil {
+		project.Volumes = origin.Volumes
+	}
+
+	if project.Networks == nil {
+		project.Networks = origin.Networks
+	}
+
+	project.Id = origin.Id
+	project.CreatedAt = origin.CreatedAt
+
+	keep := func(value *string, originValue string) {
+		if *value == "" {
+			*value = originValue
+		}
+	}
+
+	keep(&project.Token, origin.Token)
+	keep(&project.Secret, origin.Secret)
+	keep(&project.Password, origin.Password)
+	keep(&project.AccessToken, origin.AccessToken)
+
+	for i, h := range project.Hosts {
+		if h.Id == "" {
+			project.Hosts[i].Id = store.NewId()
+			continue
+		}
+
+		for _, o := range origin.Hosts {
+			if o.Id == h.Id {
+				keep(&project.Hosts[i].Password, o.Password)
+				keep(&project.Hosts[i].PrivateKey, o.PrivateKey)
+			}
+		}
+	}
+
+	// 密钥为空时保持不变
+	for i, v := range project.Variables {
+		if !v.Secret || v.Value != "" {
+			continue
+		}
+
+		for _, o := range origin.Variables {
+			if o.Secret && o.Name == v.Name && o.Environment == v.Environment {
+				project.Variables[i].Value = o.Value
+			}
+		}
+	}
+
+	if err = validate(project); err != nil {
+		return
+	}
+
+	if err = vault.SealVariables(project.Variables); err != nil {
+		return
+	}
+
+	if err = store.Default.UpdateProject(&project); err != nil {
+		err = duplicateName(err, project.Name)
+		return
+	}
+
+	data = public(project)
+}
+
+// 获取项目
+func GetRouter(ctx context.Context) {
+	var (
+		err  error
+		data interface{}
+	)
+
+	defer func() {
+		schema.JSON(ctx, data, nil, err)
+	}()
+
+	project, err := getProject(ctx.Params().Get("id"))
+
+	if err != nil {
+		return
+	}
+
+	data = public(*project)
+}
+
+// 获取项目列表, 支持分页和过滤
+//
+// ?page=0&limit=10&name=xxx&provider=github&repo=github.com/owner
+func ListRouter(ctx context.Context) {
+	var (
+		err  error
+		data interface{}
+		meta *schema.Meta
+	)
+
+	defer func() {
+		schema.JSON(ctx, data, meta, err)
+	}()
+
+	page, limit := schema.Pagination(ctx)
+
+	name := strings.ToLower(ctx.URLParam("name"))
+	provider := ctx.URLParam("provider")
+	repo := strings.ToLower(ctx.URLParam("repo"))
+
+	projects, err := store.Default.ListProjects()
+
+	if err != nil {
+		return
+	}
+
+	filtered := make([]model.Project, 0)
+
+	for _, p := range projects {
+		if name != "" && !strings.Contains(strings.ToLower(p.Name), name) {
+			continue
+		}
+
+		if provider != "" && p.Provider != provider {
+			continue
+		}
+
+		if repo != "" && !strings.Contains(strings.ToLower(p.Repo), repo) {
+			continue
+		}
+
+		filtered = append(filtered, p)
+	}
+
+	list := make([]model.Project, 0)
+
+	start, end := schema.PageRange(page, limit, len(filtered))
+
+	for i := start; i < end; i++ {
+		list = append(list, public(filtered[i]))
+	}
+
+	data = list
+	meta = &schema.Meta{
+		Page:  page,
+		Limit: limit,
+		Num:   len(list),
+		Total: len(filtered),
+	}
+}
+
+// 删除项目
+func DeleteRouter(ctx context.Context) {
+	var (
+		err  error
+		data interface{}
+	)
+
+	defer func() {
+		schema.JSON(ctx, data, nil, err)
+	}()
+
+	project, err := getProject(ctx.Params().Get("id"))
+
+	if err != nil {
+		return
+	}
+
+	if err = store.Default.DeleteProject(project.Id); err != nil {
+		return
+	}
+
+	// 项目的容器在下一次对比时删除
+	if e := hook.ForgetProject(project.Id); e != nil {
+		log.Printf("%+v\n", e)
+	}
+
+	data = public(*project)
+}
@@ -0,0 +1,64 @@
+package project
+
+import (
+	"net/http"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/hook"
+	"github.com/axetroy/hooker/internal/app/schema"
+	"github.com/kataras/iris/v12/context"
+	"github.com/pkg/errors"
+)
+
+// 项目的环境保留的版本, 按照创建时间倒序
+//
+// ?environment=pr-1
+func ReleaseListRouter(ctx context.Context) {
+	var (
+		err  error
+		data interface{}
+	)
+
+	defer func() {
+		schema.JSON(ctx, data, nil, err)
+	}()
+
+	project, err := getProject(ctx.Params().Get("project"))
+
+	if err != nil {
+		return
+	}
+
+	data, err = container.ListReleases(ctx.Request().Context(), project.Id, ctx.URLParam("environment"))
+}
+
+// 部署保留的版本, 不需要重新构建
+//
+// ?environment=pr-1
+func ReleaseDeployRouter(ctx context.Context) {
+	var (
+		err  error
+		data interface{}
+	)
+
+	defer func() {
+		schema.JSON(ctx, data, nil, err)
+	}()
+
+	project, err := getProject(ctx.Params().Get("project"))
+
+	if err != nil {
+		return
+	}
+
+	record, err := hook.DeployRelease(*project, ctx.URLParam("environment"), ctx.Params().Get("commit"))
+
+	switch errors.Cause(err) {
+	case nil:
+		data = record
+	case container.ErrReleaseNotFound:
+		err = schema.NewError(http.StatusNotFound, err.Error())
+	case hook.ErrInvalidEnvironment, container.ErrShortCommit, container.ErrAmbiguousCommit:
+		err = schema.NewError(http.StatusBadRequest, err.Error())
+	}
+}
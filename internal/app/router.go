@@ -4,7 +4,11 @@ package app
 import (
 	"html/template"
 
+	"github.com/axetroy/hooker/internal/app/auth"
+	"github.com/axetroy/hooker/internal/app/delivery"
 	"github.com/axetroy/hooker/internal/app/hook"
+	"github.com/axetroy/hooker/internal/app/project"
+	"github.com/axetroy/hooker/internal/app/workload"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 )
@@ -20,33 +24,62 @@ func init() {
 		//v1.Use(logger.New())
 		{
 			authRouter := v1.Party("/auth")
-			authRouter.Post("/register", func(c context.Context) {}) // 注册帐号
-			authRouter.Post("/login", func(c context.Context) {})    // 登录帐号
+			authRouter.Post("/register", auth.RegisterRouter) // 注册帐号
+			authRouter.Post("/login", auth.LoginRouter)       // 登录帐号
+			authRouter.Post("/logout", auth.LogoutRouter)     // 退出登录
 		}
 
 		{
 			hookRouter := v1.Party("/hook")
-			hookRouter.Post("/{project}", func(context context.Context) {})                 // 触发项目的钩子
-			hookRouter.Post("/github.com", hook.GithubRouter)                               // 单独部署 Github
-			hookRouter.Post("/gitlab.com/{owner}/{repo}", func(context context.Context) {}) // 单独部署 Gitlab
-			hookRouter.Post("/gogs.com/{owner}/{repo}", func(context context.Context) {})   // 单独部署 gogs
-			hookRouter.Post("/gitea.com/{owner}/{repo}", func(context context.Context) {})  // 单独部署 gitea
-			hookRouter.Post("/gitee.com/{owner}/{repo}", func(context context.Context) {})  // 单独部署 Gitee
+			hookRouter.Post("/{project}", hook.ProjectRouter)                // 触发项目的钩子
+			hookRouter.Post("/github.com", hook.GithubRouter)                // 单独部署 Github
+			hookRouter.Post("/gitlab.com/{owner}/{repo}", hook.GitlabRouter) // 单独部署 Gitlab
+			hookRouter.Post("/gitlab", hook.GitlabRouter)                    // 单独部署自建的 Gitlab
+			hookRouter.Post("/gogs.com/{owner}/{repo}", hook.GogsRouter)     // 单独部署 gogs
+			hookRouter.Post("/gogs", hook.GogsRouter)                        // 单独部署自建的 gogs
+			hookRouter.Post("/gitea.com/{owner}/{repo}", hook.GiteaRouter)   // 单独部署 gitea
+			hookRouter.Post("/gitea", hook.GiteaRouter)                      // 单独部署自建的 gitea
+			hookRouter.Post("/gitee.com/{owner}/{repo}", hook.GiteeRouter)   // 单独部署 Gitee
 		}
 
 		{
-			projectRouter := v1.Party("/project")
-			projectRouter.Post("", func(c context.Context) {})        // 创建项目
-			projectRouter.Put("/{id}", func(c context.Context) {})    // 更新项目
-			projectRouter.Get("/{id}", func(c context.Context) {})    // 获取项目
-			projectRouter.Get("/", func(c context.Context) {})        // 获取列表
-			projectRouter.Delete("/{id}", func(c context.Context) {}) // 删除项目
+			projectRouter := v1.Party("/project", auth.Require)
+			projectRouter.Post("", project.CreateRouter)        // 创建项目
+			projectRouter.Put("/{id}", project.UpdateRouter)    // 更新项目
+			projectRouter.Get("/{id}", project.GetRouter)       // 获取项目
+			projectRouter.Get("/", project.ListRouter)          // 获取列表
+			projectRouter.Delete("/{id}", project.DeleteRouter) // 删除项目
 
 			{
 				logRouter := projectRouter.Party("/{project}/log")
-				logRouter.Get("", func(c context.Context) {})      // 项目部署日志列表
-				logRouter.Get("/{id}", func(c context.Context) {}) // 项目部署日志详情
+				logRouter.Get("", project.LogListRouter) // 项目部署日志列表
 			}
+
+			{
+				releaseRouter := projectRouter.Party("/{project}/release")
+				releaseRouter.Get("", project.ReleaseListRouter)             // 项目保留的版本
+				releaseRouter.Post("/{commit}", project.ReleaseDeployRouter) // 部署保留的版本
+			}
+		}
+
+		// 部署状态和评论中的链接指向部署日志详情, 同时支持 cookie
+		v1.Get("/project/{project}/log/{id}", auth.RequireCookie, project.LogDetailRouter) // 项目部署日志详情
+
+		// 实时日志需要支持浏览器的 EventSource, 使用单独的认证方式
+		v1.Get("/project/{project}/log/{id}/stream", auth.RequireStream, project.LogStreamRouter) // 实时获取项目的部署日志
+
+		{
+			deliveryRouter := v1.Party("/delivery", auth.Require)
+			deliveryRouter.Get("/", delivery.ListRouter)               // Web Hook 请求列表
+			deliveryRouter.Get("/{id}", delivery.GetRouter)            // Web Hook 请求详情
+			deliveryRouter.Post("/{id}/replay", delivery.ReplayRouter) // 重新执行 Web Hook 请求
+		}
+
+		{
+			workloadRouter := v1.Party("/workload", auth.Require)
+			workloadRouter.Get("/", workload.ListRouter)                // hooker 管理的容器列表
+			workloadRouter.Get("/drift", workload.DriftRouter)          // 最近一次对比发现的差异
+			workloadRouter.Post("/reconcile", workload.ReconcileRouter) // 立即对比并且处理差异
 		}
 	}
 
@@ -59,7 +92,7 @@ func init() {
 			})
 		})
 
-		app.Get("/", func(c context.Context) {
+		app.Get("/", auth.RequireView, func(c context.Context) {
 			t, _ := template.ParseFiles("./internal/app/views/layout.html", "./internal/app/views/index.html")
 			_ = t.ExecuteTemplate(c.ResponseWriter(), "layout", "Hello world")
 		})
@@ -0,0 +1,45 @@
+package schema
+
+import (
+	"strconv"
+
+	"github.com/kataras/iris/v12/context"
+)
+
+// 每页的默认数量和最大数量
+const (
+	DefaultLimit = 10
+	MaxLimit     = 100
+)
+
+// 解析分页参数 ?page=0&limit=10, 不合法的参数使用默认值
+func Pagination(ctx context.Context) (page int, limit int) {
+	page, _ = strconv.Atoi(ctx.URLParamDefault("page", "0"))
+	limit, _ = strconv.Atoi(ctx.URLParamDefault("limit", strconv.Itoa(DefaultLimit)))
+
+	if page < 0 {
+		page = 0
+	}
+
+	if limit <= 0 || limit > MaxLimit {
+		limit = DefaultLimit
+	}
+
+	return page, limit
+}
+
+// 当前页在列表中的范围 [start, end), 超出列表时为空. 先比较页码, 避免很大的页码溢出
+func PageRange(page int, limit int, total int) (start int, end int) {
+	if page < 0 || limit <= 0 || page > total/limit {
+		return total, total
+	}
+
+	start = page * limit
+	end = start + limit
+
+	if end > total {
+		end = total
+	}
+
+	return start, end
+}
@@ -0,0 +1,35 @@
+package schema
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPageRange(t *testing.T) {
+	tests := []struct {
+		name  string
+		page  int
+		limit int
+		total int
+		start int
+		end   int
+	}{
+		{name: "first page", page: 0, limit: 10, total: 25, start: 0, end: 10},
+		{name: "last page", page: 2, limit: 10, total: 25, start: 20, end: 25},
+		{name: "past the end", page: 3, limit: 10, total: 25, start: 25, end: 25},
+		{name: "exactly the end", page: 1, limit: 10, total: 10, start: 10, end: 10},
+		{name: "empty list", page: 0, limit: 10, total: 0, start: 0, end: 0},
+		{name: "huge page", page: math.MaxInt64 / 10, limit: 100, total: 25, start: 25, end: 25},
+		{name: "negative page", page: -1, limit: 10, total: 25, start: 25, end: 25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := PageRange(tt.page, tt.limit, tt.total)
+
+			if start != tt.start || end != tt.end {
+				t.Errorf("PageRange() = [%d, %d), want [%d, %d)", start, end, tt.start, tt.end)
+			}
+		})
+	}
+}
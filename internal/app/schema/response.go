@@ -0,0 +1,75 @@
+package schema
+
+import (
+	"net/http"
+
+	"github.com/kataras/iris/v12/context"
+	"github.com/pkg/errors"
+)
+
+const (
+	StatusSuccess = 1
+	StatusFail    = -1
+)
+
+// 接口的统一响应格式
+type Response struct {
+	Message string      `json:"message"`        // 错误信息, 成功时为空
+	Data    interface{} `json:"data"`           // 数据
+	Status  int         `json:"status"`         // 状态, 1 为成功, -1 为失败
+	Meta    *Meta       `json:"meta,omitempty"` // 列表的分页信息
+}
+
+// 分页信息
+type Meta struct {
+	Page  int `json:"page"`  // 当前页, 从 0 开始
+	Limit int `json:"limit"` // 每页的数量
+	Num   int `json:"num"`   // 当前页的数量
+	Total int `json:"total"` // 总数
+}
+
+// 带有 HTTP 状态码的错误
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func NewError(code int, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+var (
+	ErrBadRequest   = NewError(http.StatusBadRequest, "bad request")
+	ErrUnauthorized = NewError(http.StatusUnauthorized, "unauthorized")
+	ErrForbidden    = NewError(http.StatusForbidden, "forbidden")
+	ErrNotFound     = NewError(http.StatusNotFound, "not found")
+)
+
+// 输出 JSON 响应, err 不为空时输出错误
+func JSON(ctx context.Context, data interface{}, meta *Meta, err error) {
+	if err != nil {
+		code := http.StatusInternalServerError
+
+		if e, ok := errors.Cause(err).(*Error); ok {
+			code = e.Code
+		}
+
+		ctx.StatusCode(code)
+		_, _ = ctx.JSON(Response{
+			Message: err.Error(),
+			Data:    nil,
+			Status:  StatusFail,
+		})
+		return
+	}
+
+	_, _ = ctx.JSON(Response{
+		Data:   data,
+		Status: StatusSuccess,
+		Meta:   meta,
+	})
+}
@@ -0,0 +1,766 @@
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/pkg/errors"
+)
+
+var idReg = regexp.MustCompile(`^[\w\-.]+$`)
+
+var _ Store = (*FileStore)(nil)
+
+// 基于文件的存储, 不依赖任何数据库, 每条记录保存为一个 JSON 文件
+//
+//	data/version
+//	data/projects/{id}.json
+//	data/hosts/{id}.json
+//	data/users/{id}.json
+//	data/sessions/{id}.json
+//	data/logs/{project}/{id}.json
+//	data/jobs/{id}.json
+//	data/deliveries/{id}.json
+//	data/desired/{project}[.{environment}].json
+type FileStore struct {
+	sync.RWMutex
+	dir string
+}
+
+// 打开数据目录, 并且执行数据迁移
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := migrate(dir); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// 先写入临时文件再重命名, 避免写入一半时程序退出导致文件损坏
+func writeFile(filepath string, data []byte) error {
+	tmp := filepath + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := os.Rename(tmp, filepath); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (s *FileStore) filepath(collection string, id string) (string, error) {
+	if id == "." || id == ".." || !idReg.MatchString(id) {
+		return "", errors.Wrapf(ErrNotFound, "invalid id '%s'", id)
+	}
+
+	return path.Join(s.dir, collection, id+".json"), nil
+}
+
+func (s *FileStore) put(collection string, id string, value interface{}) error {
+	filepath, err := s.filepath(collection, id)
+
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(value, "", "  ")
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := os.MkdirAll(path.Dir(filepath), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return writeFile(filepath, b)
+}
+
+func (s *FileStore) get(collection string, id string, value interface{}) error {
+	filepath, err := s.filepath(collection, id)
+
+	if err != nil {
+		return err
+	}
+
+	b, err := ioutil.ReadFile(filepath)
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(json.Unmarshal(b, value))
+}
+
+func (s *FileStore) exist(collection string, id string) bool {
+	filepath, err := s.filepath(collection, id)
+
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Stat(filepath)
+
+	return err == nil
+}
+
+func (s *FileStore) remove(collection string, id string) error {
+	filepath, err := s.filepath(collection, id)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filepath); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNotFound
+		}
+
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// 遍历集合中的所有记录
+func (s *FileStore) each(collection string, fn func(b []byte) error) error {
+	files, err := ioutil.ReadDir(path.Join(s.dir, collection))
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return errors.WithStack(err)
+	}
+
+	for _, f := range files {
+		if f.IsDir() || path.Ext(f.Name()) != ".json" {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(path.Join(s.dir, collection, f.Name()))
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if err := fn(b); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// 项目名称不区分大小写, 不能与其他项目重复. 需要在写入的锁中检查, 避免同时创建同名的项目
+func (s *FileStore) checkProjectName(project *model.Project) error {
+	if project.Name == "" {
+		return nil
+	}
+
+	return s.each("projects", func(b []byte) error {
+		var p model.Project
+
+		if err := json.Unmarshal(b, &p); err != nil {
+			return err
+		}
+
+		if p.Id != project.Id && strings.EqualFold(p.Name, project.Name) {
+			return errors.Wrapf(ErrDuplicateName, "project '%s'", project.Name)
+		}
+
+		return nil
+	})
+}
+
+func (s *FileStore) CreateProject(project *model.Project) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if project.Id == "" {
+		project.Id = NewId()
+	}
+
+	if s.exist("projects", project.Id) {
+		return errors.Errorf("project '%s' already exists", project.Id)
+	}
+
+	if err := s.checkProjectName(project); err != nil {
+		return err
+	}
+
+	project.CreatedAt = time.Now()
+	project.UpdatedAt = project.CreatedAt
+
+	return s.put("projects", project.Id, project)
+}
+
+func (s *FileStore) UpdateProject(project *model.Project) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.exist("projects", project.Id) {
+		return ErrNotFound
+	}
+
+	if err := s.checkProjectName(project); err != nil {
+		return err
+	}
+
+	project.UpdatedAt = time.Now()
+
+	return s.put("projects", project.Id, project)
+}
+
+func (s *FileStore) GetProject(id string) (*model.Project, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	var project model.Project
+
+	if err := s.get("projects", id, &project); err != nil {
+		return nil, err
+	}
+
+	return &project, nil
+}
+
+func (s *FileStore) ListProjects() ([]model.Project, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	projects := make([]model.Project, 0)
+
+	err := s.each("projects", func(b []byte) error {
+		var project model.Project
+
+		if err := json.Unmarshal(b, &project); err != nil {
+			return err
+		}
+
+		projects = append(projects, project)
+
+		return nil
+	})
+
+	sort.SliceStable(projects, func(i, j int) bool {
+		return projects[i].CreatedAt.Before(projects[j].CreatedAt)
+	})
+
+	return projects, err
+}
+
+func (s *FileStore) DeleteProject(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.remove("projects", id); err != nil {
+		return err
+	}
+
+	// 同时删除项目的部署记录
+	return errors.WithStack(os.RemoveAll(path.Join(s.dir, "logs", id)))
+}
+
+func (s *FileStore) CreateHost(host *model.Host) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if host.Id == "" {
+		host.Id = NewId()
+	}
+
+	if s.exist("hosts", host.Id) {
+		return errors.Errorf("host '%s' already exists", host.Id)
+	}
+
+	host.CreatedAt = time.Now()
+	host.UpdatedAt = host.CreatedAt
+
+	return s.put("hosts", host.Id, host)
+}
+
+func (s *FileStore) UpdateHost(host *model.Host) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.exist("hosts", host.Id) {
+		return ErrNotFound
+	}
+
+	host.UpdatedAt = time.Now()
+
+	return s.put("hosts", host.Id, host)
+}
+
+func (s *FileStore) GetHost(id string) (*model.Host, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	var host model.Host
+
+	if err := s.get("hosts", id, &host); err != nil {
+		return nil, err
+	}
+
+	return &host, nil
+}
+
+func (s *FileStore) ListHosts() ([]model.Host, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	hosts := make([]model.Host, 0)
+
+	err := s.each("hosts", func(b []byte) error {
+		var host model.Host
+
+		if err := json.Unmarshal(b, &host); err != nil {
+			return err
+		}
+
+		hosts = append(hosts, host)
+
+		return nil
+	})
+
+	sort.SliceStable(hosts, func(i, j int) bool {
+		return hosts[i].CreatedAt.Before(hosts[j].CreatedAt)
+	})
+
+	return hosts, err
+}
+
+func (s *FileStore) DeleteHost(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.remove("hosts", id)
+}
+
+func (s *FileStore) CreateUser(user *model.User) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if user.Id == "" {
+		user.Id = NewId()
+	}
+
+	if s.exist("users", user.Id) {
+		return errors.Errorf("user '%s' already exists", user.Id)
+	}
+
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+
+	return s.put("users", user.Id, user)
+}
+
+func (s *FileStore) UpdateUser(user *model.User) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.exist("users", user.Id) {
+		return ErrNotFound
+	}
+
+	user.UpdatedAt = time.Now()
+
+	return s.put("users", user.Id, user)
+}
+
+func (s *FileStore) GetUser(id string) (*model.User, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	var user model.User
+
+	if err := s.get("users", id, &user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (s *FileStore) ListUsers() ([]model.User, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	users := make([]model.User, 0)
+
+	err := s.each("users", func(b []byte) error {
+		var user model.User
+
+		if err := json.Unmarshal(b, &user); err != nil {
+			return err
+		}
+
+		users = append(users, user)
+
+		return nil
+	})
+
+	sort.SliceStable(users, func(i, j int) bool {
+		return users[i].CreatedAt.Before(users[j].CreatedAt)
+	})
+
+	return users, err
+}
+
+func (s *FileStore) DeleteUser(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.remove("users", id)
+}
+
+func (s *FileStore) CreateSession(session *model.Session) error {
+	s.Lock()
+	defer s.Unlock()
+
+	session.CreatedAt = time.Now()
+
+	return s.put("sessions", session.Id, session)
+}
+
+func (s *FileStore) GetSession(id string) (*model.Session, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	var session model.Session
+
+	if err := s.get("sessions", id, &session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (s *FileStore) ListSessions() ([]model.Session, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	sessions := make([]model.Session, 0)
+
+	err := s.each("sessions", func(b []byte) error {
+		var session model.Session
+
+		if err := json.Unmarshal(b, &session); err != nil {
+			return err
+		}
+
+		sessions = append(sessions, session)
+
+		return nil
+	})
+
+	return sessions, err
+}
+
+func (s *FileStore) DeleteSession(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.remove("sessions", id)
+}
+
+func (s *FileStore) CreateLog(log *model.Log) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if log.Id == "" {
+		log.Id = NewId()
+	}
+
+	collection := path.Join("logs", log.ProjectId)
+
+	if log.ProjectId == "" || !idReg.MatchString(log.ProjectId) {
+		return errors.Errorf("invalid project id '%s'", log.ProjectId)
+	}
+
+	if s.exist(collection, log.Id) {
+		return errors.Errorf("log '%s' already exists", log.Id)
+	}
+
+	log.CreatedAt = time.Now()
+
+	return s.put(collection, log.Id, log)
+}
+
+func (s *FileStore) UpdateLog(log *model.Log) error {
+	s.Lock()
+	defer s.Unlock()
+
+	collection := path.Join("logs", log.ProjectId)
+
+	if log.ProjectId == "" || !idReg.MatchString(log.ProjectId) || !s.exist(collection, log.Id) {
+		return ErrNotFound
+	}
+
+	return s.put(collection, log.Id, log)
+}
+
+func (s *FileStore) GetLog(projectId string, id string) (*model.Log, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if projectId == "" || !idReg.MatchString(projectId) {
+		return nil, ErrNotFound
+	}
+
+	var log model.Log
+
+	if err := s.get(path.Join("logs", projectId), id, &log); err != nil {
+		return nil, err
+	}
+
+	return &log, nil
+}
+
+// 获取项目的部署记录, 最新的记录在前
+func (s *FileStore) ListLogs(projectId string) ([]model.Log, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	logs := make([]model.Log, 0)
+
+	if projectId == "" || !idReg.MatchString(projectId) {
+		return logs, nil
+	}
+
+	err := s.each(path.Join("logs", projectId), func(b []byte) error {
+		var log model.Log
+
+		if err := json.Unmarshal(b, &log); err != nil {
+			return err
+		}
+
+		logs = append(logs, log)
+
+		return nil
+	})
+
+	sort.SliceStable(logs, func(i, j int) bool {
+		return logs[i].CreatedAt.After(logs[j].CreatedAt)
+	})
+
+	return logs, err
+}
+
+func (s *FileStore) DeleteLog(projectId string, id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if projectId == "" || !idReg.MatchString(projectId) {
+		return ErrNotFound
+	}
+
+	return s.remove(path.Join("logs", projectId), id)
+}
+
+func (s *FileStore) CreateJob(job *model.Job) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if job.Id == "" {
+		job.Id = NewId()
+	}
+
+	if s.exist("jobs", job.Id) {
+		return errors.Errorf("job '%s' already exists", job.Id)
+	}
+
+	job.CreatedAt = time.Now()
+
+	return s.put("jobs", job.Id, job)
+}
+
+// 获取所有的部署任务, 最早的任务在前
+func (s *FileStore) ListJobs() ([]model.Job, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	jobs := make([]model.Job, 0)
+
+	err := s.each("jobs", func(b []byte) error {
+		var job model.Job
+
+		if err := json.Unmarshal(b, &job); err != nil {
+			return err
+		}
+
+		jobs = append(jobs, job)
+
+		return nil
+	})
+
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+
+	return jobs, err
+}
+
+func (s *FileStore) DeleteJob(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.remove("jobs", id)
+}
+
+func (s *FileStore) CreateDelivery(delivery *model.Delivery) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if delivery.Id == "" {
+		delivery.Id = NewId()
+	}
+
+	if s.exist("deliveries", delivery.Id) {
+		return errors.Errorf("delivery '%s' already exists", delivery.Id)
+	}
+
+	delivery.CreatedAt = time.Now()
+
+	return s.put("deliveries", delivery.Id, delivery)
+}
+
+func (s *FileStore) UpdateDelivery(delivery *model.Delivery) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.exist("deliveries", delivery.Id) {
+		return ErrNotFound
+	}
+
+	return s.put("deliveries", delivery.Id, delivery)
+}
+
+func (s *FileStore) GetDelivery(id string) (*model.Delivery, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	var delivery model.Delivery
+
+	if err := s.get("deliveries", id, &delivery); err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// 获取所有的 Web Hook 请求, 最新的请求在前
+func (s *FileStore) ListDeliveries() ([]model.Delivery, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	deliveries := make([]model.Delivery, 0)
+
+	err := s.each("deliveries", func(b []byte) error {
+		var delivery model.Delivery
+
+		if err := json.Unmarshal(b, &delivery); err != nil {
+			return err
+		}
+
+		deliveries = append(deliveries, delivery)
+
+		return nil
+	})
+
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt)
+	})
+
+	return deliveries, err
+}
+
+func (s *FileStore) DeleteDelivery(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.remove("deliveries", id)
+}
+
+// 期望运行的版本的 ID, 默认环境为项目 ID, 其他环境为 项目 ID.环境
+func desiredId(projectId string, environment string) string {
+	if environment == "" {
+		return projectId
+	}
+
+	return projectId + "." + environment
+}
+
+func (s *FileStore) PutDesired(desired *model.Desired) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if desired.ProjectId == "" || !idReg.MatchString(desired.ProjectId) {
+		return errors.Errorf("invalid project id '%s'", desired.ProjectId)
+	}
+
+	desired.UpdatedAt = time.Now()
+
+	return s.put("desired", desiredId(desired.ProjectId, desired.Environment), desired)
+}
+
+// 获取所有环境期望运行的版本
+func (s *FileStore) ListDesired() ([]model.Desired, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	list := make([]model.Desired, 0)
+
+	err := s.each("desired", func(b []byte) error {
+		var desired model.Desired
+
+		if err := json.Unmarshal(b, &desired); err != nil {
+			return err
+		}
+
+		list = append(list, desired)
+
+		return nil
+	})
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return desiredId(list[i].ProjectId, list[i].Environment) < desiredId(list[j].ProjectId, list[j].Environment)
+	})
+
+	return list, err
+}
+
+func (s *FileStore) DeleteDesired(projectId string, environment string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if projectId == "" || !idReg.MatchString(projectId) {
+		return ErrNotFound
+	}
+
+	return s.remove("desired", desiredId(projectId, environment))
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
@@ -0,0 +1,386 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/pkg/errors"
+)
+
+func newTestStore(t *testing.T) (*FileStore, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	s, err := NewFileStore(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, dir
+}
+
+func TestFileStoreProject(t *testing.T) {
+	s, dir := newTestStore(t)
+
+	project := model.Project{Id: "blog", Name: "blog", Repo: "github.com/axetroy/blog"}
+
+	if err := s.CreateProject(&project); err != nil {
+		t.Fatal(err)
+	}
+
+	if project.CreatedAt.IsZero() || !project.UpdatedAt.Equal(project.CreatedAt) {
+		t.Errorf("CreateProject() created_at = %v, updated_at = %v", project.CreatedAt, project.UpdatedAt)
+	}
+
+	if err := s.CreateProject(&model.Project{Id: "blog"}); err == nil {
+		t.Error("CreateProject() with existing id error = nil")
+	}
+
+	project.Name = "my blog"
+
+	if err := s.UpdateProject(&project); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新打开数据目录, 确认数据已经写入文件
+	reopened, err := NewFileStore(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := reopened.GetProject("blog")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Name != "my blog" || got.Repo != project.Repo {
+		t.Errorf("GetProject() = %+v", got)
+	}
+
+	generated := model.Project{Name: "generated"}
+
+	if err := s.CreateProject(&generated); err != nil {
+		t.Fatal(err)
+	}
+
+	if generated.Id == "" {
+		t.Error("CreateProject() did not generate id")
+	}
+
+	list, err := s.ListProjects()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 2 || list[0].Id != "blog" || list[1].Id != generated.Id {
+		t.Errorf("ListProjects() = %+v, want blog before %s", list, generated.Id)
+	}
+
+	if err := s.CreateLog(&model.Log{ProjectId: "blog"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DeleteProject("blog"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.GetProject("blog"); err != ErrNotFound {
+		t.Errorf("GetProject() after delete error = %v, want %v", err, ErrNotFound)
+	}
+
+	if _, err := os.Stat(path.Join(dir, "logs", "blog")); !os.IsNotExist(err) {
+		t.Errorf("DeleteProject() did not remove logs, stat error = %v", err)
+	}
+
+	if err := s.DeleteProject("blog"); err != ErrNotFound {
+		t.Errorf("DeleteProject() twice error = %v, want %v", err, ErrNotFound)
+	}
+
+	if err := s.UpdateProject(&model.Project{Id: "missing"}); err != ErrNotFound {
+		t.Errorf("UpdateProject() missing error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestFileStoreProjectName(t *testing.T) {
+	s, _ := newTestStore(t)
+
+	// 同时创建同名的项目只有一个成功
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		created  int
+		conflict int
+	)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			err := s.CreateProject(&model.Project{Name: "Blog"})
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err == nil {
+				created++
+			} else if errors.Cause(err) == ErrDuplicateName {
+				conflict++
+			} else {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if created != 1 || conflict != 9 {
+		t.Errorf("created = %d, conflict = %d, want 1 and 9", created, conflict)
+	}
+
+	docs := model.Project{Name: "docs"}
+
+	if err := s.CreateProject(&docs); err != nil {
+		t.Fatal(err)
+	}
+
+	docs.Name = "BLOG"
+
+	if err := s.UpdateProject(&docs); errors.Cause(err) != ErrDuplicateName {
+		t.Errorf("UpdateProject() to an existing name error = %v, want %v", err, ErrDuplicateName)
+	}
+
+	// 项目保留自己的名称
+	docs.Name = "docs"
+
+	if err := s.UpdateProject(&docs); err != nil {
+		t.Errorf("UpdateProject() error = %v", err)
+	}
+}
+
+func TestFileStoreInvalidId(t *testing.T) {
+	s, _ := newTestStore(t)
+
+	for _, id := range []string{"", ".", "..", "../users/admin", "a/b", "a b"} {
+		if _, err := s.GetProject(id); errors.Cause(err) != ErrNotFound {
+			t.Errorf("GetProject(%q) error = %v, want %v", id, err, ErrNotFound)
+		}
+	}
+
+	if err := s.CreateLog(&model.Log{ProjectId: "../projects"}); err == nil {
+		t.Error("CreateLog() with invalid project id error = nil")
+	}
+
+	if _, err := s.GetLog("..", "x"); err != ErrNotFound {
+		t.Errorf("GetLog() with invalid project id error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestFileStoreUserAndSession(t *testing.T) {
+	s, _ := newTestStore(t)
+
+	user := model.User{Username: "admin"}
+
+	if err := s.CreateUser(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	session := model.Session{Id: "token", UserId: user.Id}
+
+	if err := s.CreateSession(&session); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetSession("token")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.UserId != user.Id {
+		t.Errorf("GetSession() user = %s, want %s", got.UserId, user.Id)
+	}
+
+	if err := s.DeleteSession("token"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.GetSession("token"); err != ErrNotFound {
+		t.Errorf("GetSession() after delete error = %v, want %v", err, ErrNotFound)
+	}
+
+	if err := s.DeleteUser(user.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if users, err := s.ListUsers(); err != nil || len(users) != 0 {
+		t.Errorf("ListUsers() = %v, %v, want empty", users, err)
+	}
+}
+
+func TestFileStoreLog(t *testing.T) {
+	s, _ := newTestStore(t)
+
+	first := model.Log{ProjectId: "blog", Commit: "a"}
+	second := model.Log{ProjectId: "blog", Commit: "b"}
+
+	for _, l := range []*model.Log{&first, &second} {
+		if err := s.CreateLog(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	second.Status = model.StatusSuccess
+
+	if err := s.UpdateLog(&second); err != nil {
+		t.Fatal(err)
+	}
+
+	logs, err := s.ListLogs("blog")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(logs) != 2 || logs[0].Id != second.Id || logs[0].Status != model.StatusSuccess {
+		t.Errorf("ListLogs() = %+v, want newest first", logs)
+	}
+
+	if err := s.UpdateLog(&model.Log{ProjectId: "blog", Id: "missing"}); err != ErrNotFound {
+		t.Errorf("UpdateLog() missing error = %v, want %v", err, ErrNotFound)
+	}
+
+	if err := s.DeleteLog("blog", first.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.GetLog("blog", first.Id); err != ErrNotFound {
+		t.Errorf("GetLog() after delete error = %v, want %v", err, ErrNotFound)
+	}
+
+	if logs, err := s.ListLogs("other"); err != nil || len(logs) != 0 {
+		t.Errorf("ListLogs() of project without logs = %v, %v", logs, err)
+	}
+}
+
+func TestFileStoreDesired(t *testing.T) {
+	s, dir := newTestStore(t)
+
+	for _, env := range []string{"", "pr-1"} {
+		if err := s.PutDesired(&model.Desired{ProjectId: "blog", Environment: env, Commit: "a"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 同一个环境覆盖之前的记录
+	if err := s.PutDesired(&model.Desired{ProjectId: "blog", Commit: "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"blog.json", "blog.pr-1.json"} {
+		if _, err := os.Stat(path.Join(dir, "desired", name)); err != nil {
+			t.Errorf("desired file %s: %v", name, err)
+		}
+	}
+
+	list, err := s.ListDesired()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 2 || list[0].Environment != "" || list[0].Commit != "b" || list[1].Environment != "pr-1" {
+		t.Errorf("ListDesired() = %+v", list)
+	}
+
+	if err := s.DeleteDesired("blog", "pr-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.DeleteDesired("blog", "pr-1"); err != ErrNotFound {
+		t.Errorf("DeleteDesired() twice error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestFileStoreIgnoreTemporaryFiles(t *testing.T) {
+	s, dir := newTestStore(t)
+
+	if err := s.CreateJob(&model.Job{Id: "job", ProjectId: "blog"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 写入一半时程序退出留下的临时文件不会被读取
+	if err := ioutil.WriteFile(path.Join(dir, "jobs", "broken.json.tmp"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := s.ListJobs()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(jobs) != 1 || jobs[0].Id != "job" {
+		t.Errorf("ListJobs() = %+v, want only job", jobs)
+	}
+
+	if _, err := os.Stat(path.Join(dir, "jobs", "job.json.tmp")); !os.IsNotExist(err) {
+		t.Errorf("temporary file of job left, stat error = %v", err)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	file := path.Join(dir, "record.json")
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"create", `{"id": "a"}`},
+		{"replace", `{"id": "b"}`},
+		{"shorter", `{}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := writeFile(file, []byte(tt.content)); err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := ioutil.ReadFile(file)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(b) != tt.content {
+				t.Errorf("content = %s, want %s", b, tt.content)
+			}
+
+			info, err := os.Stat(file)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+			}
+
+			if _, err := os.Stat(file + ".tmp"); !os.IsNotExist(err) {
+				t.Errorf("temporary file left, stat error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// 数据迁移, 第 N 个迁移执行后数据的版本号为 N + 1
+// 只能在末尾追加新的迁移, 不能修改已经发布的迁移
+var migrations = []func(dir string) error{
+	// 初始化数据目录
+	func(dir string) error {
+		for _, collection := range []string{"projects", "hosts", "users", "logs"} {
+			if err := os.MkdirAll(path.Join(dir, collection), 0755); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		return nil
+	},
+	// 登录会话
+	func(dir string) error {
+		return errors.WithStack(os.MkdirAll(path.Join(dir, "sessions"), 0755))
+	},
+	// 部署任务队列
+	func(dir string) error {
+		return errors.WithStack(os.MkdirAll(path.Join(dir, "jobs"), 0755))
+	},
+	// Web Hook 请求
+	func(dir string) error {
+		return errors.WithStack(os.MkdirAll(path.Join(dir, "deliveries"), 0755))
+	},
+	// 环境期望运行的版本
+	func(dir string) error {
+		return errors.WithStack(os.MkdirAll(path.Join(dir, "desired"), 0755))
+	},
+}
+
+// 获取当前数据的版本号
+func version(dir string) (int, error) {
+	b, err := ioutil.ReadFile(path.Join(dir, "version"))
+
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+
+		return 0, errors.WithStack(err)
+	}
+
+	v, err := strconv.Atoi(strings.TrimSpace(string(b)))
+
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return v, nil
+}
+
+// 执行未执行过的迁移
+func migrate(dir string) error {
+	current, err := version(dir)
+
+	if err != nil {
+		return err
+	}
+
+	if current > len(migrations) {
+		return errors.Errorf("the version of data is %d, but hooker only supports %d, please upgrade hooker", current, len(migrations))
+	}
+
+	for i := current; i < len(migrations); i++ {
+		if err := migrations[i](dir); err != nil {
+			return errors.Wrapf(err, "migrate to version %d", i+1)
+		}
+
+		if err := writeFile(path.Join(dir, "version"), []byte(strconv.Itoa(i+1))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	latest := strconv.Itoa(len(migrations))
+	all := []string{"projects", "hosts", "users", "logs", "sessions", "jobs", "deliveries", "desired"}
+
+	tests := []struct {
+		name    string
+		files   map[string]string // 迁移之前数据目录中的文件
+		created []string          // 迁移之后应该存在的集合
+		wantErr bool
+	}{
+		{
+			name:    "empty directory",
+			created: all,
+		},
+		{
+			name: "directory without version",
+			files: map[string]string{
+				"projects/blog.json": `{"id": "blog", "repo": "github.com/axetroy/blog"}`,
+			},
+			created: all,
+		},
+		{
+			name: "version 1",
+			files: map[string]string{
+				"version":                "1",
+				"projects/blog.json":     `{"id": "blog", "repo": "github.com/axetroy/blog"}`,
+				"logs/blog/1.json":       `{"id": "1", "project_id": "blog", "status": "success"}`,
+				"users/admin.json":       `{"id": "admin", "username": "admin", "admin": true}`,
+				"hosts/.gitkeep":         "",
+				"projects/blog.json.tmp": `{"id": "bl`,
+			},
+			created: all,
+		},
+		{
+			name: "version with trailing newline",
+			files: map[string]string{
+				"version": "3\n",
+			},
+			created: []string{"deliveries", "desired"},
+		},
+		{
+			name: "latest version",
+			files: map[string]string{
+				"version": latest,
+			},
+		},
+		{
+			name: "newer version",
+			files: map[string]string{
+				"version": strconv.Itoa(len(migrations) + 1),
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid version",
+			files: map[string]string{
+				"version": "v1",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			for name, content := range tt.files {
+				file := path.Join(dir, name)
+
+				if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s, err := NewFileStore(dir)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewFileStore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			b, err := ioutil.ReadFile(path.Join(dir, "version"))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.TrimSpace(string(b)) != latest {
+				t.Errorf("version = %s, want %s", b, latest)
+			}
+
+			for _, collection := range tt.created {
+				if info, err := os.Stat(path.Join(dir, collection)); err != nil || !info.IsDir() {
+					t.Errorf("collection %s: %v", collection, err)
+				}
+			}
+
+			// 迁移之前的数据保持不变
+			for name, content := range tt.files {
+				if name == "version" {
+					continue
+				}
+
+				b, err := ioutil.ReadFile(path.Join(dir, name))
+
+				if err != nil || string(b) != content {
+					t.Errorf("file %s = %s, %v, want %s", name, b, err, content)
+				}
+			}
+
+			if _, ok := tt.files["projects/blog.json"]; ok {
+				project, err := s.GetProject("blog")
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if project.Repo != "github.com/axetroy/blog" {
+					t.Errorf("GetProject() repo = %s", project.Repo)
+				}
+
+				if projects, err := s.ListProjects(); err != nil || len(projects) != 1 {
+					t.Errorf("ListProjects() = %v, %v, want only blog", projects, err)
+				}
+			}
+
+			if _, ok := tt.files["logs/blog/1.json"]; ok {
+				if logs, err := s.ListLogs("blog"); err != nil || len(logs) != 1 || logs[0].Id != "1" {
+					t.Errorf("ListLogs() = %v, %v", logs, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateOnce(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := NewFileStore(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// 已经执行过的迁移不会再次执行, 删除的目录不会被重新创建
+	if err := os.Remove(path.Join(dir, "desired")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewFileStore(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(path.Join(dir, "desired")); !os.IsNotExist(err) {
+		t.Errorf("migration executed twice, stat error = %v", err)
+	}
+}
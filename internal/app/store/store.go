@@ -0,0 +1,84 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNotFound      = errors.New("record not found")
+	ErrDuplicateName = errors.New("name already exists")
+)
+
+// 数据存储
+type Store interface {
+	// 项目
+	CreateProject(project *model.Project) error
+	UpdateProject(project *model.Project) error
+	GetProject(id string) (*model.Project, error)
+	ListProjects() ([]model.Project, error)
+	DeleteProject(id string) error
+
+	// 服务器
+	CreateHost(host *model.Host) error
+	UpdateHost(host *model.Host) error
+	GetHost(id string) (*model.Host, error)
+	ListHosts() ([]model.Host, error)
+	DeleteHost(id string) error
+
+	// 用户
+	CreateUser(user *model.User) error
+	UpdateUser(user *model.User) error
+	GetUser(id string) (*model.User, error)
+	ListUsers() ([]model.User, error)
+	DeleteUser(id string) error
+
+	// 登录会话
+	CreateSession(session *model.Session) error
+	GetSession(id string) (*model.Session, error)
+	ListSessions() ([]model.Session, error)
+	DeleteSession(id string) error
+
+	// 部署记录
+	CreateLog(log *model.Log) error
+	UpdateLog(log *model.Log) error
+	GetLog(projectId string, id string) (*model.Log, error)
+	ListLogs(projectId string) ([]model.Log, error)
+	DeleteLog(projectId string, id string) error
+
+	// 部署任务队列
+	CreateJob(job *model.Job) error
+	ListJobs() ([]model.Job, error)
+	DeleteJob(id string) error
+
+	// Web Hook 请求
+	CreateDelivery(delivery *model.Delivery) error
+	UpdateDelivery(delivery *model.Delivery) error
+	GetDelivery(id string) (*model.Delivery, error)
+	ListDeliveries() ([]model.Delivery, error)
+	DeleteDelivery(id string) error
+
+	// 环境期望运行的版本
+	PutDesired(desired *model.Desired) error
+	ListDesired() ([]model.Desired, error)
+	DeleteDesired(projectId string, environment string) error
+
+	Close() error
+}
+
+// 默认的存储, 在程序启动时初始化
+var Default Store
+
+// 生成随机的 ID
+func NewId() string {
+	b := make([]byte, 12)
+
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
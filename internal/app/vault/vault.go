@@ -0,0 +1,165 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/pkg/errors"
+)
+
+// 加密之后的值的前缀, 没有前缀的值视为明文
+const prefix = "enc:"
+
+var ErrNoKey = errors.New("encryption key is not initialized")
+
+var (
+	mu  sync.RWMutex
+	gcm cipher.AEAD
+)
+
+// 初始化加密的密钥, key 为空时使用数据目录中的 secret.key, 不存在则自动生成
+func Init(dataDir string, key string) error {
+	if key == "" {
+		file := path.Join(dataDir, "secret.key")
+
+		b, err := ioutil.ReadFile(file)
+
+		if os.IsNotExist(err) {
+			random := make([]byte, 32)
+
+			if _, err := io.ReadFull(rand.Reader, random); err != nil {
+				return errors.WithStack(err)
+			}
+
+			b = []byte(hex.EncodeToString(random))
+
+			if err := ioutil.WriteFile(file, b, 0600); err != nil {
+				return errors.WithStack(err)
+			}
+		} else if err != nil {
+			return errors.WithStack(err)
+		}
+
+		key = strings.TrimSpace(string(b))
+	}
+
+	sum := sha256.Sum256([]byte(key))
+
+	block, err := aes.NewCipher(sum[:])
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	mu.Lock()
+	gcm = aead
+	mu.Unlock()
+
+	return nil
+}
+
+func aead() (cipher.AEAD, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if gcm == nil {
+		return nil, ErrNoKey
+	}
+
+	return gcm, nil
+}
+
+// 是否为加密之后的值
+func Encrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// 加密, 已经加密的值保持不变
+func Encrypt(plain string) (string, error) {
+	if plain == "" || Encrypted(plain) {
+		return plain, nil
+	}
+
+	g, err := aead()
+
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, g.NonceSize())
+
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	sealed := g.Seal(nonce, nonce, []byte(plain), nil)
+
+	return prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// 解密, 没有加密的值保持不变
+func Decrypt(value string) (string, error) {
+	if !Encrypted(value) {
+		return value, nil
+	}
+
+	g, err := aead()
+
+	if err != nil {
+		return "", err
+	}
+
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, prefix))
+
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	if len(b) < g.NonceSize() {
+		return "", errors.New("invalid encrypted value")
+	}
+
+	plain, err := g.Open(nil, b[:g.NonceSize()], b[g.NonceSize():], nil)
+
+	if err != nil {
+		return "", errors.Wrap(err, "can not decrypt the value, the encryption key may be changed")
+	}
+
+	return string(plain), nil
+}
+
+// 加密项目中的密钥变量
+func SealVariables(variables []model.Variable) error {
+	for i, v := range variables {
+		if !v.Secret {
+			continue
+		}
+
+		value, err := Encrypt(v.Value)
+
+		if err != nil {
+			return err
+		}
+
+		variables[i].Value = value
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package vault
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/axetroy/hooker/internal/app/model"
+	"github.com/pkg/errors"
+)
+
+func reset() {
+	mu.Lock()
+	gcm = nil
+	mu.Unlock()
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	defer reset()
+
+	if err := Init(t.TempDir(), "my-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := Encrypt("password")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !Encrypted(sealed) || strings.Contains(sealed, "password") {
+		t.Fatalf("Encrypt() = %s", sealed)
+	}
+
+	// 每次加密使用不同的 nonce
+	if again, _ := Encrypt("password"); again == sealed {
+		t.Error("Encrypt() returns the same value twice")
+	}
+
+	if again, _ := Encrypt(sealed); again != sealed {
+		t.Error("Encrypt() changed an encrypted value")
+	}
+
+	plain, err := Decrypt(sealed)
+
+	if err != nil || plain != "password" {
+		t.Errorf("Decrypt() = %s, %v", plain, err)
+	}
+
+	if plain, err := Decrypt("plain"); err != nil || plain != "plain" {
+		t.Errorf("Decrypt() of plain value = %s, %v", plain, err)
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	defer reset()
+
+	if err := Init(t.TempDir(), "my-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := Encrypt("password")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, prefix))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b[len(b)-1] ^= 1
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "modified ciphertext", value: prefix + base64.StdEncoding.EncodeToString(b)},
+		{name: "invalid base64", value: prefix + "!!!"},
+		{name: "too short", value: prefix + base64.StdEncoding.EncodeToString([]byte("abc"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if plain, err := Decrypt(tt.value); err == nil {
+				t.Errorf("Decrypt() = %s, want error", plain)
+			}
+		})
+	}
+
+	// 更换密钥之后无法解密
+	if err := Init(t.TempDir(), "other-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if plain, err := Decrypt(sealed); err == nil {
+		t.Errorf("Decrypt() with another key = %s, want error", plain)
+	}
+}
+
+func TestNoKey(t *testing.T) {
+	reset()
+
+	if _, err := Encrypt("password"); errors.Cause(err) != ErrNoKey {
+		t.Errorf("Encrypt() error = %v, want ErrNoKey", err)
+	}
+
+	if _, err := Decrypt(prefix + "abc"); errors.Cause(err) != ErrNoKey {
+		t.Errorf("Decrypt() error = %v, want ErrNoKey", err)
+	}
+}
+
+func TestInitKeyFile(t *testing.T) {
+	defer reset()
+
+	dir := t.TempDir()
+
+	if err := Init(dir, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path.Join(dir, "secret.key"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("secret.key mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	sealed, err := Encrypt("password")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 重启之后使用同一个密钥文件
+	reset()
+
+	if err := Init(dir, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if plain, err := Decrypt(sealed); err != nil || plain != "password" {
+		t.Errorf("Decrypt() after restart = %s, %v", plain, err)
+	}
+
+	b, _ := ioutil.ReadFile(path.Join(dir, "secret.key"))
+
+	if len(strings.TrimSpace(string(b))) != 64 {
+		t.Errorf("secret.key = %q, want 32 random bytes in hex", b)
+	}
+}
+
+func TestSealVariables(t *testing.T) {
+	defer reset()
+
+	if err := Init(t.TempDir(), "my-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	variables := []model.Variable{
+		{Name: "LOG_LEVEL", Value: "info"},
+		{Name: "TOKEN", Value: "secret-token", Secret: true},
+	}
+
+	if err := SealVariables(variables); err != nil {
+		t.Fatal(err)
+	}
+
+	if variables[0].Value != "info" {
+		t.Errorf("plain variable = %s, want unchanged", variables[0].Value)
+	}
+
+	if !Encrypted(variables[1].Value) {
+		t.Fatalf("secret variable = %s, want encrypted", variables[1].Value)
+	}
+
+	if plain, err := Decrypt(variables[1].Value); err != nil || plain != "secret-token" {
+		t.Errorf("Decrypt() = %s, %v", plain, err)
+	}
+}
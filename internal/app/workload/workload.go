@@ -0,0 +1,114 @@
+package workload
+
+import (
+	"sort"
+
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/hook"
+	"github.com/axetroy/hooker/internal/app/schema"
+	"github.com/kataras/iris/v12/context"
+)
+
+// hooker 管理的容器列表, 类似于 docker ps
+//
+// ?project=xxx&environment=pr-1&state=running
+func ListRouter(ctx context.Context) {
+	var (
+		err  error
+		data interface{}
+	)
+
+	defer func() {
+		schema.JSON(ctx, data, nil, err)
+	}()
+
+	environment, filterEnvironment := ctx.URLParams()["environment"]
+	state := ctx.URLParam("state")
+
+	workloads, err := container.ListWorkloads(ctx.Request().Context(), ctx.URLParam("project"))
+
+	if err != nil {
+		return
+	}
+
+	list := make([]container.Workload, 0, len(workloads))
+
+	for _, w := range workloads {
+		if filterEnvironment && w.Environment != environment {
+			continue
+		}
+
+		if state != "" && w.State != state {
+			continue
+		}
+
+		list = append(list, w)
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Project != list[j].Project {
+			return list[i].Project < list[j].Project
+		}
+
+		return list[i].Environment < list[j].Environment
+	})
+
+	data = list
+}
+
+// 最近一次对比期望的状态和实际的容器的结果
+//
+// ?project=xxx
+func DriftRouter(ctx context.Context) {
+	var (
+		err  error
+		data interface{}
+	)
+
+	defer func() {
+		schema.JSON(ctx, data, nil, err)
+	}()
+
+	data = filterDrifts(hook.LastReconciliation(), ctx.URLParam("project"))
+}
+
+// 立即对比期望的状态和实际的容器, 并且处理差异
+//
+// ?project=xxx
+func ReconcileRouter(ctx context.Context) {
+	var (
+		err  error
+		data interface{}
+	)
+
+	defer func() {
+		schema.JSON(ctx, data, nil, err)
+	}()
+
+	result, err := hook.Reconcile(ctx.Request().Context())
+
+	if err != nil {
+		return
+	}
+
+	data = filterDrifts(*result, ctx.URLParam("project"))
+}
+
+// 只保留项目的差异, project 为空则不过滤
+func filterDrifts(result hook.Reconciliation, project string) hook.Reconciliation {
+	if project == "" {
+		return result
+	}
+
+	drifts := make([]hook.Drift, 0, len(result.Drifts))
+
+	for _, d := range result.Drifts {
+		if d.Project == project {
+			drifts = append(drifts, d)
+		}
+	}
+
+	result.Drifts = drifts
+
+	return result
+}
@@ -10,19 +10,53 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/axetroy/hooker/internal/app"
+	"github.com/axetroy/hooker/internal/app/auth"
+	"github.com/axetroy/hooker/internal/app/container"
+	"github.com/axetroy/hooker/internal/app/deploy"
+	"github.com/axetroy/hooker/internal/app/forge"
+	"github.com/axetroy/hooker/internal/app/hook"
+	"github.com/axetroy/hooker/internal/app/project"
+	"github.com/axetroy/hooker/internal/app/store"
+	"github.com/axetroy/hooker/internal/app/vault"
 	"github.com/pkg/errors"
 )
 
 func main() {
 	var (
-		port int64 = 3000
+		port              int64 = 3000
+		secret                  = os.Getenv("HOOKER_SECRET")
+		secretFile              = os.Getenv("HOOKER_SECRET_FILE")
+		projectFile             = os.Getenv("HOOKER_PROJECT_FILE")
+		projectOverwrite        = os.Getenv("HOOKER_PROJECT_OVERWRITE") == "true"
+		dataDir                 = os.Getenv("HOOKER_DATA")
+		adminUsername           = os.Getenv("HOOKER_ADMIN_USERNAME")
+		adminPassword           = os.Getenv("HOOKER_ADMIN_PASSWORD")
+		publicURL               = os.Getenv("HOOKER_PUBLIC_URL")
+		encryptionKey           = os.Getenv("HOOKER_ENCRYPTION_KEY")
+		mountRoot               = os.Getenv("HOOKER_MOUNT_ROOT")
+		gitlabHost              = os.Getenv("HOOKER_GITLAB_HOST")
+		logMaxCount             = deploy.Retention.MaxCount
+		logMaxAge               = deploy.Retention.MaxAge
+		deliveryMaxCount        = deploy.Retention.MaxDeliveries
+		concurrency             = deploy.Concurrency
+		debounce                = deploy.Debounce
+		reconcileInterval       = hook.ReconcileInterval
 	)
 
+	if dataDir == "" {
+		dataDir = "data"
+	}
+
+	if adminUsername == "" {
+		adminUsername = "admin"
+	}
+
 	if len(os.Getenv("PORT")) > 0 {
 		portStr := os.Getenv("PORT")
 
@@ -37,14 +71,105 @@ func main() {
 
 	flag.Int64Var(&port, "port", port, "The port listening, use with '--port 8080'")
 
+	flag.StringVar(&secret, "secret", secret, "The global secret of web hook, use with '--secret xxx'")
+	flag.StringVar(&secretFile, "secret-file", secretFile, "The JSON file of secret for each repository, use with '--secret-file secrets.json'")
+	flag.StringVar(&projectFile, "project-file", projectFile, "The JSON file of projects, use with '--project-file projects.json'")
+	flag.BoolVar(&projectOverwrite, "project-overwrite", projectOverwrite, "Overwrite existing projects with the project file, changes made through the API are lost on every start")
+	flag.StringVar(&dataDir, "data", dataDir, "The directory of data, use with '--data ./data'")
+	flag.StringVar(&encryptionKey, "encryption-key", encryptionKey, "The key to encrypt secrets of projects, use the generated key in the data directory if empty")
+	flag.StringVar(&mountRoot, "mount-root", mountRoot, "The host directories allowed to mount into containers, separated by comma, use with '--mount-root /srv/hooker'")
+	flag.StringVar(&gitlabHost, "gitlab-host", gitlabHost, "The host of self-hosted gitlab, used to clone repositories deployed by '/v1/hook/gitlab', use with '--gitlab-host gitlab.example.com'")
+	flag.StringVar(&publicURL, "public-url", publicURL, "The public URL of hooker, used for links of deployment reported to the forge, use with '--public-url https://hooker.example.com'")
+
+	flag.StringVar(&adminUsername, "admin-username", adminUsername, "The username of admin account created on first run, use with '--admin-username admin'")
+	flag.StringVar(&adminPassword, "admin-password", adminPassword, "The password of admin account created on first run, generate randomly if empty")
+
+	flag.IntVar(&logMaxCount, "log-max-count", logMaxCount, "The max count of deploy logs kept for each project, 0 for unlimited")
+	flag.DurationVar(&logMaxAge, "log-max-age", logMaxAge, "The max age of deploy logs, 0 for unlimited, use with '--log-max-age 720h'")
+	flag.IntVar(&deliveryMaxCount, "delivery-max-count", deliveryMaxCount, "The max count of web hook deliveries kept, 0 for unlimited")
+
+	flag.IntVar(&concurrency, "concurrency", concurrency, "The number of deployments running at the same time")
+	flag.DurationVar(&debounce, "debounce", debounce, "The time to wait before deploying, pushes of the same project within it are merged into one deployment")
+	flag.DurationVar(&reconcileInterval, "reconcile-interval", reconcileInterval, "The interval to restore containers that should be running and remove orphan containers, 0 to disable")
+
 	flag.Parse()
 
+	deploy.Retention.MaxCount = logMaxCount
+	deploy.Retention.MaxAge = logMaxAge
+	deploy.Retention.MaxDeliveries = deliveryMaxCount
+	deploy.Concurrency = concurrency
+	deploy.Debounce = debounce
+	deploy.Credentials = hook.TaskCredentials
+	hook.ReconcileInterval = reconcileInterval
+	forge.PublicURL = publicURL
+	hook.GitlabHost = gitlabHost
+	container.DataDir = dataDir
+
+	for _, root := range strings.Split(mountRoot, ",") {
+		if root = strings.TrimSpace(root); root != "" {
+			container.MountRoots = append(container.MountRoots, root)
+		}
+	}
+
+	if db, err := store.NewFileStore(dataDir); err != nil {
+		log.Fatalf("%+v\n", err)
+	} else {
+		store.Default = db
+	}
+
+	defer func() {
+		_ = store.Default.Close()
+	}()
+
+	if err := vault.Init(dataDir, encryptionKey); err != nil {
+		log.Fatalf("%+v\n", err)
+	}
+
+	if err := auth.Bootstrap(adminUsername, adminPassword); err != nil {
+		log.Fatalf("%+v\n", err)
+	}
+
+	if err := auth.PruneSessions(); err != nil {
+		log.Printf("%+v\n", err)
+	}
+
+	if err := deploy.PruneAll(); err != nil {
+		log.Printf("%+v\n", err)
+	}
+
+	hook.Secrets.SetGlobal(secret)
+
+	if secretFile != "" {
+		if err := hook.Secrets.LoadFile(secretFile); err != nil {
+			log.Fatalf("%+v\n", err)
+		}
+	}
+
+	if projectFile != "" {
+		if err := project.ImportProjects(projectFile, projectOverwrite); err != nil {
+			log.Fatalf("%+v\n", err)
+		}
+	}
+
+	// 项目和密钥加载之后才开始部署和对比, 否则导入的项目的容器会被视为孤儿容器
+	if err := deploy.Start(); err != nil {
+		log.Fatalf("%+v\n", err)
+	}
+
+	// 容器的重启由 hooker 处理, 重启之后需要重新监控, Docker 不可用时不影响启动
+	if err := hook.Resupervise(context.Background()); err != nil {
+		log.Printf("%+v\n", err)
+	}
+
+	hook.StartReconcile()
+
 	s := &http.Server{
 		Addr:           net.JoinHostPort("0.0.0.0", fmt.Sprintf("%d", port)),
 		Handler:        app.Router,
 		ReadTimeout:    60 * time.Second,
 		WriteTimeout:   60 * time.Second,
 		MaxHeaderBytes: 1 << 20, // 10M
+		ConnContext:    project.ConnContext,
 	}
 
 	var wg sync.WaitGroup